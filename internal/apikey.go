@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// APIKeyResolver resolves the OpenAI API key from the config.
+// The key is resolved lazily on the first call of Resolve, so that commands that do not send
+// any API requests never run the key command or read the key file.
+//
+// The sources are checked in the following order:
+//
+//  1. openai_api_key
+//  2. openai_api_key_file
+//  3. openai_api_key_command
+//  4. the environment variable named by openai_api_key_env
+//  5. the OPENAI_API_KEY environment variable
+type APIKeyResolver struct {
+	Config   *Config
+	key      string
+	resolved bool
+	lock     sync.Mutex
+}
+
+func NewAPIKeyResolver(config *Config) *APIKeyResolver {
+	return &APIKeyResolver{
+		Config: config,
+	}
+}
+
+// Resolve returns the API key. It returns an empty string if no key is configured.
+func (r *APIKeyResolver) Resolve() (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.resolved {
+		return r.key, nil
+	}
+
+	key, err := r.resolve()
+	if err != nil {
+		return "", err
+	}
+	r.key = key
+	r.resolved = true
+	return key, nil
+}
+
+func (r *APIKeyResolver) resolve() (string, error) {
+	c := r.Config
+	if c.OpenAIAPIKey != "" {
+		return c.OpenAIAPIKey, nil
+	}
+
+	if c.OpenAIAPIKeyFile != "" {
+		return readSecretFile("openai_api_key_file", c.OpenAIAPIKeyFile)
+	}
+
+	if c.OpenAIAPIKeyCommand != "" {
+		return runSecretCommand("openai_api_key_command", c.OpenAIAPIKeyCommand)
+	}
+
+	if c.OpenAIAPIKeyEnv != "" {
+		key := os.Getenv(c.OpenAIAPIKeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("environment variable '%s' specified by openai_api_key_env is empty", c.OpenAIAPIKeyEnv)
+		}
+		return key, nil
+	}
+
+	return os.Getenv("OPENAI_API_KEY"), nil
+}
+
+// readSecretFile reads a secret such as a key from the file specified by the config key.
+func readSecretFile(configKey string, path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", configKey, err)
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("%s '%s' is empty", configKey, path)
+	}
+	return secret, nil
+}
+
+// runSecretCommand runs the command specified by the config key and returns its output as a secret.
+func runSecretCommand(configKey string, command string) (string, error) {
+	stdout := &bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", configKey, err)
+	}
+	secret := strings.TrimSpace(stdout.String())
+	if secret == "" {
+		return "", fmt.Errorf("%s returned an empty key", configKey)
+	}
+	return secret, nil
+}
+
+// apiKeyTransport is an http.RoundTripper that sets the API key resolved by the APIKeyResolver
+// to the Authorization header.
+type apiKeyTransport struct {
+	Resolver *APIKeyResolver
+	Base     http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := t.Resolver.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		// RoundTrip must not modify the request, so we use a copy of it.
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return t.Base.RoundTrip(req)
+}
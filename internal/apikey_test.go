@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIKeyResolver_Resolve(t *testing.T) {
+	t.Run("plain text", func(t *testing.T) {
+		c := NewConfig()
+		c.OpenAIAPIKey = "sk-plain"
+		c.OpenAIAPIKeyCommand = "echo sk-command"
+		key, err := NewAPIKeyResolver(c).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "sk-plain", key)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		c := NewConfig()
+		c.OpenAIAPIKeyFile = testTempFile(t, []byte("sk-file\n")).Name()
+		key, err := NewAPIKeyResolver(c).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "sk-file", key)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		c := NewConfig()
+		c.OpenAIAPIKeyCommand = "echo sk-command"
+		key, err := NewAPIKeyResolver(c).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "sk-command", key)
+	})
+
+	t.Run("failed command", func(t *testing.T) {
+		c := NewConfig()
+		c.OpenAIAPIKeyCommand = "exit 1"
+		_, err := NewAPIKeyResolver(c).Resolve()
+		assert.Error(t, err)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("GPTX_TEST_OPENAI_API_KEY", "sk-env")
+		c := NewConfig()
+		c.OpenAIAPIKeyEnv = "GPTX_TEST_OPENAI_API_KEY"
+		key, err := NewAPIKeyResolver(c).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "sk-env", key)
+	})
+
+	t.Run("OPENAI_API_KEY", func(t *testing.T) {
+		t.Setenv("OPENAI_API_KEY", "sk-default-env")
+		key, err := NewAPIKeyResolver(NewConfig()).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "sk-default-env", key)
+	})
+
+	t.Run("resolved only once", func(t *testing.T) {
+		counter := filepath.Join(t.TempDir(), "counter")
+		c := NewConfig()
+		c.OpenAIAPIKeyCommand = "echo x >> " + counter + " && echo sk-command"
+		r := NewAPIKeyResolver(c)
+		for i := 0; i < 3; i++ {
+			key, err := r.Resolve()
+			assert.NoError(t, err)
+			assert.Equal(t, "sk-command", key)
+		}
+		b, err := os.ReadFile(counter)
+		assert.NoError(t, err)
+		assert.Equal(t, "x\n", string(b))
+	})
+}
+
+func TestAPIKeyTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer sk-command", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := NewConfig()
+	c.OpenAIAPIKeyCommand = "echo sk-command"
+	client, err := newHTTPClient(c)
+	assert.NoError(t, err)
+	resp, err := client.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
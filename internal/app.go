@@ -15,14 +15,28 @@ func NewApp(r *Repository) *cli.App {
 	app.Usage = "An extensible command-line utility powered by ChatGPT"
 	app.Copyright = "Copyright (c) 2023 Kohki Makimoto"
 	app.Commands = []*cli.Command{
+		ArchiveCommand,
+		AuditCommand,
+		CacheCommand,
 		ChatCommand,
 		CleanCommand,
+		CompactCommand,
 		ConfigCommand,
+		DBCommand,
 		DeleteCommand,
+		ExportCommand,
+		ImportCommand,
+		IndexCommand,
 		InitCommand,
 		InspectCommand,
 		ListCommand,
+		MockServerCommand,
+		PinCommand,
 		RenameCommand,
+		SearchCommand,
+		StarCommand,
+		TagCommand,
+		TitleCommand,
 		VersionCommand,
 	}
 
@@ -0,0 +1,473 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The kinds of the audited requests.
+const (
+	AuditKindChat       = "chat"
+	AuditKindTitle      = "title"
+	AuditKindCompaction = "compaction"
+	AuditKindEmbedding  = "embedding"
+)
+
+// The cache statuses of the audited requests.
+const (
+	AuditCacheHit         = "hit"          // The response was returned from the cache, so nothing was sent
+	AuditCacheSemanticHit = "semantic_hit" // The response of a similar prompt was returned from the semantic cache
+	AuditCacheMiss        = "miss"         // The response was not cached, so the request was sent
+	AuditCacheRefresh     = "refresh"      // The cached response was not used because of --refresh-cache, so the request was sent
+	AuditCacheOff         = "off"          // The cache was not used
+)
+
+// auditHashField is the last field of every line of the audit log. See sealAuditEntry.
+var auditHashField = []byte(`,"hash":"`)
+
+// AuditEntry is a line of the audit log.
+type AuditEntry struct {
+	Seq            uint64                         `json:"seq"`
+	Time           time.Time                      `json:"time"`
+	Kind           string                         `json:"kind"`
+	Model          string                         `json:"model"`
+	Endpoint       string                         `json:"endpoint"`
+	ConversationId uint64                         `json:"conversation_id,omitempty"`
+	RequestHash    string                         `json:"request_hash"`
+	Cache          string                         `json:"cache"`
+	Hooks          []string                       `json:"hooks,omitempty"`
+	Similarity     float64                        `json:"similarity,omitempty"` // The similarity of the prompt of the response returned from the semantic cache
+	Usage          *openai.Usage                  `json:"usage,omitempty"`
+	Error          string                         `json:"error,omitempty"`
+	Messages       []openai.ChatCompletionMessage `json:"messages,omitempty"` // The messages sent to the API. Only recorded if include_prompts is enabled
+	Keyed          bool                           `json:"keyed,omitempty"`    // If true, the hash is the HMAC with the key derived from the encryption secret
+	PrevHash       string                         `json:"prev_hash"`
+	Hash           string                         `json:"hash,omitempty"`
+}
+
+// AuditLogTamperedError is returned when the hash chain of the audit log is broken.
+type AuditLogTamperedError struct {
+	Line   int
+	Reason string
+}
+
+func (e *AuditLogTamperedError) Error() string {
+	return fmt.Sprintf("the audit log has been tampered with at line %d: %s", e.Line, e.Reason)
+}
+
+// AuditLog is an append-only JSONL file that records the requests to the API.
+// Every line has the hash of the previous line, so that modified or removed lines can be detected by VerifyAuditLog.
+type AuditLog struct {
+	Path           string
+	IncludePrompts bool
+	// Key is the key of the HMAC of the hashes. It is derived from the encryption secret by deriveAuditKey.
+	// If it is nil, the hashes are plain SHA-256, so anyone who can edit the log can also rewrite the hash chain.
+	Key []byte
+}
+
+// deriveAuditKey derives the key of the audit log from the encryption secret.
+func deriveAuditKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("gptx audit log"))
+	return mac.Sum(nil)
+}
+
+// auditHash returns the hash of the body of an entry. It is the HMAC if the key is given.
+func auditHash(key []byte, body []byte) string {
+	if key == nil {
+		sum := sha256.Sum256(body)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Append appends the entry to the audit log. It sets the sequence number and the hashes of the entry.
+func (l *AuditLog) Append(e *AuditEntry) error {
+	// the lock serializes the processes that append to the log, so that the hash chain is not forked
+	unlock, err := lockAuditLog(l.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	last, err := readLastLine(f)
+	if err != nil {
+		return err
+	}
+	e.Seq = 1
+	e.PrevHash = ""
+	if len(last) > 0 {
+		prev := &AuditEntry{}
+		if err := json.Unmarshal(last, prev); err != nil {
+			return fmt.Errorf("failed to read the last entry of the audit log: %w", err)
+		}
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+
+	line, err := sealAuditEntry(e, l.Key)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	return err
+}
+
+// lockAuditLog takes the exclusive lock of the audit log, and returns the function to release it.
+// The lock is taken on a separate lock file, so that ResealAuditLog can replace the log while holding the lock.
+func lockAuditLog(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
+
+// sealAuditEntry returns the line of the entry.
+// The hash is the auditHash of the JSON without the hash field, and it is appended as the last field of the line.
+func sealAuditEntry(e *AuditEntry, key []byte) ([]byte, error) {
+	e.Hash = ""
+	e.Keyed = key != nil
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	e.Hash = auditHash(key, body)
+
+	line := make([]byte, 0, len(body)+len(auditHashField)+len(e.Hash)+3)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, auditHashField...)
+	line = append(line, e.Hash...)
+	line = append(line, '"', '}', '\n')
+	return line, nil
+}
+
+// openAuditLine parses a line of the audit log and checks its hash. The keyed entries are checked with the key.
+func openAuditLine(line []byte, key []byte) (*AuditEntry, error) {
+	i := bytes.LastIndex(line, auditHashField)
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, errors.New("the hash is missing")
+	}
+	e := &AuditEntry{}
+	if err := json.Unmarshal(line, e); err != nil {
+		return nil, err
+	}
+	if !e.Keyed {
+		key = nil
+	} else if key == nil {
+		return nil, ErrAuditKeyRequired
+	}
+	body := append(append([]byte{}, line[:i]...), '}')
+	if !hmac.Equal([]byte(auditHash(key, body)), line[i+len(auditHashField):len(line)-2]) {
+		return nil, errors.New("the hash does not match the content")
+	}
+	return e, nil
+}
+
+// readLastLine returns the last line of the file without the newline.
+func readLastLine(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := fi.Size()
+	// skip the trailing newline
+	if end > 0 {
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, end-1); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			end--
+		}
+	}
+
+	const chunkSize = 4096
+	var line []byte
+	for pos := end; pos > 0; {
+		n := int64(chunkSize)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		buf := make([]byte, n)
+		if _, err := f.ReadAt(buf, pos); err != nil {
+			return nil, err
+		}
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			return append(buf[i+1:], line...), nil
+		}
+		line = append(buf, line...)
+	}
+	return line, nil
+}
+
+// ReadAuditLog calls fn for each entry of the audit log in order.
+// It does not verify the hashes. Use VerifyAuditLog for it.
+func ReadAuditLog(path string, fn func(e *AuditEntry) error) error {
+	return readAuditLines(path, func(n int, line []byte) error {
+		e := &AuditEntry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			return fmt.Errorf("invalid entry at line %d of the audit log: %w", n, err)
+		}
+		return fn(e)
+	})
+}
+
+// ErrAuditKeyRequired is returned when the audit log has keyed entries, but the encryption secret is not configured.
+var ErrAuditKeyRequired = errors.New("the audit log is keyed with the encryption secret, so the encryption must be configured to verify it")
+
+// AuditLogSummary is the result of VerifyAuditLog.
+type AuditLogSummary struct {
+	Entries  int    // The number of the entries
+	Unkeyed  int    // The number of the entries that are not keyed. They were recorded without the encryption secret
+	LastHash string // The hash of the last entry
+}
+
+// VerifyAuditLog checks the hash chain of the audit log with the key of the AuditLog.
+// The entries recorded without the key are only accepted before the keyed entries.
+// Removing the latest entries can not be detected by the log itself, so keep the last hash somewhere else to detect it.
+func VerifyAuditLog(path string, key []byte) (*AuditLogSummary, error) {
+	summary := &AuditLogSummary{}
+	var prev *AuditEntry
+	err := readAuditLines(path, func(n int, line []byte) error {
+		e, err := openAuditLine(line, key)
+		if err == ErrAuditKeyRequired {
+			return err
+		}
+		if err != nil {
+			return &AuditLogTamperedError{Line: n, Reason: err.Error()}
+		}
+		if !e.Keyed {
+			if key != nil && prev != nil && prev.Keyed {
+				return &AuditLogTamperedError{Line: n, Reason: "the entry is not keyed, but the previous entry is keyed"}
+			}
+			summary.Unkeyed++
+		}
+		if prev == nil {
+			if e.PrevHash != "" {
+				return &AuditLogTamperedError{Line: n, Reason: "the first entry has a previous hash"}
+			}
+		} else {
+			if e.PrevHash != prev.Hash {
+				return &AuditLogTamperedError{Line: n, Reason: "the previous hash does not match the previous entry"}
+			}
+			if e.Seq != prev.Seq+1 {
+				return &AuditLogTamperedError{Line: n, Reason: fmt.Sprintf("the sequence number %d does not follow %d", e.Seq, prev.Seq)}
+			}
+		}
+		prev = e
+		summary.Entries++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		summary.LastHash = prev.Hash
+	}
+	return summary, nil
+}
+
+// ResealAuditLog verifies the audit log with the old key, and replaces the hashes of the keyed entries with the ones of the new key.
+// It is used when the encryption secret is changed. The hash of the last entry changes, so it returns the new summary.
+// The resealed log is written to a temporary file and replaces the log, so the log is left as it is if it fails.
+func ResealAuditLog(path string, oldKey []byte, newKey []byte) (*AuditLogSummary, error) {
+	unlock, err := lockAuditLog(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &AuditLogSummary{}, nil
+	}
+	if _, err := VerifyAuditLog(path, oldKey); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	prevHash := ""
+	if err := ReadAuditLog(path, func(e *AuditEntry) error {
+		key := newKey
+		if !e.Keyed {
+			// the entries recorded before the encryption was configured are kept as they are
+			key = nil
+		}
+		e.PrevHash = prevHash
+		line, err := sealAuditEntry(e, key)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		prevHash = e.Hash
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := replaceFile(path, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write the resealed audit log: %w", err)
+	}
+	return VerifyAuditLog(path, newKey)
+}
+
+// replaceFile writes the content to a temporary file in the same directory, and renames it to the path.
+func replaceFile(path string, content []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// readAuditLines calls fn for each non-empty line of the audit log. A missing log has no lines.
+func readAuditLines(path string, fn func(n int, line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			if err := fn(n, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// requestHash returns the hash of the request. It is also used as the cache key.
+func requestHash(req openai.ChatCompletionRequest) ([]byte, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	sha := sha256.Sum256(b)
+	return sha[:], nil
+}
+
+// newAuditEntry returns an entry of a request for the conversation. The conversation can be nil.
+func newAuditEntry(kind string, cache string, co *Conversation) *AuditEntry {
+	e := &AuditEntry{
+		Kind:  kind,
+		Cache: cache,
+	}
+	if co != nil {
+		e.ConversationId = co.Id
+		// the hooks only process the chat messages
+		if kind == AuditKindChat {
+			e.Hooks = co.Hooks
+		}
+	}
+	return e
+}
+
+// audit records the request to the audit log. It does nothing if the audit log is disabled.
+// resp is nil if the request was not sent or failed.
+func (c *ChatService) audit(e *AuditEntry, req openai.ChatCompletionRequest, resp *openai.ChatCompletionResponse, reqErr error) error {
+	if c.AuditLog == nil {
+		return nil
+	}
+	hash, err := requestHash(req)
+	if err != nil {
+		return err
+	}
+	e.Time = time.Now().UTC()
+	e.Model = req.Model
+	e.Endpoint = c.ClientConfig.BaseURL
+	e.RequestHash = hex.EncodeToString(hash)
+	if resp != nil {
+		usage := resp.Usage
+		e.Usage = &usage
+	}
+	if reqErr != nil {
+		e.Error = reqErr.Error()
+	}
+	if c.AuditLog.IncludePrompts {
+		e.Messages = req.Messages
+	}
+	if err := c.AuditLog.Append(e); err != nil {
+		return fmt.Errorf("failed to write the audit log: %w", err)
+	}
+	return nil
+}
+
+// createChatCompletion sends the request to the API and records it to the audit log.
+func (c *ChatService) createChatCompletion(ctx context.Context, e *AuditEntry, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	client := openai.NewClientWithConfig(c.ClientConfig)
+	c.spinnerStart()
+	resp, err := client.CreateChatCompletion(ctx, req)
+	c.spinnerStop()
+	if err != nil {
+		if aerr := c.audit(e, req, nil, err); aerr != nil {
+			return resp, fmt.Errorf("%s: %s", err.Error(), aerr.Error())
+		}
+		return resp, err
+	}
+	if err := c.audit(e, req, &resp, nil); err != nil {
+		return resp, err
+	}
+	if len(resp.Choices) == 0 {
+		return resp, errors.New("the API returned no choices")
+	}
+	return resp, nil
+}
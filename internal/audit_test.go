@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAppendAuditEntries(t *testing.T, l *AuditLog, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		e := &AuditEntry{
+			Time:        time.Now().UTC(),
+			Kind:        AuditKindChat,
+			Model:       "test-model",
+			RequestHash: "hash",
+			Cache:       AuditCacheMiss,
+			// a long entry that is larger than a chunk of readLastLine
+			Error: strings.Repeat("x", 5000),
+		}
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAuditLog_Append(t *testing.T) {
+	l := &AuditLog{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+	testAppendAuditEntries(t, l, 3)
+
+	var entries []*AuditEntry
+	err := ReadAuditLog(l.Path, func(e *AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, uint64(1), entries[0].Seq)
+	assert.Equal(t, "", entries[0].PrevHash)
+	assert.Equal(t, uint64(3), entries[2].Seq)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+
+	summary, err := VerifyAuditLog(l.Path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &AuditLogSummary{Entries: 3, Unkeyed: 3, LastHash: entries[2].Hash}, summary)
+
+	info, err := os.Stat(l.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	newLog := func(t *testing.T) (*AuditLog, [][]byte) {
+		l := &AuditLog{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+		testAppendAuditEntries(t, l, 3)
+		b, err := os.ReadFile(l.Path)
+		assert.NoError(t, err)
+		return l, bytes.SplitAfter(bytes.TrimRight(b, "\n"), []byte("\n"))
+	}
+
+	t.Run("missing log", func(t *testing.T) {
+		summary, err := VerifyAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, &AuditLogSummary{}, summary)
+	})
+
+	t.Run("modified entry", func(t *testing.T) {
+		l, lines := newLog(t)
+		lines[1] = bytes.Replace(lines[1], []byte(`"model":"test-model"`), []byte(`"model":"other-model"`), 1)
+		assert.NoError(t, os.WriteFile(l.Path, bytes.Join(lines, nil), 0600))
+		_, err := VerifyAuditLog(l.Path, nil)
+		assert.Equal(t, &AuditLogTamperedError{Line: 2, Reason: "the hash does not match the content"}, err)
+	})
+
+	t.Run("removed entry", func(t *testing.T) {
+		l, lines := newLog(t)
+		assert.NoError(t, os.WriteFile(l.Path, bytes.Join([][]byte{lines[0], lines[2]}, nil), 0600))
+		_, err := VerifyAuditLog(l.Path, nil)
+		assert.Equal(t, &AuditLogTamperedError{Line: 2, Reason: "the previous hash does not match the previous entry"}, err)
+	})
+
+	t.Run("removed first entry", func(t *testing.T) {
+		l, lines := newLog(t)
+		assert.NoError(t, os.WriteFile(l.Path, bytes.Join(lines[1:], nil), 0600))
+		_, err := VerifyAuditLog(l.Path, nil)
+		assert.Equal(t, &AuditLogTamperedError{Line: 1, Reason: "the first entry has a previous hash"}, err)
+	})
+}
+
+func TestVerifyAuditLog_Keyed(t *testing.T) {
+	key := deriveAuditKey("secret")
+	l := &AuditLog{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+	// the entries recorded before the encryption was configured are not keyed
+	testAppendAuditEntries(t, l, 1)
+	l.Key = key
+	testAppendAuditEntries(t, l, 2)
+
+	summary, err := VerifyAuditLog(l.Path, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Entries)
+	assert.Equal(t, 1, summary.Unkeyed)
+
+	_, err = VerifyAuditLog(l.Path, nil)
+	assert.Equal(t, ErrAuditKeyRequired, err)
+	_, err = VerifyAuditLog(l.Path, deriveAuditKey("other secret"))
+	assert.Equal(t, &AuditLogTamperedError{Line: 2, Reason: "the hash does not match the content"}, err)
+
+	b, err := os.ReadFile(l.Path)
+	assert.NoError(t, err)
+	lines := bytes.SplitAfter(bytes.TrimRight(b, "\n"), []byte("\n"))
+
+	t.Run("rewritten without the key", func(t *testing.T) {
+		// the plain SHA-256 chain can be recomputed by anyone who can edit the log
+		var buf bytes.Buffer
+		prevHash := ""
+		for _, line := range lines {
+			e := &AuditEntry{}
+			assert.NoError(t, json.Unmarshal(line, e))
+			e.Model = "other-model"
+			e.PrevHash = prevHash
+			sealed, err := sealAuditEntry(e, nil)
+			assert.NoError(t, err)
+			buf.Write(sealed)
+			prevHash = e.Hash
+		}
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+
+		// it is accepted only as unkeyed entries
+		summary, err := VerifyAuditLog(path, key)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, summary.Unkeyed)
+
+		// an unkeyed entry can not follow a keyed entry
+		assert.NoError(t, os.WriteFile(path, append(bytes.Join(lines[:2], nil), bytes.SplitAfter(buf.Bytes(), []byte("\n"))[2]...), 0600))
+		_, err = VerifyAuditLog(path, key)
+		assert.Error(t, err)
+	})
+
+	t.Run("reseal", func(t *testing.T) {
+		newKey := deriveAuditKey("new secret")
+		_, err := ResealAuditLog(l.Path, deriveAuditKey("other secret"), newKey)
+		assert.Error(t, err)
+
+		summary, err := ResealAuditLog(l.Path, key, newKey)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, summary.Entries)
+		assert.Equal(t, 1, summary.Unkeyed)
+
+		_, err = VerifyAuditLog(l.Path, key)
+		assert.Error(t, err)
+		verified, err := VerifyAuditLog(l.Path, newKey)
+		assert.NoError(t, err)
+		assert.Equal(t, summary, verified)
+
+		// the new entries are chained to the resealed entries
+		l.Key = newKey
+		testAppendAuditEntries(t, l, 1)
+		verified, err = VerifyAuditLog(l.Path, newKey)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, verified.Entries)
+
+		// the log is replaced by the resealed file, and no temporary file is left
+		matches, err := filepath.Glob(l.Path + ".*.tmp")
+		assert.NoError(t, err)
+		assert.Empty(t, matches)
+		info, err := os.Stat(l.Path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte("old\n"), 0600))
+	assert.NoError(t, replaceFile(path, []byte("new\n")))
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(b))
+
+	// the original is left as it is if it can not be replaced
+	target := filepath.Join(dir, "target")
+	assert.NoError(t, os.MkdirAll(filepath.Join(target, "child"), 0700))
+	assert.Error(t, replaceFile(target, []byte("new\n")))
+	assert.DirExists(t, filepath.Join(target, "child"))
+	matches, err := filepath.Glob(target + ".*.tmp")
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v2"
+	"strings"
+	"time"
+)
+
+var AuditCommand = &cli.Command{
+	Name:  "audit",
+	Usage: "Query the audit log of the requests to the API",
+	Description: `Display the entries of the audit log (audit.jsonl in the gptx home directory).
+Every request to the API made by the chat, title and compact commands is recorded with the model, the conversation,
+the hash of the request, the token usage, the cache status and the hooks. Responses returned from the cache are
+recorded with the "hit" cache status because nothing is sent to the API.
+Every entry has the hash of the previous entry. Use --verify to check that the log has not been modified.
+If the database is encrypted, the hashes are keyed with the encryption secret, so the log can not be rewritten without the secret.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "Filter the entries recorded since the `time` (e.g. 7d, 12h, 2006-01-02 or RFC3339)",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "Filter the entries recorded until the `time`",
+		},
+		&cli.StringFlag{
+			Name:    "conversation",
+			Aliases: []string{"c"},
+			Usage:   "Filter the entries of the conversation `id`",
+		},
+		&cli.StringFlag{
+			Name:  "model",
+			Usage: "Filter the entries of the `model`",
+		},
+		&cli.StringFlag{
+			Name:  "kind",
+			Usage: "Filter the entries of the `kind` (chat, title, compaction or embedding)",
+		},
+		&cli.StringFlag{
+			Name:  "cache",
+			Usage: "Filter the entries of the cache `status` (hit, semantic_hit, miss, refresh or off)",
+		},
+		&cli.IntFlag{
+			Name:    "limit",
+			Aliases: []string{"n"},
+			Usage:   "Display only the latest `number` of entries. 0 displays all the entries",
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Specify an output `format` (table or json). json outputs an entry per line",
+			Value:   "table",
+		},
+		&cli.BoolFlag{
+			Name:               "verify",
+			Usage:              "Verify the hash chain of the audit log instead of displaying the entries",
+			DisableDefaultText: true,
+		},
+	},
+	Action: auditAction,
+}
+
+var auditAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	path := r.PathResolver.AuditLogFilePath()
+	if c.Bool("verify") {
+		summary, err := VerifyAuditLog(path, r.AuditKey)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(c.App.Writer, "Verified %d entries.\n", summary.Entries)
+		if summary.Unkeyed > 0 {
+			_, _ = fmt.Fprintf(c.App.Writer, "%d entries are not keyed with the encryption secret. Anyone who can edit the log can rewrite them with their hashes.\n", summary.Unkeyed)
+		}
+		if summary.Entries > 0 {
+			_, _ = fmt.Fprintf(c.App.Writer, "Last hash: %s\n", summary.LastHash)
+		}
+		return nil
+	}
+
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid format: %s", format)
+	}
+	since, err := getTimeValueFromStringFlag(c, "since")
+	if err != nil {
+		return err
+	}
+	until, err := getTimeValueFromStringFlag(c, "until")
+	if err != nil {
+		return err
+	}
+	conversationId, err := getUint64ValueFromStringFlag(c, "conversation")
+	if err != nil {
+		return err
+	}
+	model := c.String("model")
+	kind := c.String("kind")
+	cache := c.String("cache")
+
+	var entries []*AuditEntry
+	if err := ReadAuditLog(path, func(e *AuditEntry) error {
+		switch {
+		case since != nil && e.Time.Before(*since),
+			until != nil && e.Time.After(*until),
+			conversationId != 0 && e.ConversationId != conversationId,
+			model != "" && e.Model != model,
+			kind != "" && e.Kind != kind,
+			cache != "" && e.Cache != cache:
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if limit := c.Int("limit"); limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	if format == "json" {
+		for _, e := range entries {
+			buf, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(c.App.Writer, string(buf))
+		}
+		return nil
+	}
+
+	t := NewSimpleTableWriter(c.App.Writer)
+	t.AppendHeader(table.Row{
+		"SEQ",
+		"TIME",
+		"KIND",
+		"MODEL",
+		"CONVERSATION",
+		"CACHE",
+		"TOKENS",
+		"HOOKS",
+		"REQUEST",
+	})
+	for _, e := range entries {
+		conversation := ""
+		if e.ConversationId != 0 {
+			conversation = fmt.Sprintf("%d", e.ConversationId)
+		}
+		tokens := ""
+		if e.Usage != nil {
+			tokens = fmt.Sprintf("%d", e.Usage.TotalTokens)
+		}
+		if e.Error != "" {
+			tokens = "error"
+		}
+		hash := e.RequestHash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		t.AppendRow([]interface{}{
+			e.Seq,
+			e.Time.Local().Format(time.RFC3339),
+			e.Kind,
+			e.Model,
+			conversation,
+			e.Cache,
+			tokens,
+			strings.Join(e.Hooks, ", "),
+			hash,
+		})
+	}
+	t.Render()
+	return nil
+})
@@ -1,10 +1,16 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/sashabaranov/go-openai"
 	bolt "go.etcd.io/bbolt"
+	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,7 +18,27 @@ import (
 const (
 	CacheBucketCaches = "caches"
 
+	// CacheBucketOrder holds the keys by the order of use. The first key is the least recently used one.
 	CacheBucketOrder = "order"
+
+	// CacheBucketRecency holds the sequence numbers of the keys in the order bucket.
+	CacheBucketRecency = "recency"
+
+	// CacheBucketItems holds the metadata of the cached responses (CacheItem) by the same keys as the caches bucket.
+	CacheBucketItems = "items"
+
+	// CacheBucketStats holds the counters of the cache hits and misses, and the total size of the cached responses.
+	CacheBucketStats = "stats"
+
+	// CacheBucketEmbeddings holds the embeddings of the prompts of the cached responses for the semantic cache.
+	CacheBucketEmbeddings = "embeddings"
+)
+
+var (
+	cacheStatsKeyHits         = []byte("hits")
+	cacheStatsKeyMisses       = []byte("misses")
+	cacheStatsKeyBytes        = []byte("bytes")
+	cacheStatsKeySemanticHits = []byte("semantic_hits")
 )
 
 // CacheItemNotFoundError is an error that is returned when the cache is not found.
@@ -29,8 +55,17 @@ func (e *CacheItemNotFoundError) Error() string {
 type CacheManager struct {
 	DBPath    string
 	MaxLength int
-	cache     *Cache
-	lock      sync.RWMutex
+	// MaxSize is the maximum total size of the cached responses in bytes. 0 means unlimited.
+	MaxSize int64
+	// TTL is the default time to live of the cached responses. 0 means that they never expire.
+	TTL time.Duration
+	// KeyResolver resolves the secret to open the encrypted cache database.
+	KeyResolver *EncryptionKeyResolver
+	// Encrypt encrypts the cache database when it is initialized if it is not encrypted yet.
+	Encrypt bool
+	cache   *Cache
+	cipher  *ValueCipher
+	lock    sync.RWMutex
 }
 
 func (m *CacheManager) Open() (*Cache, error) {
@@ -53,7 +88,10 @@ func (m *CacheManager) Open() (*Cache, error) {
 		m:         m,
 		db:        db,
 		maxLength: m.MaxLength,
+		maxSize:   m.MaxSize,
+		ttl:       m.TTL,
 	}
+	registerValueCipher(db, m.cipher)
 
 	return m.cache, nil
 }
@@ -92,6 +130,8 @@ type Cache struct {
 	m         *CacheManager
 	db        *bolt.DB
 	maxLength int
+	maxSize   int64
+	ttl       time.Duration
 }
 
 func (c *Cache) Close() error {
@@ -99,6 +139,7 @@ func (c *Cache) Close() error {
 		// already closed
 		return nil
 	}
+	registerValueCipher(c.db, nil)
 	err := c.db.Close()
 	if err != nil {
 		return err
@@ -116,69 +157,340 @@ func (c *Cache) Init() error {
 		if _, err := tx.CreateBucketIfNotExists([]byte(CacheBucketOrder)); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(CacheBucketRecency)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(CacheBucketItems)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(CacheBucketStats)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(CacheBucketEmbeddings)); err != nil {
+			return err
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
+
+	vc, err := unlockDB(c.db, c.m.KeyResolver)
+	if err != nil {
+		return err
+	}
+	c.setCipher(vc)
+
+	// the total size is counted once for the caches created by old versions
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(CacheBucketStats)).Get(cacheStatsKeyBytes) != nil {
+			return nil
+		}
+		return recountCacheBytes(tx)
+	}); err != nil {
+		return err
+	}
+
+	if c.m.Encrypt && vc == nil {
+		return c.encrypt()
+	}
 	return nil
 }
 
 func (c *Cache) Set(key []byte, value []byte) error {
+	return c.SetItem(key, value, &CacheItem{})
+}
+
+// SetItem stores the value with the metadata of the request.
+// The key, the size, the creation time and the expiration time of the metadata are set by this method if they are empty.
+// The least recently used responses are removed if the cache exceeds the maximum length or size.
+// A value larger than the maximum size is not stored.
+func (c *Cache) SetItem(key []byte, value []byte, item *CacheItem) error {
+	if c.maxSize > 0 && int64(len(value)) > c.maxSize {
+		return nil
+	}
 	return c.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(CacheBucketCaches))
-
-		if b.Get(key) == nil {
-			// if the key is not found, the item is new.
-			// So we need to add it to the order bucket
-			// and remove the oldest item if the cache is full.
-
-			// add key to order bucket
-			orderBucket := tx.Bucket([]byte(CacheBucketOrder))
-			orderNo, _ := orderBucket.NextSequence()
-			if err := orderBucket.Put(uint64tob(orderNo), key); err != nil {
+		items, err := tx.CreateBucketIfNotExists([]byte(CacheBucketItems))
+		if err != nil {
+			return err
+		}
+		if b.Get(key) != nil {
+			// the existing response is replaced
+			if err := deleteCacheEntry(tx, key); err != nil {
 				return err
 			}
+		}
+		if err := touchCacheKey(tx, key); err != nil {
+			return err
+		}
 
-			// plus 1 means that the new item is added
-			keyN := orderBucket.Stats().KeyN + 1
-			// remove the oldest item if the cache is full
-			for c.maxLength != 0 && keyN > c.maxLength {
-				// get the oldest key
-				oldestOrderNo, oldestKey := orderBucket.Cursor().First()
-				if oldestOrderNo != nil {
-					// remove the oldest orderNo from order bucket
-					if err := orderBucket.Delete(oldestOrderNo); err != nil {
-						return err
-					}
-					// remove the oldest key from cache bucket
-					if err := b.Delete(oldestKey); err != nil {
-						return err
-					}
-					keyN--
-				}
+		item.Key = hex.EncodeToString(key)
+		item.Size = len(value)
+		if item.CreatedAt.IsZero() {
+			item.CreatedAt = time.Now()
+		}
+		if item.ExpiresAt == nil && c.ttl > 0 {
+			expiresAt := item.CreatedAt.Add(c.ttl)
+			item.ExpiresAt = &expiresAt
+		}
+		if err := putCacheItem(tx, items, key, item); err != nil {
+			return err
+		}
+		sealed, err := sealValue(txCipher(tx), value)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, sealed); err != nil {
+			return err
+		}
+		if err := addCacheBytes(tx, int64(len(value))); err != nil {
+			return err
+		}
+		return c.evict(tx)
+	})
+}
+
+// evict removes the least recently used responses while the cache exceeds the maximum length or size.
+func (c *Cache) evict(tx *bolt.Tx) error {
+	if c.maxLength == 0 && c.maxSize == 0 {
+		return nil
+	}
+	orderBucket := tx.Bucket([]byte(CacheBucketOrder))
+	// the bucket stats do not include the changes in the transaction, so the keys are counted
+	n := 0
+	if err := orderBucket.ForEach(func(_, _ []byte) error {
+		n++
+		return nil
+	}); err != nil {
+		return err
+	}
+	for n > 0 {
+		size, err := cacheBytes(tx)
+		if err != nil {
+			return err
+		}
+		if (c.maxLength == 0 || n <= c.maxLength) && (c.maxSize == 0 || size <= c.maxSize) {
+			return nil
+		}
+		_, oldestKey := orderBucket.Cursor().First()
+		if oldestKey == nil {
+			return nil
+		}
+		if err := deleteCacheEntry(tx, append([]byte{}, oldestKey...)); err != nil {
+			return err
+		}
+		n--
+	}
+	return nil
+}
+
+// touchCacheKey moves the key to the end of the order bucket as the most recently used one.
+func touchCacheKey(tx *bolt.Tx, key []byte) error {
+	orderBucket := tx.Bucket([]byte(CacheBucketOrder))
+	recency, err := tx.CreateBucketIfNotExists([]byte(CacheBucketRecency))
+	if err != nil {
+		return err
+	}
+	if err := removeCacheOrder(tx, key); err != nil {
+		return err
+	}
+	orderNo, err := orderBucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err := orderBucket.Put(uint64tob(orderNo), key); err != nil {
+		return err
+	}
+	return recency.Put(key, uint64tob(orderNo))
+}
+
+// removeCacheOrder removes the key from the order bucket.
+func removeCacheOrder(tx *bolt.Tx, key []byte) error {
+	orderBucket := tx.Bucket([]byte(CacheBucketOrder))
+	recency := tx.Bucket([]byte(CacheBucketRecency))
+	var orderNo []byte
+	if recency != nil {
+		if v := recency.Get(key); v != nil {
+			orderNo = append([]byte{}, v...)
+		}
+	}
+	if orderNo == nil {
+		// the keys cached by old versions are not in the recency bucket
+		if err := orderBucket.ForEach(func(k, v []byte) error {
+			if orderNo == nil && bytes.Equal(v, key) {
+				orderNo = append([]byte{}, k...)
 			}
+			return nil
+		}); err != nil {
+			return err
 		}
+	}
+	if orderNo != nil {
+		if err := orderBucket.Delete(orderNo); err != nil {
+			return err
+		}
+	}
+	if recency != nil {
+		return recency.Delete(key)
+	}
+	return nil
+}
 
-		err := b.Put(key, value)
+// deleteCacheEntry removes the cached response of the key with its metadata.
+func deleteCacheEntry(tx *bolt.Tx, key []byte) error {
+	b := tx.Bucket([]byte(CacheBucketCaches))
+	value := b.Get(key)
+	if value == nil {
+		return nil
+	}
+	item, err := getCacheItem(tx, key, value)
+	if err != nil {
 		return err
-	})
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	if items := tx.Bucket([]byte(CacheBucketItems)); items != nil {
+		if err := items.Delete(key); err != nil {
+			return err
+		}
+	}
+	if embeddings := tx.Bucket([]byte(CacheBucketEmbeddings)); embeddings != nil {
+		if err := embeddings.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := removeCacheOrder(tx, key); err != nil {
+		return err
+	}
+	return addCacheBytes(tx, -int64(item.Size))
+}
+
+func cacheBytes(tx *bolt.Tx) (int64, error) {
+	b := tx.Bucket([]byte(CacheBucketStats))
+	if b == nil {
+		return 0, nil
+	}
+	v := b.Get(cacheStatsKeyBytes)
+	if v == nil {
+		return 0, nil
+	}
+	return int64(btouint64(v)), nil
 }
 
+func addCacheBytes(tx *bolt.Tx, delta int64) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(CacheBucketStats))
+	if err != nil {
+		return err
+	}
+	n, err := cacheBytes(tx)
+	if err != nil {
+		return err
+	}
+	n += delta
+	if n < 0 {
+		n = 0
+	}
+	return b.Put(cacheStatsKeyBytes, uint64tob(uint64(n)))
+}
+
+// recountCacheBytes counts the total size of the cached responses.
+func recountCacheBytes(tx *bolt.Tx) error {
+	var n int64
+	if err := tx.Bucket([]byte(CacheBucketCaches)).ForEach(func(key, value []byte) error {
+		item, err := getCacheItem(tx, key, value)
+		if err != nil {
+			return err
+		}
+		n += int64(item.Size)
+		return nil
+	}); err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(CacheBucketStats))
+	if err != nil {
+		return err
+	}
+	return b.Put(cacheStatsKeyBytes, uint64tob(uint64(n)))
+}
+
+// Get returns the cached response of the key, and marks it as the most recently used one.
+// An expired response is removed and not returned.
 func (c *Cache) Get(key []byte) ([]byte, error) {
+	_, value, err := c.get(key, 0, false)
+	return value, err
+}
+
+// Lookup returns the cached response like Get, and records the cache hit or miss.
+// If maxAge is not 0, the responses cached earlier than maxAge ago are also treated as expired.
+func (c *Cache) Lookup(key []byte, maxAge time.Duration) ([]byte, error) {
+	_, value, err := c.get(key, maxAge, true)
+	return value, err
+}
+
+// LookupItem is like Lookup, but it also returns the metadata of the cached response.
+func (c *Cache) LookupItem(key []byte, maxAge time.Duration) (*CacheItem, []byte, error) {
+	return c.get(key, maxAge, true)
+}
+
+func (c *Cache) get(key []byte, maxAge time.Duration, record bool) (*CacheItem, []byte, error) {
+	var item *CacheItem
 	var value []byte
-	err := c.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(CacheBucketCaches))
-		value = b.Get(key)
-		if value == nil {
-			return &CacheItemNotFoundError{Key: key}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		buf := tx.Bucket([]byte(CacheBucketCaches)).Get(key)
+		if buf != nil {
+			var err error
+			item, err = getCacheItem(tx, key, buf)
+			if err != nil {
+				return err
+			}
+			if item.Expired(time.Now(), maxAge) {
+				if err := deleteCacheEntry(tx, key); err != nil {
+					return err
+				}
+				buf = nil
+			}
 		}
-		return nil
+		if buf == nil {
+			if record {
+				return incrementCacheStat(tx, cacheStatsKeyMisses)
+			}
+			return nil
+		}
+
+		// the value is only valid during the transaction, so it is copied
+		v, err := openValue(txCipher(tx), buf)
+		if err != nil {
+			return err
+		}
+		value = append([]byte{}, v...)
+		if err := touchCacheKey(tx, key); err != nil {
+			return err
+		}
+		if !record {
+			return nil
+		}
+
+		now := time.Now()
+		item.Hits++
+		item.LastHitAt = &now
+		items, err := tx.CreateBucketIfNotExists([]byte(CacheBucketItems))
+		if err != nil {
+			return err
+		}
+		if err := putCacheItem(tx, items, key, item); err != nil {
+			return err
+		}
+		return incrementCacheStat(tx, cacheStatsKeyHits)
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return value, nil
+	if value == nil {
+		return nil, nil, &CacheItemNotFoundError{Key: key}
+	}
+	return item, value, nil
 }
 
 func (c *Cache) Size() (size int) {
@@ -192,3 +504,311 @@ func (c *Cache) Size() (size int) {
 	}
 	return size
 }
+
+// CacheItem is the metadata of a cached response.
+type CacheItem struct {
+	Key       string     `json:"key"`                // The hex encoded hash of the request
+	Model     string     `json:"model,omitempty"`    // The model of the request
+	Prompt    string     `json:"prompt,omitempty"`   // The last user message of the request
+	Messages  int        `json:"messages,omitempty"` // The number of the messages of the request
+	Size      int        `json:"size"`               // The size of the cached response in bytes
+	CreatedAt time.Time  `json:"created_at"`
+	Hits      uint64     `json:"hits"`
+	LastHitAt *time.Time `json:"last_hit_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil means that the response never expires
+}
+
+// Expired returns true if the response has expired at the time.
+// If maxAge is not 0, the response cached earlier than maxAge before the time is also treated as expired.
+func (i *CacheItem) Expired(now time.Time, maxAge time.Duration) bool {
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return true
+	}
+	return maxAge > 0 && !i.CreatedAt.IsZero() && now.Sub(i.CreatedAt) > maxAge
+}
+
+// NewCacheItem returns the metadata of the request.
+func NewCacheItem(req openai.ChatCompletionRequest) *CacheItem {
+	item := &CacheItem{
+		Model:    req.Model,
+		Messages: len(req.Messages),
+	}
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == openai.ChatMessageRoleUser {
+			item.Prompt = req.Messages[i].Content
+			break
+		}
+	}
+	return item
+}
+
+func putCacheItem(tx *bolt.Tx, b *bolt.Bucket, key []byte, item *CacheItem) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	buf, err = sealValue(txCipher(tx), buf)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, buf)
+}
+
+// getCacheItem returns the metadata of the key.
+// The responses cached before the metadata was introduced only have the key and the size.
+func getCacheItem(tx *bolt.Tx, key []byte, value []byte) (*CacheItem, error) {
+	item := &CacheItem{}
+	if b := tx.Bucket([]byte(CacheBucketItems)); b != nil {
+		if buf := b.Get(key); buf != nil {
+			buf, err := openValue(txCipher(tx), buf)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(buf, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	item.Key = hex.EncodeToString(key)
+	if item.Size == 0 && value != nil {
+		v, err := openValue(txCipher(tx), value)
+		if err != nil {
+			return nil, err
+		}
+		item.Size = len(v)
+	}
+	return item, nil
+}
+
+func incrementCacheStat(tx *bolt.Tx, key []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(CacheBucketStats))
+	if err != nil {
+		return err
+	}
+	var n uint64
+	if v := b.Get(key); v != nil {
+		n = btouint64(v)
+	}
+	return b.Put(key, uint64tob(n+1))
+}
+
+// ListItems returns the metadata of the cached responses from the least recently used one.
+func (c *Cache) ListItems() ([]*CacheItem, error) {
+	var list []*CacheItem
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(CacheBucketCaches))
+		return tx.Bucket([]byte(CacheBucketOrder)).ForEach(func(_, key []byte) error {
+			value := b.Get(key)
+			if value == nil {
+				return nil
+			}
+			item, err := getCacheItem(tx, key, value)
+			if err != nil {
+				return err
+			}
+			list = append(list, item)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FindKey returns the key of the cached response whose hex encoded key starts with the prefix.
+func (c *Cache) FindKey(prefix string) ([]byte, error) {
+	prefix = strings.ToLower(prefix)
+	if prefix == "" {
+		return nil, errors.New("empty cache key")
+	}
+	var found [][]byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CacheBucketCaches)).ForEach(func(key, _ []byte) error {
+			if strings.HasPrefix(hex.EncodeToString(key), prefix) {
+				found = append(found, append([]byte{}, key...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no cached response matches %q", prefix)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%q matches %d cached responses. specify a longer key", prefix, len(found))
+	}
+}
+
+// GetItem returns the metadata and the cached response of the key.
+func (c *Cache) GetItem(key []byte) (*CacheItem, []byte, error) {
+	var item *CacheItem
+	var value []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket([]byte(CacheBucketCaches)).Get(key)
+		if buf == nil {
+			return &CacheItemNotFoundError{Key: key}
+		}
+		v, err := openValue(txCipher(tx), buf)
+		if err != nil {
+			return err
+		}
+		value = append([]byte{}, v...)
+		item, err = getCacheItem(tx, key, buf)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, value, nil
+}
+
+// Delete removes the cached response of the key.
+func (c *Cache) Delete(key []byte) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(CacheBucketCaches)).Get(key) == nil {
+			return &CacheItemNotFoundError{Key: key}
+		}
+		return deleteCacheEntry(tx, key)
+	})
+}
+
+// PruneExpired removes the expired responses. It returns the number of the removed responses.
+func (c *Cache) PruneExpired() (int, error) {
+	n := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		now := time.Now()
+		if err := tx.Bucket([]byte(CacheBucketCaches)).ForEach(func(key, value []byte) error {
+			item, err := getCacheItem(tx, key, value)
+			if err != nil {
+				return err
+			}
+			if item.Expired(now, 0) {
+				keys = append(keys, append([]byte{}, key...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// the bucket must not be modified during the iteration
+		for _, key := range keys {
+			if err := deleteCacheEntry(tx, key); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CacheStats is the statistics of the cache.
+type CacheStats struct {
+	Items        int
+	Bytes        int64 // The total size of the cached responses
+	Hits         uint64
+	Misses       uint64
+	SemanticHits uint64 // The misses that were answered by the semantic cache
+}
+
+// HitRatio returns the ratio of the cache hits including the semantic hits to the lookups. It returns 0 if there is no lookup.
+func (s *CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.SemanticHits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() (*CacheStats, error) {
+	items, err := c.ListItems()
+	if err != nil {
+		return nil, err
+	}
+	stats := &CacheStats{Items: len(items)}
+	for _, item := range items {
+		stats.Bytes += int64(item.Size)
+	}
+	err = c.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(CacheBucketStats)); b != nil {
+			if v := b.Get(cacheStatsKeyHits); v != nil {
+				stats.Hits = btouint64(v)
+			}
+			if v := b.Get(cacheStatsKeyMisses); v != nil {
+				stats.Misses = btouint64(v)
+			}
+			if v := b.Get(cacheStatsKeySemanticHits); v != nil {
+				stats.SemanticHits = btouint64(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// CacheExportEntry is a line of the JSONL exported by the cache export command.
+type CacheExportEntry struct {
+	*CacheItem
+	Value string `json:"value"`
+}
+
+// Export writes the cached responses with their metadata as JSONL. It returns the number of the exported responses.
+func (c *Cache) Export(w io.Writer) (int, error) {
+	items, err := c.ListItems()
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(w)
+	for _, item := range items {
+		key, err := hex.DecodeString(item.Key)
+		if err != nil {
+			return 0, err
+		}
+		_, value, err := c.GetItem(key)
+		if err != nil {
+			return 0, err
+		}
+		if err := enc.Encode(&CacheExportEntry{CacheItem: item, Value: string(value)}); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), nil
+}
+
+// Import stores the cached responses exported by Export. It returns the number of the imported responses.
+// The existing responses with the same keys are overwritten.
+func (c *Cache) Import(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	n := 0
+	for {
+		entry := &CacheExportEntry{}
+		if err := dec.Decode(entry); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, fmt.Errorf("invalid cache entry: %w", err)
+		}
+		if entry.CacheItem == nil {
+			return n, errors.New("invalid cache entry: the key is missing")
+		}
+		key, err := hex.DecodeString(entry.Key)
+		if err != nil || len(key) == 0 {
+			return n, fmt.Errorf("invalid cache key: %q", entry.Key)
+		}
+		if err := c.SetItem(key, []byte(entry.Value), entry.CacheItem); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
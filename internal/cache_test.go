@@ -1,10 +1,15 @@
 package internal
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCacheItemNotFoundError_Error(t *testing.T) {
@@ -87,3 +92,196 @@ func TestCacheManager_Refresh(t *testing.T) {
 	assert.Equal(t, 0, c.Size())
 
 }
+
+func TestCache_Items(t *testing.T) {
+	cm := testCacheManager(t)
+	cm.MaxLength = 2
+	c, err := cm.Open()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	keys := make([][]byte, 3)
+	for i := range keys {
+		sha := sha256.Sum256([]byte(fmt.Sprintf("test-key-%d", i)))
+		keys[i] = sha[:]
+		err := c.SetItem(keys[i], []byte(fmt.Sprintf("value-%d", i)), NewCacheItem(openai.ChatCompletionRequest{
+			Model: "test-model",
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: "system"},
+				{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("prompt-%d", i)},
+			},
+		}))
+		assert.NoError(t, err)
+	}
+
+	// the metadata of the evicted response is also removed
+	items, err := c.ListItems()
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, hex.EncodeToString(keys[1]), items[0].Key)
+	assert.Equal(t, "test-model", items[0].Model)
+	assert.Equal(t, "prompt-1", items[0].Prompt)
+	assert.Equal(t, 2, items[0].Messages)
+	assert.Equal(t, 7, items[0].Size)
+	assert.False(t, items[0].CreatedAt.IsZero())
+
+	t.Run("Lookup", func(t *testing.T) {
+		v, err := c.Lookup(keys[2], 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value-2"), v)
+		_, err = c.Lookup(keys[0], 0)
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+
+		item, value, err := c.GetItem(keys[2])
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value-2"), value)
+		assert.Equal(t, uint64(1), item.Hits)
+		assert.NotNil(t, item.LastHitAt)
+
+		stats, err := c.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, &CacheStats{Items: 2, Bytes: 14, Hits: 1, Misses: 1}, stats)
+		assert.Equal(t, 0.5, stats.HitRatio())
+	})
+
+	t.Run("FindKey", func(t *testing.T) {
+		key, err := c.FindKey(hex.EncodeToString(keys[1])[:8])
+		assert.NoError(t, err)
+		assert.Equal(t, keys[1], key)
+		_, err = c.FindKey("zz")
+		assert.Error(t, err)
+		_, err = c.FindKey("")
+		assert.Error(t, err)
+	})
+
+	t.Run("Export and Import", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		n, err := c.Export(b)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		cm2 := testCacheManager(t)
+		c2, err := cm2.Open()
+		assert.NoError(t, err)
+		defer c2.Close()
+		n, err = c2.Import(b)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		item, value, err := c2.GetItem(keys[2])
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value-2"), value)
+		assert.Equal(t, "prompt-2", item.Prompt)
+		assert.Equal(t, uint64(1), item.Hits)
+
+		_, err = c2.Import(strings.NewReader(`{"key":"not hex","value":"x"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, c.Delete(keys[1]))
+		assert.Equal(t, 1, c.Size())
+		_, _, err := c.GetItem(keys[1])
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+		assert.IsType(t, &CacheItemNotFoundError{}, c.Delete(keys[1]))
+	})
+}
+
+func TestCache_Eviction(t *testing.T) {
+	key := func(i int) []byte {
+		sha := sha256.Sum256([]byte(fmt.Sprintf("test-key-%d", i)))
+		return sha[:]
+	}
+
+	t.Run("least recently used", func(t *testing.T) {
+		cm := testCacheManager(t)
+		cm.MaxLength = 2
+		c, err := cm.Open()
+		assert.NoError(t, err)
+		defer c.Close()
+
+		assert.NoError(t, c.Set(key(0), []byte("value-0")))
+		assert.NoError(t, c.Set(key(1), []byte("value-1")))
+		// the first response becomes the most recently used one
+		_, err = c.Get(key(0))
+		assert.NoError(t, err)
+		assert.NoError(t, c.Set(key(2), []byte("value-2")))
+
+		_, err = c.Get(key(0))
+		assert.NoError(t, err)
+		_, err = c.Get(key(1))
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+		_, err = c.Get(key(2))
+		assert.NoError(t, err)
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		cm := testCacheManager(t)
+		cm.MaxSize = 20
+		c, err := cm.Open()
+		assert.NoError(t, err)
+		defer c.Close()
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, c.Set(key(i), []byte(fmt.Sprintf("value-%d", i))))
+		}
+		// 3 responses of 7 bytes exceed 20 bytes
+		_, err = c.Get(key(0))
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+		assert.Equal(t, 2, c.Size())
+
+		stats, err := c.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(14), stats.Bytes)
+
+		// a response larger than the maximum size is not stored
+		assert.NoError(t, c.Set(key(3), []byte(strings.Repeat("x", 21))))
+		_, err = c.Get(key(3))
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+		assert.Equal(t, 2, c.Size())
+	})
+}
+
+func TestCache_TTL(t *testing.T) {
+	key := func(i int) []byte {
+		sha := sha256.Sum256([]byte(fmt.Sprintf("test-key-%d", i)))
+		return sha[:]
+	}
+
+	cm := testCacheManager(t)
+	cm.TTL = time.Hour
+	c, err := cm.Open()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	assert.NoError(t, c.Set(key(0), []byte("value-0")))
+	item, _, err := c.GetItem(key(0))
+	assert.NoError(t, err)
+	assert.NotNil(t, item.ExpiresAt)
+	assert.True(t, item.ExpiresAt.After(time.Now().Add(59*time.Minute)))
+
+	expired := time.Now().Add(-time.Minute)
+	assert.NoError(t, c.SetItem(key(1), []byte("value-1"), &CacheItem{ExpiresAt: &expired}))
+	assert.NoError(t, c.SetItem(key(2), []byte("value-2"), &CacheItem{CreatedAt: time.Now().Add(-2 * time.Hour)}))
+
+	t.Run("Lookup with max age", func(t *testing.T) {
+		_, err := c.Lookup(key(0), time.Minute)
+		assert.NoError(t, err)
+		_, err = c.Lookup(key(0), time.Nanosecond)
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+		// the expired response has been removed
+		_, err = c.Get(key(0))
+		assert.IsType(t, &CacheItemNotFoundError{}, err)
+	})
+
+	t.Run("PruneExpired", func(t *testing.T) {
+		n, err := c.PruneExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, 0, c.Size())
+
+		stats, err := c.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), stats.Bytes)
+	})
+}
@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v2"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var CacheCommand = &cli.Command{
+	Name:  "cache",
+	Usage: "Inspect and manage the cached responses",
+	Description: `The cached responses are identified by the hash of the request (the key).
+The subcommands that take a key accept a prefix of it as displayed by 'gptx cache list'.
+Use 'gptx clean' to remove all the cached responses.`,
+	Subcommands: []*cli.Command{
+		CacheExportCommand,
+		CacheImportCommand,
+		CacheListCommand,
+		CachePruneCommand,
+		CacheRemoveCommand,
+		CacheShowCommand,
+		CacheStatsCommand,
+	},
+}
+
+var CacheListCommand = &cli.Command{
+	Name:    "list",
+	Aliases: []string{"ls"},
+	Usage:   "List the cached responses from the least recently used one",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Specify an output `format` (table or json)",
+			Value:   "table",
+		},
+	},
+	Action: cacheListAction,
+}
+
+var cacheListAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid format: %s", format)
+	}
+
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	items, err := cache.ListItems()
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		if items == nil {
+			items = []*CacheItem{}
+		}
+		buf, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.App.Writer, string(buf))
+		return nil
+	}
+
+	t := NewSimpleTableWriter(c.App.Writer)
+	t.AppendHeader(table.Row{
+		"KEY",
+		"MODEL",
+		"PROMPT",
+		"SIZE",
+		"CREATED",
+		"HITS",
+	})
+	for _, item := range items {
+		line, _, _ := strings.Cut(strings.TrimSpace(item.Prompt), "\n")
+		t.AppendRow([]interface{}{
+			shortCacheKey(item.Key),
+			item.Model,
+			truncateChars(line, 50),
+			humanize.Bytes(uint64(item.Size)),
+			formatCacheTime(item.CreatedAt),
+			item.Hits,
+		})
+	}
+	t.Render()
+	return nil
+})
+
+// shortCacheKey returns the prefix of the key that is displayed in the list.
+func shortCacheKey(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
+
+// formatCacheTime formats the time of a cached response. The responses cached by old versions do not have the times.
+func formatCacheTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+var CacheShowCommand = &cli.Command{
+	Name:      "show",
+	Usage:     "Display a cached response and its request metadata",
+	ArgsUsage: "[key]",
+	Action:    cacheShowAction,
+}
+
+var cacheShowAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing key argument")
+	}
+
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	key, err := cache.FindKey(c.Args().First())
+	if err != nil {
+		return err
+	}
+	item, value, err := cache.GetItem(key)
+	if err != nil {
+		return err
+	}
+
+	w := c.App.Writer
+	_, _ = fmt.Fprintf(w, "Key: %s\n", item.Key)
+	_, _ = fmt.Fprintf(w, "Model: %s\n", item.Model)
+	_, _ = fmt.Fprintf(w, "Messages: %d\n", item.Messages)
+	_, _ = fmt.Fprintf(w, "Size: %s\n", humanize.Bytes(uint64(item.Size)))
+	_, _ = fmt.Fprintf(w, "Created: %s\n", formatCacheTime(item.CreatedAt))
+	_, _ = fmt.Fprintf(w, "Hits: %d\n", item.Hits)
+	if item.LastHitAt != nil {
+		_, _ = fmt.Fprintf(w, "Last hit: %s\n", item.LastHitAt.Format(time.RFC3339))
+	}
+	if item.ExpiresAt != nil {
+		_, _ = fmt.Fprintf(w, "Expires: %s\n", item.ExpiresAt.Format(time.RFC3339))
+	}
+	_, _ = fmt.Fprintf(w, "\nPrompt:\n%s\n", item.Prompt)
+	_, _ = fmt.Fprintf(w, "\nResponse:\n%s\n", string(value))
+	return nil
+})
+
+var CacheRemoveCommand = &cli.Command{
+	Name:      "rm",
+	Usage:     "Remove cached responses",
+	ArgsUsage: "[key...]",
+	Action:    cacheRemoveAction,
+}
+
+var cacheRemoveAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing key argument(s)")
+	}
+
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	for _, prefix := range c.Args().Slice() {
+		key, err := cache.FindKey(prefix)
+		if err != nil {
+			return err
+		}
+		if err := cache.Delete(key); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(c.App.Writer, "Removed %x\n", key)
+	}
+	return nil
+})
+
+var CachePruneCommand = &cli.Command{
+	Name:        "prune",
+	Usage:       "Remove the expired responses",
+	Description: "Remove the responses that have expired by the time to live. Expired responses are also removed when they are looked up.",
+	Action:      cachePruneAction,
+}
+
+var cachePruneAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	n, err := cache.PruneExpired()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Removed %d expired response(s)\n", n)
+	return nil
+})
+
+var CacheStatsCommand = &cli.Command{
+	Name:   "stats",
+	Usage:  "Display the statistics of the cache",
+	Action: cacheStatsAction,
+}
+
+var cacheStatsAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	stats, err := cache.Stats()
+	if err != nil {
+		return err
+	}
+	w := c.App.Writer
+	_, _ = fmt.Fprintf(w, "Responses: %d (max %d)\n", stats.Items, r.Config.MaxCacheLength)
+	if r.CacheManager.MaxSize > 0 {
+		_, _ = fmt.Fprintf(w, "Size: %s (max %s)\n", humanize.Bytes(uint64(stats.Bytes)), humanize.Bytes(uint64(r.CacheManager.MaxSize)))
+	} else {
+		_, _ = fmt.Fprintf(w, "Size: %s\n", humanize.Bytes(uint64(stats.Bytes)))
+	}
+	_, _ = fmt.Fprintf(w, "Hits: %d\n", stats.Hits)
+	_, _ = fmt.Fprintf(w, "Misses: %d\n", stats.Misses)
+	if stats.SemanticHits > 0 {
+		_, _ = fmt.Fprintf(w, "Semantic hits: %d\n", stats.SemanticHits)
+	}
+	_, _ = fmt.Fprintf(w, "Hit ratio: %.1f%%\n", stats.HitRatio()*100)
+	return nil
+})
+
+var CacheExportCommand = &cli.Command{
+	Name:        "export",
+	Usage:       "Export the cached responses and their metadata as JSONL",
+	ArgsUsage:   "[file]",
+	Description: "Write the cached responses to the file, or the standard output if the file is not specified. The responses are written in plain text even if the cache is encrypted.",
+	Action:      cacheExportAction,
+}
+
+var cacheExportAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	if c.NArg() == 0 {
+		_, err := cache.Export(c.App.Writer)
+		return err
+	}
+
+	path := c.Args().First()
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := cache.Export(f)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Exported %d cached response(s) to %s\n", n, path)
+	return nil
+})
+
+var CacheImportCommand = &cli.Command{
+	Name:        "import",
+	Usage:       "Import the cached responses exported by 'gptx cache export'",
+	ArgsUsage:   "[file]",
+	Description: `Import the cached responses from the file. Use "-" to read the standard input. The existing responses with the same keys are overwritten.`,
+	Action:      cacheImportAction,
+}
+
+var cacheImportAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing file argument")
+	}
+
+	var in io.Reader
+	if file := c.Args().First(); file == "-" {
+		in = c.App.Reader
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	n, err := cache.Import(in)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Imported %d cached response(s)\n", n)
+	return nil
+})
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestCacheCommand(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+
+	ms, err := mockserver.New(&mockserver.Script{
+		Responses: []*mockserver.Response{
+			{Content: "Tokyo"},
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(ms)
+	defer ts.Close()
+	r.ClientConfig.BaseURL = ts.URL + "/v1"
+
+	for i := 0; i < 2; i++ {
+		err = app.Run([]string{"gptx", "chat", "--no-loading", "What is the capital of Japan?"})
+		assert.NoError(t, err)
+	}
+
+	app.Writer.(*bytes.Buffer).Reset()
+	err = app.Run([]string{"gptx", "cache", "list"})
+	assert.NoError(t, err)
+	out := app.Writer.(*bytes.Buffer).String()
+	assert.Regexp(t, `KEY\s+MODEL\s+PROMPT\s+SIZE\s+CREATED\s+HITS`, out)
+	assert.Regexp(t, `[0-9a-f]{12}\s+gpt-3.5-turbo\s+What is the capital of Japan\?\s+5 B\s+\S+\s+1`, out)
+	key := regexp.MustCompile(`\n([0-9a-f]{12})\s`).FindStringSubmatch(out)[1]
+
+	t.Run("show", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "cache", "show", key})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.Regexp(t, `Key: `+key+`[0-9a-f]{52}\n`, out)
+		assert.Contains(t, out, "Model: gpt-3.5-turbo\nMessages: 1\nSize: 5 B\n")
+		assert.Contains(t, out, "Hits: 1\n")
+		assert.Contains(t, out, "\nPrompt:\nWhat is the capital of Japan?\n\nResponse:\nTokyo\n")
+
+		err = app.Run([]string{"gptx", "cache", "show"})
+		assert.Error(t, err)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "cache", "stats"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Responses: 1 (max 100)\nSize: 5 B\nHits: 1\nMisses: 1\nHit ratio: 50.0%\n", app.Writer.(*bytes.Buffer).String())
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "cache", "prune"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Removed 0 expired response(s)\n", app.Writer.(*bytes.Buffer).String())
+	})
+
+	t.Run("export, rm and import", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.jsonl")
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "cache", "export", path})
+		assert.NoError(t, err)
+		assert.Equal(t, "Exported 1 cached response(s) to "+path+"\n", app.Writer.(*bytes.Buffer).String())
+
+		// the export file must not be overwritten
+		err = app.Run([]string{"gptx", "cache", "export", path})
+		assert.Error(t, err)
+
+		app.Writer.(*bytes.Buffer).Reset()
+		err = app.Run([]string{"gptx", "cache", "rm", key})
+		assert.NoError(t, err)
+		assert.Regexp(t, `^Removed `+key+`[0-9a-f]{52}\n$`, app.Writer.(*bytes.Buffer).String())
+
+		err = app.Run([]string{"gptx", "cache", "rm", key})
+		assert.Error(t, err)
+
+		app.Writer.(*bytes.Buffer).Reset()
+		err = app.Run([]string{"gptx", "cache", "import", path})
+		assert.NoError(t, err)
+		assert.Equal(t, "Imported 1 cached response(s)\n", app.Writer.(*bytes.Buffer).String())
+
+		// the imported response is used
+		err = app.Run([]string{"gptx", "chat", "--no-loading", "What is the capital of Japan?"})
+		assert.NoError(t, err)
+		assert.Len(t, ms.Requests(), 1)
+	})
+}
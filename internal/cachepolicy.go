@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"os"
+	"strings"
+)
+
+// CacheControlNoStore is written to the cache control file by a hook to prevent the response from being cached.
+const CacheControlNoStore = "no-store"
+
+// CachePolicy decides which requests are cached.
+type CachePolicy struct {
+	DeterministicOnly bool     // If true, only the requests with temperature 0 are cached
+	Models            []string // If not empty, only the requests for these models are cached
+	Labels            []string // If not empty, only the requests in the conversations with these labels are cached
+}
+
+// NewCachePolicy creates a CachePolicy from the config.
+func NewCachePolicy(config *CachePolicyConfig) *CachePolicy {
+	return &CachePolicy{
+		DeterministicOnly: config.DeterministicOnly,
+		Models:            config.Models,
+		Labels:            config.Labels,
+	}
+}
+
+// Allows reports whether the request in the conversation with the label can be cached.
+// A nil policy allows all the requests.
+func (p *CachePolicy) Allows(req openai.ChatCompletionRequest, label string) bool {
+	if p == nil {
+		return true
+	}
+	if p.DeterministicOnly && !isDeterministic(req) {
+		return false
+	}
+	if len(p.Models) > 0 && !containsString(p.Models, req.Model) {
+		return false
+	}
+	if len(p.Labels) > 0 && !containsString(p.Labels, label) {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// createCacheControlFile creates an empty file that a hook can write the cache control directive to.
+func createCacheControlFile() (string, error) {
+	file, err := os.CreateTemp("", "gptx-cache-control-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// readCacheControlFile marks the response of the current message as uncacheable if the hook wrote "no-store" to the file.
+func (c *ChatService) readCacheControlFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(b)) == CacheControlNoStore {
+		c.uncacheable = true
+	}
+	return nil
+}
+
+// discardCachedResponse removes the response of the current message from the cache if a hook marked it as uncacheable.
+func (c *ChatService) discardCachedResponse() error {
+	if !c.uncacheable || c.cacheKey == nil {
+		return nil
+	}
+	cache, err := c.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	if err := cache.Delete(c.cacheKey); err != nil {
+		if _, ok := err.(*CacheItemNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCachePolicy_Allows(t *testing.T) {
+	req := func(model string, temperature float32) openai.ChatCompletionRequest {
+		return openai.ChatCompletionRequest{Model: model, Temperature: temperature}
+	}
+
+	tests := []struct {
+		policy   *CachePolicy
+		req      openai.ChatCompletionRequest
+		label    string
+		expected bool
+	}{
+		{policy: nil, req: req("gpt-4", 1), expected: true},
+		{policy: &CachePolicy{}, req: req("gpt-4", 1), expected: true},
+		{policy: &CachePolicy{DeterministicOnly: true}, req: req("gpt-4", requestTemperature(0)), expected: true},
+		{policy: &CachePolicy{DeterministicOnly: true}, req: req("gpt-4", 0), expected: false},
+		{policy: &CachePolicy{DeterministicOnly: true}, req: req("gpt-4", 0.7), expected: false},
+		{policy: &CachePolicy{Models: []string{"gpt-3.5-turbo"}}, req: req("gpt-3.5-turbo", 1), expected: true},
+		{policy: &CachePolicy{Models: []string{"gpt-3.5-turbo"}}, req: req("gpt-4", 1), expected: false},
+		{policy: &CachePolicy{Labels: []string{"scripts"}}, req: req("gpt-4", 1), label: "scripts", expected: true},
+		{policy: &CachePolicy{Labels: []string{"scripts"}}, req: req("gpt-4", 1), label: "", expected: false},
+	}
+
+	for i, tt := range tests {
+		assert.Equal(t, tt.expected, tt.policy.Allows(tt.req, tt.label), i)
+	}
+}
+
+func TestRequestTemperature(t *testing.T) {
+	// the temperature 0 is sent to the API instead of being omitted
+	b, err := json.Marshal(openai.ChatCompletionRequest{Model: "gpt-4", Temperature: requestTemperature(0)})
+	assert.NoError(t, err)
+	m := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Contains(t, m, "temperature")
+	assert.Less(t, m["temperature"].(float64), 1e-6)
+
+	assert.Equal(t, float32(0.7), requestTemperature(0.7))
+}
+
+func TestRequestedTemperature(t *testing.T) {
+	for _, v := range []float32{0, 0.5, 1, 2} {
+		assert.Equal(t, v, requestedTemperature(requestTemperature(v)))
+		assert.Equal(t, v == 0, isDeterministic(openai.ChatCompletionRequest{Temperature: requestTemperature(v)}))
+	}
+	// the API uses its default temperature for a request without the temperature
+	assert.Equal(t, defaultTemperature, requestedTemperature(0))
+	assert.False(t, isDeterministic(openai.ChatCompletionRequest{}))
+}
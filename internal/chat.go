@@ -2,32 +2,60 @@ package internal
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
 	"github.com/briandowns/spinner"
+	"github.com/fatih/color"
 	"github.com/sashabaranov/go-openai"
+	"io"
+	"math"
 	"os"
 	"os/exec"
+	"time"
 )
 
 type ChatService struct {
-	ClientConfig openai.ClientConfig
-	PathResolver *PathResolver
-	StoreManager *StoreManager
-	CacheManager *CacheManager
-	HookFactory  *HookFactory
-	Writer       *OutputWriter
-	Spinner      *spinner.Spinner
-	Conversation *Conversation
-	Hooks        []*Hook
-	NoLoading    bool
-	NoCache      bool
-	OnMemory     bool
-	Model        string
-	Temperature  float32
-	TopP         float32
-	HooksEnv     []string
+	ClientConfig        openai.ClientConfig
+	PathResolver        *PathResolver
+	StoreManager        *StoreManager
+	CacheManager        *CacheManager
+	HookFactory         *HookFactory
+	Writer              *OutputWriter
+	Spinner             *spinner.Spinner
+	Conversation        *Conversation
+	Hooks               []*Hook
+	NoLoading           bool
+	NoCache             bool
+	CacheTTL            time.Duration  // Overrides the time to live of the cached response, and ignores the cached responses older than it. 0 uses the default
+	CachePolicy         *CachePolicy   // Decides which requests are cached. nil caches all the requests
+	RefreshCache        bool           // If true, the cached responses are not used, but the new responses are cached
+	ShowCachedAt        bool           // If true, when the response was cached is reported to ErrWriter if the response is returned from the cache
+	SemanticCache       *SemanticCache // Returns the cached responses of the similar prompts. nil disables it
+	Embedder            Embedder       // Computes the embeddings of the texts
+	IndexManager        *IndexManager  // Opens the local indexes of the documents
+	RAGIndex            string         // The name of the index whose chunks are injected into the prompts. Empty disables it
+	RAGTopK             int            // The number of the chunks injected into a prompt
+	RAGMinScore         float64        // The minimum similarity of the chunks to the prompt
+	EmbedConversations  bool           // If true, the new messages are embedded for the semantic search when the conversation is saved
+	OnMemory            bool
+	Model               string
+	Temperature         float32
+	TopP                float32
+	HooksEnv            []string
+	AutoTitle           bool      // If true, a title is generated after the first exchange of a new conversation
+	TitleModel          string    // The model used to generate titles
+	CompactionThreshold int       // The estimated number of tokens that triggers the compaction of the conversation. 0 disables it
+	CompactionKeep      int       // The number of the latest messages that are not compacted
+	CompactionModel     string    // The model used to summarize the compacted messages
+	Redactor            *Redactor // Redacts the secrets in the requests. nil disables the redaction
+	RestoreRedacted     bool      // If true, the placeholders in the completions are replaced with the original values
+	Verbose             bool      // If true, the details such as the redacted values are reported to ErrWriter
+	ErrWriter           io.Writer
+	AuditLog            *AuditLog  // Records the requests to the API. nil disables it
+	uncacheable         bool       // Set by the hooks that mark the response of the current message as uncacheable
+	cacheKey            []byte     // The cache key of the response of the current message. nil if the cache was not used
+	cacheHit            *CacheItem // The metadata of the cached response of the current message. nil if it was not returned from the cache
+	cacheSimilarity     float64    // The similarity of the prompt of the cached response if it was returned by the semantic cache
 }
 
 func (c *ChatService) DisableOutputAnimation() {
@@ -110,10 +138,24 @@ func (c *ChatService) LoadHooks(hookNames []string) error {
 }
 
 func (c *ChatService) Chat(prompt string) error {
-	if c.Conversation.IsNew() {
+	isNew := c.Conversation.IsNew()
+	c.resetMessageState()
+	if isNew {
 		c.Conversation.Prompt = prompt
 	}
 
+	// inject the chunks of the documents before the pre-message hooks, so that the hooks see the augmented prompt
+	var sources []*RAGSource
+	if c.RAGIndex != "" {
+		_prompt, _sources, err := c.augmentPrompt(context.Background(), prompt)
+		if err != nil {
+			return err
+		}
+		prompt = _prompt
+		sources = _sources
+		c.reportRAGSources(sources)
+	}
+
 	// run pre-message hooks
 	for _, hook := range c.Hooks {
 		_prompt, err := c.runPreMessageHook(hook, prompt)
@@ -128,6 +170,12 @@ func (c *ChatService) Chat(prompt string) error {
 	m.Role = openai.ChatMessageRoleUser
 	m.Content = prompt
 	c.Conversation.AddMessage(m)
+	c.Conversation.SetRAGSources(len(c.Conversation.Messages)-1, sources)
+	c.Conversation.Model = c.Model
+
+	if !isNew {
+		c.autoCompact()
+	}
 
 	if !c.OnMemory {
 		// save conversation
@@ -154,9 +202,85 @@ func (c *ChatService) Chat(prompt string) error {
 		}
 	}
 
+	return c.respond(isNew)
+}
+
+// Retry regenerates the answer to the last message. The last answer is discarded, and the cached response is not used.
+func (c *ChatService) Retry() error {
+	n := len(c.Conversation.Messages)
+	if n < 2 || c.Conversation.Messages[n-1].Role != openai.ChatMessageRoleAssistant || c.Conversation.Messages[n-2].Role != openai.ChatMessageRoleUser {
+		return fmt.Errorf("no answer to retry")
+	}
+	c.resetMessageState()
+
+	last := c.Conversation.Messages[n-1]
+	cachedAt, cacheHit := c.Conversation.CacheHits[n-1]
+	c.Conversation.TruncateMessages(n - 1)
+
+	refreshCache := c.RefreshCache
+	c.RefreshCache = true
+	defer func() {
+		c.RefreshCache = refreshCache
+	}()
+	if err := c.respond(false); err != nil {
+		// restore the last answer, because the conversation in the store still has it
+		if len(c.Conversation.Messages) == n-1 {
+			c.Conversation.AddMessage(last)
+			if cacheHit {
+				c.Conversation.MarkCacheHit(n-1, cachedAt)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// resetMessageState resets the state of the previous message.
+func (c *ChatService) resetMessageState() {
+	c.uncacheable = false
+	c.cacheKey = nil
+	c.cacheHit = nil
+	c.cacheSimilarity = 0
+}
+
+// zeroTemperature is sent to the API for the temperature 0.
+// go-openai omits the temperature 0 from the request because of omitempty, and then the API uses its default temperature 1.
+// So the smallest positive value is sent instead, which works as 0.
+const zeroTemperature float32 = math.SmallestNonzeroFloat32
+
+// defaultTemperature is the temperature used by the API if the request has no temperature.
+const defaultTemperature float32 = 1
+
+// requestTemperature returns the temperature of the request for the temperature requested by the user.
+func requestTemperature(t float32) float32 {
+	if t == 0 {
+		return zeroTemperature
+	}
+	return t
+}
+
+// requestedTemperature returns the temperature requested by the user from the temperature of the request.
+// It is the inverse of requestTemperature.
+func requestedTemperature(t float32) float32 {
+	switch t {
+	case zeroTemperature:
+		return 0
+	case 0:
+		return defaultTemperature
+	}
+	return t
+}
+
+// isDeterministic reports whether the temperature 0 is requested for the request.
+func isDeterministic(req openai.ChatCompletionRequest) bool {
+	return requestedTemperature(req.Temperature) == 0
+}
+
+// respond requests the completion of the conversation, and adds it as an assistant message.
+func (c *ChatService) respond(isNew bool) error {
 	content, err := c.requestChatCompletion(context.Background(), openai.ChatCompletionRequest{
 		Model:       c.Model,
-		Temperature: c.Temperature,
+		Temperature: requestTemperature(c.Temperature),
 		TopP:        c.TopP,
 		Messages:    c.Conversation.Messages,
 	})
@@ -172,12 +296,18 @@ func (c *ChatService) Chat(prompt string) error {
 		}
 		content = _content
 	}
+	if err := c.discardCachedResponse(); err != nil {
+		return err
+	}
 
 	// save completion as an assistant message
-	m = openai.ChatCompletionMessage{}
+	m := openai.ChatCompletionMessage{}
 	m.Role = openai.ChatMessageRoleAssistant
 	m.Content = content
 	c.Conversation.AddMessage(m)
+	if c.cacheHit != nil {
+		c.Conversation.MarkCacheHit(len(c.Conversation.Messages)-1, c.cacheHit.CreatedAt)
+	}
 
 	if !c.OnMemory {
 		if err := func() error {
@@ -198,6 +328,12 @@ func (c *ChatService) Chat(prompt string) error {
 	}
 
 	c.Writer.Println(content)
+	c.reportCacheHit()
+	c.autoEmbedMessages()
+
+	if isNew {
+		c.autoTitle()
+	}
 
 	// run finish hooks
 	for _, hook := range c.Hooks {
@@ -237,48 +373,105 @@ func (c *ChatService) isExistsConversationByName(name string) (bool, error) {
 	}
 }
 
+// requestChatCompletion sends the request after redacting the secrets in the messages.
+// The cache holds the redacted completions, and the redacted values are restored in the returned completion.
 func (c *ChatService) requestChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (string, error) {
-	client := openai.NewClientWithConfig(c.ClientConfig)
+	var rd *Redaction
+	req.Messages, rd = c.redactMessages(req.Messages)
+	content, err := c.sendChatCompletion(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return c.restoreRedacted(rd, content), nil
+}
 
-	if !c.NoCache && !c.OnMemory {
+// useCache reports whether the response of the request can be returned from and stored to the cache.
+func (c *ChatService) useCache(req openai.ChatCompletionRequest) bool {
+	if c.NoCache || c.OnMemory || c.uncacheable {
+		return false
+	}
+	label := ""
+	if c.Conversation != nil {
+		label = c.Conversation.Label
+	}
+	return c.CachePolicy.Allows(req, label)
+}
+
+func (c *ChatService) sendChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (string, error) {
+	if c.useCache(req) {
 		cache, err := c.CacheManager.Open()
 		if err != nil {
 			return "", err
 		}
 		defer cache.Close()
 
-		b, err := json.Marshal(req)
+		key, err := requestHash(req)
 		if err != nil {
 			return "", err
 		}
-		sha := sha256.Sum256(b)
-		key := sha[:]
-		item, err := cache.Get(key)
-		if err != nil {
-			if _, ok := err.(*CacheItemNotFoundError); ok {
-				// Cache miss. Request to OpenAI API
-				c.spinnerStart()
-				resp, err := client.CreateChatCompletion(ctx, req)
-				c.spinnerStop()
-				if err != nil {
+		c.cacheKey = key
+		status := AuditCacheRefresh
+		if !c.RefreshCache {
+			item, value, err := cache.LookupItem(key, c.CacheTTL)
+			if err == nil {
+				// Cache hit
+				if err := c.audit(newAuditEntry(AuditKindChat, AuditCacheHit, c.Conversation), req, nil, nil); err != nil {
 					return "", err
 				}
-				content := resp.Choices[0].Message.Content
-				if err := cache.Set(key, []byte(content)); err != nil {
+				c.cacheHit = item
+				return string(value), nil
+			}
+			if _, ok := err.(*CacheItemNotFoundError); !ok {
+				return "", err
+			}
+			status = AuditCacheMiss
+		}
+
+		// the embedding is also stored for the semantic cache when the cache is refreshed
+		query := c.newSemanticCacheQuery(ctx, req)
+		if query != nil && !c.RefreshCache {
+			hit, err := cache.LookupSimilar(query, c.SemanticCache.Threshold, c.CacheTTL)
+			if err != nil {
+				return "", err
+			}
+			if hit != nil {
+				// Semantic cache hit
+				e := newAuditEntry(AuditKindChat, AuditCacheSemanticHit, c.Conversation)
+				e.Similarity = hit.Similarity
+				if err := c.audit(e, req, nil, nil); err != nil {
 					return "", err
 				}
-				return content, nil
-			} else {
+				if c.cacheKey, err = hex.DecodeString(hit.Item.Key); err != nil {
+					return "", err
+				}
+				c.cacheHit = hit.Item
+				c.cacheSimilarity = hit.Similarity
+				return string(hit.Value), nil
+			}
+		}
+
+		// Cache miss or refresh. Request to OpenAI API and store the new response
+		resp, err := c.createChatCompletion(ctx, newAuditEntry(AuditKindChat, status, c.Conversation), req)
+		if err != nil {
+			return "", err
+		}
+		content := resp.Choices[0].Message.Content
+		cacheItem := NewCacheItem(req)
+		if c.CacheTTL > 0 {
+			expiresAt := time.Now().Add(c.CacheTTL)
+			cacheItem.ExpiresAt = &expiresAt
+		}
+		if err := cache.SetItem(key, []byte(content), cacheItem); err != nil {
+			return "", err
+		}
+		if query != nil {
+			if err := cache.SetEmbedding(key, query); err != nil {
 				return "", err
 			}
-		} else {
-			// Cache hit
-			return string(item), nil
 		}
+		return content, nil
 	} else {
-		c.spinnerStart()
-		resp, err := client.CreateChatCompletion(ctx, req)
-		c.spinnerStop()
+		resp, err := c.createChatCompletion(ctx, newAuditEntry(AuditKindChat, AuditCacheOff, c.Conversation), req)
 		if err != nil {
 			return "", err
 		}
@@ -287,6 +480,31 @@ func (c *ChatService) requestChatCompletion(ctx context.Context, req openai.Chat
 	}
 }
 
+// reportCacheHit writes a dimmed note to ErrWriter if the response was returned from the cache.
+// The note is only written to a terminal unless ShowCachedAt is set, so that it does not pollute the logs of the scripts.
+func (c *ChatService) reportCacheHit() {
+	if c.cacheHit == nil || c.ErrWriter == nil || !c.ShowCachedAt && !isTerminal(c.ErrWriter) {
+		return
+	}
+	note := "cached response"
+	if c.ShowCachedAt && !c.cacheHit.CreatedAt.IsZero() {
+		note += " from " + c.cacheHit.CreatedAt.Local().Format(time.RFC3339)
+	}
+	if c.cacheSimilarity > 0 {
+		note += fmt.Sprintf(" of a similar prompt, similarity %.3f", c.cacheSimilarity)
+	}
+	note = "(" + note + ")"
+	_, _ = color.New(color.Faint).Fprintln(c.ErrWriter, note)
+}
+
+// cacheHitEnv returns the value of GPTX_CACHE_HIT passed to the hooks.
+func (c *ChatService) cacheHitEnv() string {
+	if c.cacheHit != nil {
+		return "1"
+	}
+	return "0"
+}
+
 func (c *ChatService) spinnerStart() {
 	if !c.NoLoading {
 		c.Spinner.Start()
@@ -309,6 +527,11 @@ func (c *ChatService) runPreMessageHook(h *Hook, prompt string) (string, error)
 	if _, err := file.WriteString(prompt); err != nil {
 		return "", err
 	}
+	cacheControlFile, err := createCacheControlFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(cacheControlFile)
 
 	cmd := h.Command()
 
@@ -319,6 +542,7 @@ func (c *ChatService) runPreMessageHook(h *Hook, prompt string) (string, error)
 		fmt.Sprintf("GPTX_MESSAGE_INDEX=%d", len(c.Conversation.Messages)),
 		fmt.Sprintf("GPTX_CONVERSATION_ID=%d", c.Conversation.Id),
 		fmt.Sprintf("GPTX_PROMPT_FILE=%s", file.Name()),
+		fmt.Sprintf("GPTX_CACHE_CONTROL_FILE=%s", cacheControlFile),
 	)
 
 	cmd.Env = cmdEnv
@@ -326,6 +550,9 @@ func (c *ChatService) runPreMessageHook(h *Hook, prompt string) (string, error)
 	if err := cmd.Run(); err != nil {
 		return "", err
 	}
+	if err := c.readCacheControlFile(cacheControlFile); err != nil {
+		return "", err
+	}
 
 	// read the modified prompt from the file after the hook command is finished
 	b, err := os.ReadFile(file.Name())
@@ -346,6 +573,11 @@ func (c *ChatService) runPostMessageHook(h *Hook, completion string) (string, er
 	if _, err := file.WriteString(completion); err != nil {
 		return "", err
 	}
+	cacheControlFile, err := createCacheControlFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(cacheControlFile)
 
 	cmd := h.Command()
 
@@ -356,12 +588,17 @@ func (c *ChatService) runPostMessageHook(h *Hook, completion string) (string, er
 		fmt.Sprintf("GPTX_MESSAGE_INDEX=%d", len(c.Conversation.Messages)-1),
 		fmt.Sprintf("GPTX_CONVERSATION_ID=%d", c.Conversation.Id),
 		fmt.Sprintf("GPTX_COMPLETION_FILE=%s", file.Name()),
+		fmt.Sprintf("GPTX_CACHE_CONTROL_FILE=%s", cacheControlFile),
+		fmt.Sprintf("GPTX_CACHE_HIT=%s", c.cacheHitEnv()),
 	)
 	cmd.Env = cmdEnv
 
 	if err := cmd.Run(); err != nil {
 		return "", err
 	}
+	if err := c.readCacheControlFile(cacheControlFile); err != nil {
+		return "", err
+	}
 
 	// read the modified completion from the file after the hook command is finished
 	b, err := os.ReadFile(file.Name())
@@ -392,6 +629,7 @@ func (c *ChatService) runFinishHook(h *Hook, completion string) error {
 		fmt.Sprintf("GPTX_MESSAGE_INDEX=%d", len(c.Conversation.Messages)-2),
 		fmt.Sprintf("GPTX_CONVERSATION_ID=%d", c.Conversation.Id),
 		fmt.Sprintf("GPTX_COMPLETION_FILE=%s", file.Name()),
+		fmt.Sprintf("GPTX_CACHE_HIT=%s", c.cacheHitEnv()),
 	)
 	cmd.Env = cmdEnv
 
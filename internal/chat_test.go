@@ -9,7 +9,7 @@ func TestChatService_DisableOutputAnimation(t *testing.T) {
 	app := testNewApp(t)
 	r, err := getRepository(app)
 	assert.NoError(t, err)
-	c, err := r.NewChatService(app.Writer)
+	c, err := r.NewChatService(app.Writer, app.ErrWriter)
 	assert.NoError(t, err)
 	c.DisableOutputAnimation()
 
@@ -1,11 +1,13 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"github.com/chzyer/readline"
 	"github.com/urfave/cli/v2"
 	"io"
 	"strings"
+	"time"
 )
 
 var ChatCommand = &cli.Command{
@@ -34,6 +36,15 @@ var ChatCommand = &cli.Command{
 			Aliases: []string{"l"},
 			Usage:   "Specify a `label` for the conversation",
 		},
+		&cli.StringSliceFlag{
+			Name:    "tag",
+			Aliases: []string{"t"},
+			Usage:   "Add a `tag` to the conversation. It can be specified multiple times",
+		},
+		&cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "Add metadata (`key=value`) to the conversation. It can be specified multiple times",
+		},
 		&cli.StringFlag{
 			Name:    "resume",
 			Aliases: []string{"r"},
@@ -80,12 +91,44 @@ var ChatCommand = &cli.Command{
 			Usage:              "Disable cache",
 			DisableDefaultText: true,
 		},
+		&cli.BoolFlag{
+			Name:               "refresh-cache",
+			Usage:              "Do not use the cached response, but cache the new response",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:               "show-cached-at",
+			Usage:              "Print when the response was cached if it is returned from the cache",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:  "cache-ttl",
+			Usage: "Override the time to live of the cached response (e.g. 30m, 12h or 7d). The cached responses older than it are not used",
+		},
+		&cli.StringFlag{
+			Name:  "rag",
+			Usage: "Inject the chunks of the local `index` built by 'gptx index' that are relevant to the prompt into the prompt",
+		},
+		&cli.IntFlag{
+			Name:  "rag-top-k",
+			Usage: "The number of the chunks injected into the prompt. The default is top_k in the [rag] section of the config",
+		},
 		&cli.BoolFlag{
 			Name:               "on-memory",
 			Aliases:            []string{"m"},
 			Usage:              "Disable the ability to save or load the conversation to and from the disk, which also includes the behavior of the --no-cache option.",
 			DisableDefaultText: true,
 		},
+		&cli.BoolFlag{
+			Name:               "no-redact",
+			Usage:              "Disable the redaction of the secrets in the prompts. It is not allowed if the redaction is enforced by the config",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:               "verbose",
+			Usage:              "Report the details such as the redacted values to stderr",
+			DisableDefaultText: true,
+		},
 	},
 	Action: chatAction,
 }
@@ -104,8 +147,33 @@ var chatAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error
 	hookNames := c.StringSlice("hook")
 	interactive := c.Bool("interactive")
 	noCache := c.Bool("no-cache")
+	refreshCache := c.Bool("refresh-cache")
+	showCachedAt := c.Bool("show-cached-at")
+	var cacheTTL time.Duration
+	if v := c.String("cache-ttl"); v != "" {
+		d, err := parseDurationSpec(v)
+		if err != nil {
+			return fmt.Errorf("invalid value for flag cache-ttl: %w", err)
+		}
+		if d <= 0 {
+			return errors.New("invalid value for flag cache-ttl: it must be positive")
+		}
+		cacheTTL = d
+	}
+	ragIndex := c.String("rag")
+	ragTopK := c.Int("rag-top-k")
+	if ragTopK < 0 {
+		return errors.New("invalid value for flag rag-top-k: it must be positive")
+	}
 	onMemory := c.Bool("on-memory")
 	hooksEnv := c.StringSlice("env")
+	noRedact := c.Bool("no-redact")
+	verbose := c.Bool("verbose")
+	tags := c.StringSlice("tag")
+	metadata, err := parseMetadataFlags(c.StringSlice("meta"))
+	if err != nil {
+		return err
+	}
 
 	if interactive && isPipe(c.App.Reader) {
 		return fmt.Errorf("interactive mode is not supported with pipe")
@@ -139,11 +207,19 @@ var chatAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error
 		return fmt.Errorf("prompt is not supported in interactive mode")
 	}
 
+	if noRedact && r.Config.Redaction != nil && r.Config.Redaction.Enabled && r.Config.Redaction.Enforce {
+		return ErrRedactionEnforced
+	}
+
 	// initialize chat service
-	sv, err := r.NewChatService(c.App.Writer)
+	sv, err := r.NewChatService(c.App.Writer, c.App.ErrWriter)
 	if err != nil {
 		return err
 	}
+	if noRedact {
+		sv.Redactor = nil
+	}
+	sv.Verbose = verbose
 	if noAnimation {
 		sv.DisableOutputAnimation()
 	}
@@ -156,12 +232,23 @@ var chatAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error
 	sv.Temperature = temperature
 	sv.TopP = topP
 	sv.NoCache = noCache
+	sv.RefreshCache = refreshCache
+	sv.ShowCachedAt = showCachedAt
+	sv.CacheTTL = cacheTTL
 	sv.OnMemory = onMemory
+	sv.RAGIndex = ragIndex
+	if ragTopK > 0 {
+		sv.RAGTopK = ragTopK
+	}
 	sv.HooksEnv = hooksEnv
 
 	if err := sv.InitConversation(resume, name, label); err != nil {
 		return err
 	}
+	sv.Conversation.AddTags(tags...)
+	for k, v := range metadata {
+		sv.Conversation.SetMetadata(k, v)
+	}
 
 	if err := sv.LoadHooks(hookNames); err != nil {
 		return err
@@ -184,7 +271,7 @@ var chatAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error
 func doREPL(c *cli.Context, r *Repository, sv *ChatService) error {
 	l, err := readline.NewEx(&readline.Config{
 		Prompt:       "> ",
-		AutoComplete: completer,
+		AutoComplete: replCompleter(),
 		HistoryFile:  r.PathResolver.HistoryFilePath(),
 	})
 	if err != nil {
@@ -209,15 +296,7 @@ func doREPL(c *cli.Context, r *Repository, sv *ChatService) error {
 		if strings.HasPrefix(line, ".") {
 			switch line {
 			case ".help":
-				_, _ = fmt.Fprintln(c.App.Writer, strings.TrimSpace(`
-Available commands:
-  .editor   Enter editor mode to input multi-line text
-  .exit:    Exit REPL
-  .quit:    Alias for .exit
-  .help:    Show this help
-
-Press Ctrl+C to abort current expression, Ctrl+D to exit the REPL
-`))
+				_, _ = fmt.Fprintln(c.App.Writer, replHelp())
 			case ".exit", ".quit":
 				goto exit
 			case ".editor":
@@ -226,7 +305,12 @@ Press Ctrl+C to abort current expression, Ctrl+D to exit the REPL
 				l.SetPrompt("> ")
 				goto chat
 			default:
-				_, _ = fmt.Fprintf(c.App.Writer, "Unknown command: %s\n", line)
+				ok, err := runREPLCommand(c.App.Writer, sv, line)
+				if !ok {
+					_, _ = fmt.Fprintf(c.App.Writer, "Unknown command: %s\n", line)
+				} else if err != nil && !isErrCancel(err) {
+					_, _ = fmt.Fprintf(c.App.ErrWriter, "%s\n", err.Error())
+				}
 			}
 			continue
 		}
@@ -269,10 +353,3 @@ func doREPLEditor(l *readline.Instance) string {
 	}
 	return ml
 }
-
-var completer = readline.NewPrefixCompleter(
-	readline.PcItem(".editor"),
-	readline.PcItem(".exit"),
-	readline.PcItem(".quit"),
-	readline.PcItem(".help"),
-)
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+)
+
+var CompactCommand = &cli.Command{
+	Name:      "compact",
+	Usage:     "Replace the older messages of a conversation with a summary",
+	ArgsUsage: "[conversation]",
+	Description: `Ask the model to summarize the older messages of the conversation and replace them with the summary
+to reduce the tokens sent with every message. The leading system messages and the latest messages are kept.
+The original messages are kept in the store and can be seen with 'gptx inspect'.
+Conversations can also be compacted automatically with the [compaction] section of the config.`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:        "keep",
+			Aliases:     []string{"k"},
+			Usage:       "Keep the latest `number` of messages as they are",
+			DefaultText: "the keep value in the config",
+		},
+		&cli.StringFlag{
+			Name:  "model",
+			Usage: "Specify a `model` to summarize the messages. The default is the compaction model in the config or the model of the conversation",
+		},
+		&cli.BoolFlag{
+			Name:               "no-loading",
+			Usage:              "Disable loading animation",
+			DisableDefaultText: true,
+		},
+	},
+	Action: compactAction,
+}
+
+var compactAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing conversation argument")
+	}
+
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	co, err := store.GetConversationByKey(NewConversationKey(c.Args().First()))
+	if err != nil {
+		return err
+	}
+
+	sv, err := r.NewChatService(c.App.Writer, c.App.ErrWriter)
+	if err != nil {
+		return err
+	}
+	sv.NoLoading = c.Bool("no-loading")
+	sv.Model = r.Config.Model
+	keep := sv.CompactionKeep
+	if c.IsSet("keep") {
+		keep = c.Int("keep")
+	}
+	model := c.String("model")
+	if model == "" {
+		model = sv.CompactionModel
+	}
+
+	before := estimateTokens(co.Messages)
+	n, err := sv.CompactConversation(context.Background(), co, keep, model)
+	if err != nil {
+		return err
+	}
+	if err := store.UpdateConversationState(co); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Compacted %d message(s) into a summary (about %d -> %d tokens)\n", n, before, estimateTokens(co.Messages))
+	return nil
+})
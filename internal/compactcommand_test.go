@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompactCommand(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+	s, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	co := NewConversation()
+	co.Prompt = "q1"
+	co.Model = "test-model"
+	co.Messages = testCompactionMessages()
+	assert.NoError(t, s.CreateConversation(co))
+
+	ms, err := mockserver.New(&mockserver.Script{
+		Responses: []*mockserver.Response{
+			{Content: "The user asked q1 and q2."},
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(ms)
+	defer ts.Close()
+	r.ClientConfig.BaseURL = ts.URL + "/v1"
+
+	t.Run("missing argument", func(t *testing.T) {
+		assert.EqualError(t, app.Run([]string{"gptx", "compact"}), "missing conversation argument")
+	})
+
+	t.Run("nothing to compact", func(t *testing.T) {
+		assert.ErrorIs(t, app.Run([]string{"gptx", "compact", "--keep", "10", "1"}), ErrNothingToCompact)
+		assert.Len(t, ms.Requests(), 0)
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		app.Writer = &bytes.Buffer{}
+		assert.NoError(t, app.Run([]string{"gptx", "compact", "--no-loading", "--keep", "2", "1"}))
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "Compacted 4 message(s) into a summary")
+		assert.Equal(t, "test-model", ms.Requests()[0].Model)
+
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		after, err := s.GetConversationById(1)
+		assert.NoError(t, err)
+		assert.Len(t, after.Messages, 4)
+		assert.Equal(t, compactionSummaryPrefix+"The user asked q1 and q2.", after.Messages[1].Content)
+		assert.Equal(t, testCompactionMessages()[1:5], after.Compacted)
+		assert.True(t, co.UpdatedAt.Equal(after.UpdatedAt))
+	})
+}
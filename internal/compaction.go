@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+	"time"
+)
+
+// defaultCompactionKeep is the default number of the latest messages that are not compacted.
+const defaultCompactionKeep = 4
+
+// compactionSummaryPrefix is the prefix of the system message that holds the summary of the compacted messages.
+const compactionSummaryPrefix = "Summary of the earlier conversation:\n"
+
+// compactionInstruction is the system message to ask the model for a summary of the messages.
+const compactionInstruction = "Summarize the following conversation concisely. Keep the facts, decisions, code, commands and open questions that are needed to continue the conversation."
+
+// ErrNothingToCompact is returned when the conversation does not have enough messages to compact.
+var ErrNothingToCompact = errors.New("nothing to compact")
+
+// estimateTokens roughly estimates the number of tokens of the messages.
+// It assumes that a token is about 4 characters, which is good enough to decide when to compact.
+func estimateTokens(messages []openai.ChatCompletionMessage) int {
+	n := 0
+	for _, m := range messages {
+		// every message has a few tokens of overhead for the role and the separators
+		n += 4 + (len(m.Content)+3)/4
+	}
+	return n
+}
+
+func isCompactionSummary(m openai.ChatCompletionMessage) bool {
+	return m.Role == openai.ChatMessageRoleSystem && strings.HasPrefix(m.Content, compactionSummaryPrefix)
+}
+
+// splitForCompaction splits the messages into the leading system messages, the messages to summarize and the latest messages to keep.
+// A previous summary is summarized again together with the newer messages.
+// At least "keep" messages are kept, and they start with a user message so that the summary is followed by a complete exchange.
+// The last user message is always kept, even if keep is 0, so that the request after the compaction has a user message.
+func splitForCompaction(messages []openai.ChatCompletionMessage, keep int) (head, middle, tail []openai.ChatCompletionMessage) {
+	if keep < 1 {
+		keep = 1
+	}
+	h := 0
+	for h < len(messages) && messages[h].Role == openai.ChatMessageRoleSystem && !isCompactionSummary(messages[h]) {
+		h++
+	}
+	t := len(messages) - keep
+	if t < h {
+		t = h
+	}
+	for t > h && t < len(messages) && messages[t].Role != openai.ChatMessageRoleUser {
+		t--
+	}
+	return messages[:h], messages[h:t], messages[t:]
+}
+
+// compactionTranscript returns the messages as a text to summarize.
+func compactionTranscript(messages []openai.ChatCompletionMessage) string {
+	var b strings.Builder
+	for _, m := range messages {
+		if isCompactionSummary(m) {
+			b.WriteString("Summary: " + strings.TrimPrefix(m.Content, compactionSummaryPrefix) + "\n\n")
+			continue
+		}
+		b.WriteString(roleTitle(m.Role) + ": " + m.Content + "\n\n")
+	}
+	return b.String()
+}
+
+// CompactConversation replaces the older messages of the conversation with a summary generated by the model.
+// The latest "keep" messages are kept as they are, and the replaced messages are moved to Compacted.
+// It returns the number of the compacted messages.
+func (c *ChatService) CompactConversation(ctx context.Context, co *Conversation, keep int, model string) (int, error) {
+	if keep < 0 {
+		keep = 0
+	}
+	head, middle, tail := splitForCompaction(co.Messages, keep)
+	compacted := make([]openai.ChatCompletionMessage, 0, len(middle))
+	for _, m := range middle {
+		if !isCompactionSummary(m) {
+			compacted = append(compacted, m)
+		}
+	}
+	if len(compacted) == 0 {
+		return 0, ErrNothingToCompact
+	}
+	if model == "" {
+		model = co.Model
+	}
+	if model == "" {
+		model = c.Model
+	}
+
+	messages, rd := c.redactMessages([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: compactionInstruction},
+		{Role: openai.ChatMessageRoleUser, Content: compactionTranscript(middle)},
+	})
+
+	resp, err := c.createChatCompletion(ctx, newAuditEntry(AuditKindCompaction, AuditCacheOff, co), openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: messages,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return 0, errors.New("the model returned an empty summary")
+	}
+
+	summary := c.restoreRedacted(rd, strings.TrimSpace(resp.Choices[0].Message.Content))
+	messages = make([]openai.ChatCompletionMessage, 0, len(head)+1+len(tail))
+	messages = append(messages, head...)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: compactionSummaryPrefix + summary,
+	})
+	messages = append(messages, tail...)
+
+	co.Compacted = append(co.Compacted, compacted...)
+	co.Messages = messages
+	// the messages after the summary are shifted
+	if len(co.CacheHits) > 0 {
+		hits := map[int]time.Time{}
+		for i, t := range co.CacheHits {
+			switch {
+			case i < len(head):
+				hits[i] = t
+			case i >= len(head)+len(middle):
+				hits[i-len(middle)+1] = t
+			}
+		}
+		co.CacheHits = hits
+	}
+	if len(co.RAGSources) > 0 {
+		sources := map[int][]*RAGSource{}
+		for i, v := range co.RAGSources {
+			switch {
+			case i < len(head):
+				sources[i] = v
+			case i >= len(head)+len(middle):
+				sources[i-len(middle)+1] = v
+			}
+		}
+		co.RAGSources = sources
+	}
+	return len(compacted), nil
+}
+
+// autoCompact compacts the conversation if its estimated number of tokens exceeds the threshold.
+// Compaction is best effort, so the full history is sent if it fails, and a warning is written to ErrWriter.
+func (c *ChatService) autoCompact() {
+	if c.CompactionThreshold <= 0 || estimateTokens(c.Conversation.Messages) <= c.CompactionThreshold {
+		return
+	}
+	if _, err := c.CompactConversation(context.Background(), c.Conversation, c.CompactionKeep, c.CompactionModel); err != nil && err != ErrNothingToCompact && c.ErrWriter != nil {
+		_, _ = fmt.Fprintf(c.ErrWriter, "warning: failed to compact the conversation: %v\n", err)
+	}
+}
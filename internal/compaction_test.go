@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCompactionMessages() []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a helpful assistant."},
+		{Role: openai.ChatMessageRoleUser, Content: "q1"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a1"},
+		{Role: openai.ChatMessageRoleUser, Content: "q2"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a2"},
+		{Role: openai.ChatMessageRoleUser, Content: "q3"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a3"},
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, estimateTokens(nil))
+	assert.Equal(t, 4+3, estimateTokens([]openai.ChatCompletionMessage{{Role: "user", Content: "hello world"}}))
+}
+
+func TestSplitForCompaction(t *testing.T) {
+	messages := testCompactionMessages()
+
+	head, middle, tail := splitForCompaction(messages, 2)
+	assert.Equal(t, messages[:1], head)
+	assert.Equal(t, messages[1:5], middle)
+	assert.Equal(t, messages[5:], tail)
+
+	// the kept messages start with a user message
+	head, middle, tail = splitForCompaction(messages, 3)
+	assert.Len(t, head, 1)
+	assert.Equal(t, messages[1:3], middle)
+	assert.Equal(t, messages[3:], tail)
+
+	_, middle, tail = splitForCompaction(messages, 10)
+	assert.Len(t, middle, 0)
+	assert.Len(t, tail, 6)
+
+	// the last user message is always kept
+	_, middle, tail = splitForCompaction(messages, 0)
+	assert.Equal(t, messages[1:5], middle)
+	assert.Equal(t, messages[5:], tail)
+	_, middle, tail = splitForCompaction(messages[:6], 0)
+	assert.Equal(t, messages[1:5], middle)
+	assert.Equal(t, messages[5:6], tail)
+
+	// a previous summary is not a leading system message
+	messages[0].Content = compactionSummaryPrefix + "summary"
+	head, middle, _ = splitForCompaction(messages, 2)
+	assert.Len(t, head, 0)
+	assert.Len(t, middle, 5)
+}
+
+func TestChatService_CompactConversation(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+
+	ms, err := mockserver.New(&mockserver.Script{
+		Responses: []*mockserver.Response{
+			{Match: `^User: q1`, Content: "The user asked q1 and q2."},
+			{Match: `^Summary: `, Content: "The user asked q1, q2 and q3."},
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(ms)
+	defer ts.Close()
+	r.ClientConfig.BaseURL = ts.URL + "/v1"
+
+	sv, err := r.NewChatService(app.Writer, app.ErrWriter)
+	assert.NoError(t, err)
+	sv.NoLoading = true
+	sv.Model = "test-model"
+
+	co := NewConversation()
+	co.Messages = testCompactionMessages()
+	cachedAt := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	co.MarkCacheHit(2, cachedAt)
+	co.MarkCacheHit(6, cachedAt)
+	sources := []*RAGSource{{Index: "docs", Path: "deploy.md", StartLine: 1, EndLine: 10, Score: 0.9}}
+	co.SetRAGSources(1, sources)
+	co.SetRAGSources(5, sources)
+
+	n, err := sv.CompactConversation(context.Background(), co, 2, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "You are a helpful assistant."},
+		{Role: openai.ChatMessageRoleSystem, Content: compactionSummaryPrefix + "The user asked q1 and q2."},
+		{Role: openai.ChatMessageRoleUser, Content: "q3"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a3"},
+	}, co.Messages)
+	assert.Equal(t, testCompactionMessages()[1:5], co.Compacted)
+	// the cache hit of a3 is shifted, and the one of the compacted a1 is removed
+	assert.Equal(t, map[int]time.Time{3: cachedAt}, co.CacheHits)
+	assert.Equal(t, map[int][]*RAGSource{2: sources}, co.RAGSources)
+	assert.Equal(t, "test-model", ms.Requests()[0].Model)
+	assert.Equal(t, compactionInstruction, ms.Requests()[0].Messages[0].Content)
+
+	// the previous summary is summarized again, and the last user message is kept even if keep is 0
+	co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "q4"})
+	n, err = sv.CompactConversation(context.Background(), co, 0, "summary-model")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, co.Messages, 3)
+	assert.Equal(t, compactionSummaryPrefix+"The user asked q1, q2 and q3.", co.Messages[1].Content)
+	assert.Equal(t, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "q4"}, co.Messages[2])
+	assert.Len(t, co.Compacted, 6)
+	assert.Empty(t, co.CacheHits)
+	assert.Empty(t, co.RAGSources)
+	assert.Equal(t, "summary-model", ms.Requests()[1].Model)
+	assert.True(t, strings.HasPrefix(ms.Requests()[1].Messages[1].Content, "Summary: The user asked q1 and q2.\n\nUser: q3"))
+
+	_, err = sv.CompactConversation(context.Background(), co, 0, "")
+	assert.ErrorIs(t, err, ErrNothingToCompact)
+}
@@ -9,29 +9,333 @@ import (
 var initialConfig = trimLeftSpaces(`
 # This is a Gptx configuration file.
 
-# OpenAI API Key. You can override this value by using the OPENAI_API_KEY environment variable.
+# OpenAI API Key. If no key is configured, the OPENAI_API_KEY environment variable is used.
 openai_api_key = ""
 
+# Instead of writing the API key in plain text, you can load it from a file, the output of a command
+# (e.g. a password manager CLI) or an environment variable with another name.
+# These are resolved only when a request is actually sent to the API.
+# openai_api_key_file = "/path/to/openai_api_key"
+# openai_api_key_command = "op read op://Private/OpenAI/credential"
+# openai_api_key_env = "MY_OPENAI_API_KEY"
+
 # Default model for Chat API.
 model = "gpt-3.5-turbo"
 
 # Maximum number of cached responses.
 max_cache_length = 100
+
+# Maximum total size of the cached responses (e.g. "10MB"). The least recently used responses are removed
+# when the cache exceeds it. Responses larger than it are not cached. Empty means unlimited.
+# max_cache_size = "10MB"
+
+# Time to live of the cached responses (e.g. "12h" or "7d"). Empty means that they never expire.
+# cache_ttl = "7d"
+
+# Base URL of the OpenAI API. You can override this value by using the OPENAI_BASE_URL environment variable.
+# base_url = "https://api.openai.com/v1"
+
+# OpenAI organization ID. You can override this value by using the OPENAI_ORGANIZATION environment variable.
+# organization = ""
+
+# HTTP proxy URL. If it is empty, the HTTP_PROXY and HTTPS_PROXY environment variables are used.
+# proxy = "http://proxy.example.com:8080"
+
+# Path to a PEM encoded CA bundle that is trusted in addition to the system certificates.
+# ca_file = "/path/to/ca.pem"
+
+# Extra HTTP headers sent with every API request.
+# [headers]
+# X-Gateway-Token = "your-token"
+
+# Cache policy that decides which requests are cached. If deterministic_only is true, only the requests with
+# temperature 0 are cached. If models or labels are not empty, only the requests for the models or in the
+# conversations with the labels are cached.
+# [cache_policy]
+# deterministic_only = true
+# models = ["gpt-3.5-turbo"]
+# labels = ["scripts"]
+
+# Semantic cache that returns the cached response of a similar prompt. The similarity is the cosine similarity
+# of the embeddings of the last user messages, and only the requests that are the same except for the last user
+# message are compared. The embeddings are computed by the [embedding] settings.
+# [semantic_cache]
+# enabled = true
+# threshold = 0.95
+
+# Embeddings of the texts. They are computed by the embeddings API with the model, or by the command if it is set.
+# The command reads a text from the standard input and prints its embedding as a JSON array of numbers.
+# [embedding]
+# model = "text-embedding-ada-002"
+# command = "/path/to/local-embedding-model"
+
+# Semantic search of the conversations ('gptx search --semantic'). If it is enabled, the new messages are embedded
+# with the [embedding] settings when the conversations are saved. Otherwise, they are embedded when you search.
+# [semantic_search]
+# enabled = true
+
+# Retrieval-augmented generation over the local document indexes built by 'gptx index'.
+# 'gptx chat --rag <name>' injects the top_k chunks most similar to the prompt into the prompt.
+# The chunks less similar than min_score are not used. chunk_size is the maximum number of characters of a chunk.
+# [rag]
+# top_k = 4
+# min_score = 0.0
+# chunk_size = 1500
+
+# Retention policy of the conversations. The conversations that have not been updated
+# for more than max_age_days are removed automatically when gptx runs. 0 disables it.
+# [retention]
+# max_age_days = 90
+
+# Automatic titling of the conversations. If it is enabled, gptx asks the model for a short title
+# after the first exchange of a new conversation. You can use a cheaper model for it.
+# [title]
+# auto = true
+# model = "gpt-3.5-turbo"
+
+# Compaction of the long conversations. If the estimated number of tokens of a conversation exceeds
+# the threshold, the older messages are replaced with a summary before sending a message.
+# The last "keep" messages are kept as they are. 0 threshold disables the automatic compaction.
+# [compaction]
+# threshold = 8000
+# keep = 4
+# model = "gpt-3.5-turbo"
+
+# Encryption at rest of the conversations and the cache. The secret is loaded from a key file,
+# the output of a command or an environment variable (GPTX_PASSPHRASE by default).
+# Use 'gptx db rekey' to change the secret.
+# [encryption]
+# enabled = true
+# key_file = "/path/to/gptx.key"
+# key_command = "op read op://Private/gptx/passphrase"
+# passphrase_env = "MY_GPTX_PASSPHRASE"
+
+# Redaction of the secrets in the prompts before they are sent to the API. The matched values are replaced
+# with placeholders such as [REDACTED_AWS_ACCESS_KEY_1], and the placeholders in the answers are replaced
+# with the original values if restore is true. Available detectors: private_key, aws_access_key,
+# aws_secret_key, jwt, env_secret, email and ip. If enforce is true, --no-redact is not allowed.
+# [redaction]
+# enabled = true
+# enforce = false
+# restore = true
+# detectors = ["private_key", "aws_access_key", "aws_secret_key", "jwt", "env_secret"]
+#
+# Custom rules. If the pattern has a capturing group, only the first group is replaced.
+# [[redaction.rules]]
+# name = "internal_host"
+# pattern = '\b[a-z0-9-]+\.corp\.example\.com\b'
+
+# Audit log of the requests to the API. The requests are recorded to audit.jsonl in the gptx home directory.
+# If include_prompts is true, the messages sent to the API are also recorded. Use 'gptx audit' to query the log.
+# [audit]
+# enabled = true
+# include_prompts = false
 `)
 
 type Config struct {
-	OpenAIAPIKey   string                 `toml:"openai_api_key"`   // OpenAI API Key
-	Model          string                 `toml:"model"`            // Default setting for https://platform.openai.com/docs/api-reference/chat/create#chat/create-model
-	MaxCacheLength int                    `toml:"max_cache_length"` // The maximum number of cached responses.
-	m              map[string]interface{} `toml:"-"`                // This is an internal representation of Config for holding arbitrary keys.
+	OpenAIAPIKey        string                 `toml:"openai_api_key"`         // OpenAI API Key
+	OpenAIAPIKeyFile    string                 `toml:"openai_api_key_file"`    // Path to a file that contains the OpenAI API Key
+	OpenAIAPIKeyCommand string                 `toml:"openai_api_key_command"` // Command that prints the OpenAI API Key
+	OpenAIAPIKeyEnv     string                 `toml:"openai_api_key_env"`     // Name of an environment variable that contains the OpenAI API Key
+	Model               string                 `toml:"model"`                  // Default setting for https://platform.openai.com/docs/api-reference/chat/create#chat/create-model
+	MaxCacheLength      int                    `toml:"max_cache_length"`       // The maximum number of cached responses.
+	MaxCacheSize        string                 `toml:"max_cache_size"`         // The maximum total size of the cached responses (e.g. "10MB").
+	CacheTTL            string                 `toml:"cache_ttl"`              // The time to live of the cached responses (e.g. "7d").
+	CachePolicy         *CachePolicyConfig     `toml:"cache_policy"`           // Policy that decides which requests are cached.
+	SemanticCache       *SemanticCacheConfig   `toml:"semantic_cache"`         // Semantic cache for the similar prompts.
+	Embedding           *EmbeddingConfig       `toml:"embedding"`              // Embeddings of the texts.
+	SemanticSearch      *SemanticSearchConfig  `toml:"semantic_search"`        // Semantic search of the conversations.
+	RAG                 *RAGConfig             `toml:"rag"`                    // Retrieval-augmented generation over the local document indexes.
+	BaseURL             string                 `toml:"base_url"`               // Base URL of the OpenAI API.
+	Organization        string                 `toml:"organization"`           // OpenAI organization ID.
+	Proxy               string                 `toml:"proxy"`                  // HTTP proxy URL.
+	CAFile              string                 `toml:"ca_file"`                // Path to a PEM encoded CA bundle.
+	Headers             map[string]string      `toml:"headers"`                // Extra HTTP headers sent with every API request.
+	Retention           *RetentionConfig       `toml:"retention"`              // Retention policy of the conversations.
+	Title               *TitleConfig           `toml:"title"`                  // Automatic titling of the conversations.
+	Compaction          *CompactionConfig      `toml:"compaction"`             // Compaction of the long conversations.
+	Encryption          *EncryptionConfig      `toml:"encryption"`             // Encryption at rest of the databases.
+	Redaction           *RedactionConfig       `toml:"redaction"`              // Redaction of the secrets in the prompts.
+	Audit               *AuditConfig           `toml:"audit"`                  // Audit log of the requests to the API.
+	m                   map[string]interface{} `toml:"-"`                      // This is an internal representation of Config for holding arbitrary keys.
 }
 
 func NewConfig() *Config {
 	return &Config{
-		OpenAIAPIKey:   "",
-		Model:          openai.GPT3Dot5Turbo,
-		MaxCacheLength: 100,
-		m:              make(map[string]interface{}),
+		OpenAIAPIKey:        "",
+		OpenAIAPIKeyFile:    "",
+		OpenAIAPIKeyCommand: "",
+		OpenAIAPIKeyEnv:     "",
+		Model:               openai.GPT3Dot5Turbo,
+		MaxCacheLength:      100,
+		MaxCacheSize:        "",
+		CacheTTL:            "",
+		CachePolicy:         NewCachePolicyConfig(),
+		SemanticCache:       NewSemanticCacheConfig(),
+		Embedding:           NewEmbeddingConfig(),
+		SemanticSearch:      NewSemanticSearchConfig(),
+		RAG:                 NewRAGConfig(),
+		BaseURL:             "",
+		Organization:        "",
+		Proxy:               "",
+		CAFile:              "",
+		Headers:             map[string]string{},
+		Retention:           NewRetentionConfig(),
+		Title:               NewTitleConfig(),
+		Compaction:          NewCompactionConfig(),
+		Encryption:          NewEncryptionConfig(),
+		Redaction:           NewRedactionConfig(),
+		Audit:               NewAuditConfig(),
+		m:                   make(map[string]interface{}),
+	}
+}
+
+type CachePolicyConfig struct {
+	DeterministicOnly bool     `toml:"deterministic_only" json:"deterministic_only"` // If true, only the requests with temperature 0 are cached.
+	Models            []string `toml:"models" json:"models"`                         // If not empty, only the requests for these models are cached.
+	Labels            []string `toml:"labels" json:"labels"`                         // If not empty, only the requests in the conversations with these labels are cached.
+}
+
+func NewCachePolicyConfig() *CachePolicyConfig {
+	return &CachePolicyConfig{
+		DeterministicOnly: false,
+		Models:            []string{},
+		Labels:            []string{},
+	}
+}
+
+type SemanticCacheConfig struct {
+	Enabled   bool    `toml:"enabled" json:"enabled"`     // If true, the cached response of a similar prompt is returned.
+	Threshold float64 `toml:"threshold" json:"threshold"` // The minimum cosine similarity of the prompts.
+}
+
+func NewSemanticCacheConfig() *SemanticCacheConfig {
+	return &SemanticCacheConfig{
+		Enabled:   false,
+		Threshold: defaultSemanticCacheThreshold,
+	}
+}
+
+type EmbeddingConfig struct {
+	Model   string `toml:"model" json:"model"`     // The model of the embeddings API.
+	Command string `toml:"command" json:"command"` // Command that computes the embedding instead of the embeddings API.
+}
+
+func NewEmbeddingConfig() *EmbeddingConfig {
+	return &EmbeddingConfig{
+		Model:   defaultEmbeddingModel,
+		Command: "",
+	}
+}
+
+type SemanticSearchConfig struct {
+	Enabled bool `toml:"enabled" json:"enabled"` // If true, the new messages are embedded when the conversations are saved.
+}
+
+func NewSemanticSearchConfig() *SemanticSearchConfig {
+	return &SemanticSearchConfig{
+		Enabled: false,
+	}
+}
+
+type RAGConfig struct {
+	TopK      int     `toml:"top_k" json:"top_k"`           // The number of the chunks injected into the prompt.
+	MinScore  float64 `toml:"min_score" json:"min_score"`   // The minimum cosine similarity of the chunks to the prompt.
+	ChunkSize int     `toml:"chunk_size" json:"chunk_size"` // The maximum number of characters of a chunk when the documents are indexed.
+}
+
+func NewRAGConfig() *RAGConfig {
+	return &RAGConfig{
+		TopK:      defaultRAGTopK,
+		MinScore:  0,
+		ChunkSize: defaultIndexChunkSize,
+	}
+}
+
+type RetentionConfig struct {
+	MaxAgeDays int `toml:"max_age_days" json:"max_age_days"` // Conversations not updated for more than this number of days are removed. 0 disables it.
+}
+
+func NewRetentionConfig() *RetentionConfig {
+	return &RetentionConfig{
+		MaxAgeDays: 0,
+	}
+}
+
+type TitleConfig struct {
+	Auto  bool   `toml:"auto" json:"auto"`   // If true, a title is generated after the first exchange of a new conversation.
+	Model string `toml:"model" json:"model"` // The model used to generate titles. If it is empty, the model of the conversation is used.
+}
+
+func NewTitleConfig() *TitleConfig {
+	return &TitleConfig{
+		Auto:  false,
+		Model: "",
+	}
+}
+
+type CompactionConfig struct {
+	Threshold int    `toml:"threshold" json:"threshold"` // The estimated number of tokens that triggers the automatic compaction. 0 disables it.
+	Keep      int    `toml:"keep" json:"keep"`           // The number of the latest messages that are not compacted.
+	Model     string `toml:"model" json:"model"`         // The model used to summarize. If it is empty, the model of the conversation is used.
+}
+
+func NewCompactionConfig() *CompactionConfig {
+	return &CompactionConfig{
+		Threshold: 0,
+		Keep:      defaultCompactionKeep,
+		Model:     "",
+	}
+}
+
+type EncryptionConfig struct {
+	Enabled       bool   `toml:"enabled" json:"enabled"`               // If true, the values in the databases are encrypted.
+	KeyFile       string `toml:"key_file" json:"key_file"`             // Path to a file that contains the secret.
+	KeyCommand    string `toml:"key_command" json:"key_command"`       // Command that prints the secret.
+	PassphraseEnv string `toml:"passphrase_env" json:"passphrase_env"` // Name of an environment variable that contains the secret.
+}
+
+func NewEncryptionConfig() *EncryptionConfig {
+	return &EncryptionConfig{
+		Enabled:       false,
+		KeyFile:       "",
+		KeyCommand:    "",
+		PassphraseEnv: "",
+	}
+}
+
+type RedactionConfig struct {
+	Enabled   bool             `toml:"enabled" json:"enabled"`     // If true, the secrets in the prompts are redacted.
+	Enforce   bool             `toml:"enforce" json:"enforce"`     // If true, the redaction can not be disabled with --no-redact.
+	Restore   bool             `toml:"restore" json:"restore"`     // If true, the placeholders in the answers are replaced with the original values.
+	Detectors []string         `toml:"detectors" json:"detectors"` // The names of the built-in detectors to use.
+	Rules     []*RedactionRule `toml:"rules" json:"rules"`         // Custom rules.
+}
+
+type RedactionRule struct {
+	Name    string `toml:"name" json:"name"`       // The name used in the placeholders.
+	Pattern string `toml:"pattern" json:"pattern"` // A regular expression. If it has a capturing group, only the first group is replaced.
+}
+
+func NewRedactionConfig() *RedactionConfig {
+	return &RedactionConfig{
+		Enabled:   true,
+		Enforce:   false,
+		Restore:   true,
+		Detectors: append([]string{}, defaultRedactionDetectors...),
+		Rules:     []*RedactionRule{},
+	}
+}
+
+type AuditConfig struct {
+	Enabled        bool `toml:"enabled" json:"enabled"`                 // If true, the requests to the API are recorded to the audit log.
+	IncludePrompts bool `toml:"include_prompts" json:"include_prompts"` // If true, the messages sent to the API are also recorded.
+}
+
+func NewAuditConfig() *AuditConfig {
+	return &AuditConfig{
+		Enabled:        true,
+		IncludePrompts: false,
 	}
 }
 
@@ -54,8 +358,77 @@ func (c *Config) MarshalJSON() ([]byte, error) {
 
 	// override built-in keys
 	m["openai_api_key"] = c.OpenAIAPIKey
+	m["openai_api_key_file"] = c.OpenAIAPIKeyFile
+	m["openai_api_key_command"] = c.OpenAIAPIKeyCommand
+	m["openai_api_key_env"] = c.OpenAIAPIKeyEnv
 	m["model"] = c.Model
 	m["max_cache_length"] = c.MaxCacheLength
+	m["max_cache_size"] = c.MaxCacheSize
+	m["cache_ttl"] = c.CacheTTL
+	if c.CachePolicy != nil {
+		m["cache_policy"] = c.CachePolicy
+	} else {
+		m["cache_policy"] = NewCachePolicyConfig()
+	}
+	if c.SemanticCache != nil {
+		m["semantic_cache"] = c.SemanticCache
+	} else {
+		m["semantic_cache"] = NewSemanticCacheConfig()
+	}
+	if c.Embedding != nil {
+		m["embedding"] = c.Embedding
+	} else {
+		m["embedding"] = NewEmbeddingConfig()
+	}
+	if c.SemanticSearch != nil {
+		m["semantic_search"] = c.SemanticSearch
+	} else {
+		m["semantic_search"] = NewSemanticSearchConfig()
+	}
+	if c.RAG != nil {
+		m["rag"] = c.RAG
+	} else {
+		m["rag"] = NewRAGConfig()
+	}
+	m["base_url"] = c.BaseURL
+	m["organization"] = c.Organization
+	m["proxy"] = c.Proxy
+	m["ca_file"] = c.CAFile
+	if c.Headers != nil {
+		m["headers"] = c.Headers
+	} else {
+		m["headers"] = map[string]string{}
+	}
+	if c.Retention != nil {
+		m["retention"] = c.Retention
+	} else {
+		m["retention"] = NewRetentionConfig()
+	}
+	if c.Title != nil {
+		m["title"] = c.Title
+	} else {
+		m["title"] = NewTitleConfig()
+	}
+	if c.Compaction != nil {
+		m["compaction"] = c.Compaction
+	} else {
+		m["compaction"] = NewCompactionConfig()
+	}
+	if c.Encryption != nil {
+		m["encryption"] = c.Encryption
+	} else {
+		m["encryption"] = NewEncryptionConfig()
+	}
+	if c.Redaction != nil {
+		m["redaction"] = c.Redaction
+	} else {
+		m["redaction"] = NewRedactionConfig()
+	}
+	if c.Audit != nil {
+		m["audit"] = c.Audit
+	} else {
+		m["audit"] = NewAuditConfig()
+	}
 
 	buf, err := json.Marshal(m)
 	if err != nil {
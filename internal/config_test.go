@@ -11,20 +11,76 @@ func TestConfig_LoadFromFile(t *testing.T) {
 	t.Run("Load from file", func(t *testing.T) {
 		tempFile := testTempFile(t, []byte(`
 openai_api_key = "test-key"
+openai_api_key_file = "/path/to/key"
+openai_api_key_command = "echo sk-test"
+openai_api_key_env = "MY_OPENAI_API_KEY"
 model = "test-model"
 max_cache_length = 123
+base_url = "https://gateway.example.com/v1"
+organization = "org-123"
+proxy = "http://proxy.example.com:8080"
+ca_file = "/path/to/ca.pem"
 
 # arbitrary keys
 v1 = "bar"
 v2 = 123
+
+[headers]
+X-Gateway-Token = "secret"
+
+[retention]
+max_age_days = 90
+
+[title]
+auto = true
+model = "title-model"
+
+[compaction]
+threshold = 8000
+keep = 6
+
+[encryption]
+enabled = true
+key_file = "/path/to/gptx.key"
+
+[redaction]
+enforce = true
+detectors = ["email"]
+
+[[redaction.rules]]
+name = "host"
+pattern = 'host-\d+'
+
+[audit]
+include_prompts = true
 `))
 		c := NewConfig()
 		err := c.LoadFromFile(tempFile.Name())
 		assert.NoError(t, err)
 
 		assert.Equal(t, "test-key", c.OpenAIAPIKey)
+		assert.Equal(t, "/path/to/key", c.OpenAIAPIKeyFile)
+		assert.Equal(t, "echo sk-test", c.OpenAIAPIKeyCommand)
+		assert.Equal(t, "MY_OPENAI_API_KEY", c.OpenAIAPIKeyEnv)
 		assert.Equal(t, "test-model", c.Model)
 		assert.Equal(t, 123, c.MaxCacheLength)
+		assert.Equal(t, "https://gateway.example.com/v1", c.BaseURL)
+		assert.Equal(t, "org-123", c.Organization)
+		assert.Equal(t, "http://proxy.example.com:8080", c.Proxy)
+		assert.Equal(t, "/path/to/ca.pem", c.CAFile)
+		assert.Equal(t, map[string]string{"X-Gateway-Token": "secret"}, c.Headers)
+		assert.Equal(t, 90, c.Retention.MaxAgeDays)
+		assert.Equal(t, &TitleConfig{Auto: true, Model: "title-model"}, c.Title)
+		assert.Equal(t, &CompactionConfig{Threshold: 8000, Keep: 6, Model: ""}, c.Compaction)
+		assert.Equal(t, &EncryptionConfig{Enabled: true, KeyFile: "/path/to/gptx.key"}, c.Encryption)
+		assert.Equal(t, &RedactionConfig{
+			Enabled:   true,
+			Enforce:   true,
+			Restore:   true,
+			Detectors: []string{"email"},
+			Rules:     []*RedactionRule{{Name: "host", Pattern: `host-\d+`}},
+		}, c.Redaction)
+		assert.Equal(t, &AuditConfig{Enabled: true, IncludePrompts: true}, c.Audit)
 		assert.Equal(t, "bar", c.m["v1"])
 		assert.Equal(t, int64(123), c.m["v2"])
 	})
@@ -41,6 +97,8 @@ func TestConfig_MarshalJSON(t *testing.T) {
 	c.OpenAIAPIKey = "test-key"
 	c.Model = "test-model"
 	c.MaxCacheLength = 100
+	c.BaseURL = "https://gateway.example.com/v1"
+	c.Headers = map[string]string{"X-Gateway-Token": "secret"}
 	c.m["v1"] = "bar"
 	c.m["v2"] = 123
 
@@ -49,8 +107,43 @@ func TestConfig_MarshalJSON(t *testing.T) {
 	assert.JSONEq(t, strings.TrimPrefix(`
 {
   "openai_api_key": "test-key",
+  "openai_api_key_file": "",
+  "openai_api_key_command": "",
+  "openai_api_key_env": "",
   "model": "test-model",
   "max_cache_length": 100,
+  "max_cache_size": "",
+  "cache_ttl": "",
+  "semantic_cache": {
+    "enabled": false,
+    "threshold": 0.95
+  },
+  "embedding": {
+    "model": "text-embedding-ada-002",
+    "command": ""
+  },
+  "semantic_search": {"enabled": false},
+  "rag": {
+    "top_k": 4,
+    "min_score": 0,
+    "chunk_size": 1500
+  },
+  "cache_policy": {
+    "deterministic_only": false,
+    "models": [],
+    "labels": []
+  },
+  "base_url": "https://gateway.example.com/v1",
+  "organization": "",
+  "proxy": "",
+  "ca_file": "",
+  "headers": {"X-Gateway-Token": "secret"},
+  "retention": {"max_age_days": 0},
+  "title": {"auto": false, "model": ""},
+  "compaction": {"threshold": 0, "keep": 4, "model": ""},
+  "encryption": {"enabled": false, "key_file": "", "key_command": "", "passphrase_env": ""},
+  "redaction": {"enabled": true, "enforce": false, "restore": true, "detectors": ["private_key", "aws_access_key", "aws_secret_key", "jwt", "env_secret"], "rules": []},
+  "audit": {"enabled": true, "include_prompts": false},
   "v1": "bar",
   "v2": 123
 }`, "\n"), string(buf))
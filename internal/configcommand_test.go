@@ -26,8 +26,43 @@ func TestConfigCommand(t *testing.T) {
 		assert.JSONEq(t, strings.TrimPrefix(`
 {
   "openai_api_key": "sk-1234567890",
+  "openai_api_key_file": "",
+  "openai_api_key_command": "",
+  "openai_api_key_env": "",
   "model": "test_model",
-  "max_cache_length": 123
+  "max_cache_length": 123,
+  "max_cache_size": "",
+  "cache_ttl": "",
+  "semantic_cache": {
+    "enabled": false,
+    "threshold": 0.95
+  },
+  "embedding": {
+    "model": "text-embedding-ada-002",
+    "command": ""
+  },
+  "semantic_search": {"enabled": false},
+  "rag": {
+    "top_k": 4,
+    "min_score": 0,
+    "chunk_size": 1500
+  },
+  "cache_policy": {
+    "deterministic_only": false,
+    "models": [],
+    "labels": []
+  },
+  "base_url": "",
+  "organization": "",
+  "proxy": "",
+  "ca_file": "",
+  "headers": {},
+  "retention": {"max_age_days": 0},
+  "title": {"auto": false, "model": ""},
+  "compaction": {"threshold": 0, "keep": 4, "model": ""},
+  "encryption": {"enabled": false, "key_file": "", "key_command": "", "passphrase_env": ""},
+  "redaction": {"enabled": true, "enforce": false, "restore": true, "detectors": ["private_key", "aws_access_key", "aws_secret_key", "jwt", "env_secret"], "rules": []},
+  "audit": {"enabled": true, "include_prompts": false}
 }
 `, "\n"), ret)
 	})
@@ -51,8 +86,43 @@ func TestConfigCommand(t *testing.T) {
 		assert.JSONEq(t, strings.TrimPrefix(`
 {
   "openai_api_key": "sk-1234567890",
+  "openai_api_key_file": "",
+  "openai_api_key_command": "",
+  "openai_api_key_env": "",
   "model": "test_model",
-  "max_cache_length": 123
+  "max_cache_length": 123,
+  "max_cache_size": "",
+  "cache_ttl": "",
+  "semantic_cache": {
+    "enabled": false,
+    "threshold": 0.95
+  },
+  "embedding": {
+    "model": "text-embedding-ada-002",
+    "command": ""
+  },
+  "semantic_search": {"enabled": false},
+  "rag": {
+    "top_k": 4,
+    "min_score": 0,
+    "chunk_size": 1500
+  },
+  "cache_policy": {
+    "deterministic_only": false,
+    "models": [],
+    "labels": []
+  },
+  "base_url": "",
+  "organization": "",
+  "proxy": "",
+  "ca_file": "",
+  "headers": {},
+  "retention": {"max_age_days": 0},
+  "title": {"auto": false, "model": ""},
+  "compaction": {"threshold": 0, "keep": 4, "model": ""},
+  "encryption": {"enabled": false, "key_file": "", "key_command": "", "passphrase_env": ""},
+  "redaction": {"enabled": true, "enforce": false, "restore": true, "detectors": ["private_key", "aws_access_key", "aws_secret_key", "jwt", "env_secret"], "rules": []},
+  "audit": {"enabled": true, "include_prompts": false}
 }
 `, "\n"), ret)
 	})
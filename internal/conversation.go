@@ -2,8 +2,10 @@ package internal
 
 import (
 	"github.com/sashabaranov/go-openai"
+	"path"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -37,13 +39,24 @@ func NewConversationKey(value string) *ConversationKey {
 }
 
 type Conversation struct {
-	Id        uint64                         `json:"id"`              // Conversation ID
-	Prompt    string                         `json:"prompt"`          // The initial input text that starts the conversation
-	Name      string                         `json:"name,omitempty"`  // The unique name of the conversation
-	Label     string                         `json:"label,omitempty"` // A label for categorizing the conversation
-	CreatedAt time.Time                      `json:"created_at"`      // When the conversation was created
-	Messages  []openai.ChatCompletionMessage `json:"messages"`        // Messages in the conversation
-	Hooks     []string                       `json:"hooks,omitempty"` // Registered Hooks for the conversation
+	Id         uint64                         `json:"id"`                    // Conversation ID
+	Prompt     string                         `json:"prompt"`                // The initial input text that starts the conversation
+	Name       string                         `json:"name,omitempty"`        // The unique name of the conversation
+	Title      string                         `json:"title,omitempty"`       // A short human-readable title of the conversation. Unlike the name, it does not need to be unique
+	Label      string                         `json:"label,omitempty"`       // A label for categorizing the conversation
+	Model      string                         `json:"model,omitempty"`       // The model used for the last message
+	CreatedAt  time.Time                      `json:"created_at"`            // When the conversation was created
+	UpdatedAt  time.Time                      `json:"updated_at"`            // When the conversation was last updated
+	Messages   []openai.ChatCompletionMessage `json:"messages"`              // Messages in the conversation
+	Hooks      []string                       `json:"hooks,omitempty"`       // Registered Hooks for the conversation
+	Archived   bool                           `json:"archived,omitempty"`    // Archived conversations are hidden from the list by default
+	Pinned     bool                           `json:"pinned,omitempty"`      // Pinned conversations are listed first and never pruned by the retention policy
+	Starred    bool                           `json:"starred,omitempty"`     // Starred conversations are marked as favorites
+	Tags       []string                       `json:"tags,omitempty"`        // Tags for categorizing the conversation
+	Metadata   map[string]string              `json:"metadata,omitempty"`    // Arbitrary key/value metadata (e.g. ticket=ABC-123)
+	Compacted  []openai.ChatCompletionMessage `json:"compacted,omitempty"`   // The original messages replaced with a summary by the compaction. They are not sent to the API
+	CacheHits  map[int]time.Time              `json:"cache_hits,omitempty"`  // The indexes of the assistant messages returned from the cache, and when the responses were cached
+	RAGSources map[int][]*RAGSource           `json:"rag_sources,omitempty"` // The indexes of the user messages augmented with the chunks of the local documents, and the chunks
 }
 
 func NewConversation() *Conversation {
@@ -52,6 +65,7 @@ func NewConversation() *Conversation {
 		Prompt:    "",
 		Name:      "",
 		Label:     "",
+		Model:     "",
 		CreatedAt: time.Now().UTC(),
 		Messages:  []openai.ChatCompletionMessage{},
 		Hooks:     []string{},
@@ -62,10 +76,107 @@ func (c *Conversation) IsNew() bool {
 	return c.Id == 0
 }
 
+// LastUpdatedAt returns the time when the conversation was last updated.
+// Conversations stored by older versions do not have UpdatedAt, so CreatedAt is used for them.
+func (c *Conversation) LastUpdatedAt() time.Time {
+	if c.UpdatedAt.IsZero() {
+		return c.CreatedAt
+	}
+	return c.UpdatedAt
+}
+
+// States returns the names of the states set on the conversation.
+func (c *Conversation) States() []string {
+	states := []string{}
+	if c.Pinned {
+		states = append(states, "pinned")
+	}
+	if c.Starred {
+		states = append(states, "starred")
+	}
+	if c.Archived {
+		states = append(states, "archived")
+	}
+	return states
+}
+
 func (c *Conversation) AddMessage(msg openai.ChatCompletionMessage) {
 	c.Messages = append(c.Messages, msg)
 }
 
+// MarkCacheHit records that the message at the index was returned from the cache that was created at cachedAt.
+func (c *Conversation) MarkCacheHit(index int, cachedAt time.Time) {
+	if c.CacheHits == nil {
+		c.CacheHits = map[int]time.Time{}
+	}
+	c.CacheHits[index] = cachedAt
+}
+
+// IsCacheHit returns true if the message at the index was returned from the cache.
+func (c *Conversation) IsCacheHit(index int) bool {
+	_, ok := c.CacheHits[index]
+	return ok
+}
+
+// SetRAGSources records the chunks of the documents that were injected into the message at the index.
+func (c *Conversation) SetRAGSources(index int, sources []*RAGSource) {
+	if len(sources) == 0 {
+		return
+	}
+	if c.RAGSources == nil {
+		c.RAGSources = map[int][]*RAGSource{}
+	}
+	c.RAGSources[index] = sources
+}
+
+// TruncateMessages drops the messages from the index, and the records of the dropped messages.
+func (c *Conversation) TruncateMessages(index int) {
+	if index < 0 || index >= len(c.Messages) {
+		return
+	}
+	c.Messages = c.Messages[:index]
+	for i := range c.CacheHits {
+		if i >= index {
+			delete(c.CacheHits, i)
+		}
+	}
+	for i := range c.RAGSources {
+		if i >= index {
+			delete(c.RAGSources, i)
+		}
+	}
+}
+
+// SetSystemMessage replaces the content of the first system message, or inserts a system message at the beginning.
+// The summary of the compacted messages is not replaced.
+func (c *Conversation) SetSystemMessage(content string) {
+	for i := range c.Messages {
+		if c.Messages[i].Role != openai.ChatMessageRoleSystem {
+			break
+		}
+		if !isCompactionSummary(c.Messages[i]) {
+			c.Messages[i].Content = content
+			return
+		}
+	}
+
+	c.Messages = append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: content}}, c.Messages...)
+	if len(c.CacheHits) > 0 {
+		hits := make(map[int]time.Time, len(c.CacheHits))
+		for i, v := range c.CacheHits {
+			hits[i+1] = v
+		}
+		c.CacheHits = hits
+	}
+	if len(c.RAGSources) > 0 {
+		sources := make(map[int][]*RAGSource, len(c.RAGSources))
+		for i, v := range c.RAGSources {
+			sources[i+1] = v
+		}
+		c.RAGSources = sources
+	}
+}
+
 func checkValidConversationName(name string) error {
 	k := NewConversationKey(name)
 	if !k.IsEmpty && !k.IsId {
@@ -77,11 +188,103 @@ func checkValidConversationName(name string) error {
 	}
 }
 
+const (
+	SortById       = "id"
+	SortByCreated  = "created"
+	SortByUpdated  = "updated"
+	SortByMessages = "messages"
+)
+
 type ListConversationsQuery struct {
-	Begin   *uint64
-	Reverse bool
-	Limit   int
-	Label   string
+	Begin        *uint64
+	Reverse      bool
+	Limit        int
+	Label        string
+	Sort         string            // One of SortById (default), SortByCreated, SortByUpdated or SortByMessages
+	CreatedSince *time.Time        // Filter by the creation time (inclusive)
+	CreatedUntil *time.Time        // Filter by the creation time (inclusive)
+	UpdatedSince *time.Time        // Filter by the last update time (inclusive)
+	UpdatedUntil *time.Time        // Filter by the last update time (inclusive)
+	Name         string            // Filter by a glob pattern of the name
+	Hook         string            // Filter by a hook name
+	Model        string            // Filter by a model
+	MinMessages  int               // Filter by the minimum number of messages
+	MaxMessages  int               // Filter by the maximum number of messages
+	Archived     *bool             // Filter by the archived state if it is set
+	Pinned       *bool             // Filter by the pinned state if it is set
+	Starred      bool              // Filter only the starred conversations
+	PinnedFirst  bool              // List the pinned conversations before the others on the first page
+	Tags         []string          // Filter by the tags. The conversations must have all the tags
+	Metadata     map[string]string // Filter by the metadata. The conversations must have all the key/value pairs
+	Prompt       string            // Filter by a case-insensitive text match on the prompt
+}
+
+// Match reports whether the conversation satisfies the filters of the query.
+func (q *ListConversationsQuery) Match(c *Conversation) bool {
+	if q.Label != "" && c.Label != q.Label {
+		return false
+	}
+	if q.CreatedSince != nil && c.CreatedAt.Before(*q.CreatedSince) {
+		return false
+	}
+	if q.CreatedUntil != nil && c.CreatedAt.After(*q.CreatedUntil) {
+		return false
+	}
+	if q.UpdatedSince != nil && c.LastUpdatedAt().Before(*q.UpdatedSince) {
+		return false
+	}
+	if q.UpdatedUntil != nil && c.LastUpdatedAt().After(*q.UpdatedUntil) {
+		return false
+	}
+	if q.Name != "" {
+		if ok, _ := path.Match(q.Name, c.Name); !ok {
+			return false
+		}
+	}
+	if q.Hook != "" {
+		found := false
+		for _, h := range c.Hooks {
+			if h == q.Hook {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Model != "" && c.Model != q.Model {
+		return false
+	}
+	if q.MinMessages > 0 && len(c.Messages) < q.MinMessages {
+		return false
+	}
+	if q.MaxMessages > 0 && len(c.Messages) > q.MaxMessages {
+		return false
+	}
+	if q.Archived != nil && c.Archived != *q.Archived {
+		return false
+	}
+	if q.Pinned != nil && c.Pinned != *q.Pinned {
+		return false
+	}
+	if q.Starred && !c.Starred {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !c.HasTag(tag) {
+			return false
+		}
+	}
+	for key, value := range q.Metadata {
+		if c.Metadata[key] != value {
+			return false
+		}
+	}
+	if q.Prompt != "" && !strings.Contains(strings.ToLower(c.Prompt), strings.ToLower(q.Prompt)) {
+		return false
+	}
+	return true
 }
 
 type ConversationList struct {
@@ -92,18 +295,21 @@ type ConversationList struct {
 	Count         int             `json:"count"`
 }
 
-func (l *ConversationList) TryAppendConversation(c *Conversation) {
-	if l.query != nil && l.query.Label != "" && c.Label != l.query.Label {
-		// skip if label is specified and it doesn't match
-		return
+// TryAppendConversation appends the conversation to the list if it matches the query.
+// It returns true if the conversation is appended.
+func (l *ConversationList) TryAppendConversation(c *Conversation) bool {
+	if l.query != nil && !l.query.Match(c) {
+		// skip if the conversation doesn't match the filters
+		return false
 	}
 
 	if l.IsLimitReached() {
 		// skip if limit is specified and it's reached
-		return
+		return false
 	}
 
 	l.Conversations = append(l.Conversations, c)
+	return true
 }
 
 func (l *ConversationList) IsLimitReached() bool {
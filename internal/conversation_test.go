@@ -4,6 +4,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNewConversationKey(t *testing.T) {
@@ -167,3 +168,105 @@ func TestConversationList_TryAppendConversation(t *testing.T) {
 		assert.Equal(t, 2, len(l.Conversations))
 	})
 }
+
+func TestListConversationsQuery_Match(t *testing.T) {
+	now := time.Now().UTC()
+	past := now.Add(-48 * time.Hour)
+	co := &Conversation{
+		Prompt:    "How do I configure NGINX?",
+		Name:      "deploy-nginx",
+		Label:     "web",
+		Model:     "gpt-4",
+		Hooks:     []string{"shell"},
+		CreatedAt: past,
+		UpdatedAt: now,
+		Messages:  make([]openai.ChatCompletionMessage, 4),
+	}
+
+	yesterday := now.Add(-24 * time.Hour)
+	tests := []struct {
+		query *ListConversationsQuery
+		want  bool
+	}{
+		{&ListConversationsQuery{}, true},
+		{&ListConversationsQuery{Label: "web"}, true},
+		{&ListConversationsQuery{Label: "other"}, false},
+		{&ListConversationsQuery{CreatedSince: &yesterday}, false},
+		{&ListConversationsQuery{CreatedUntil: &yesterday}, true},
+		{&ListConversationsQuery{UpdatedSince: &yesterday}, true},
+		{&ListConversationsQuery{UpdatedUntil: &yesterday}, false},
+		{&ListConversationsQuery{Name: "deploy-*"}, true},
+		{&ListConversationsQuery{Name: "test-*"}, false},
+		{&ListConversationsQuery{Hook: "shell"}, true},
+		{&ListConversationsQuery{Hook: "other"}, false},
+		{&ListConversationsQuery{Model: "gpt-4"}, true},
+		{&ListConversationsQuery{Model: "gpt-3.5-turbo"}, false},
+		{&ListConversationsQuery{MinMessages: 4}, true},
+		{&ListConversationsQuery{MinMessages: 5}, false},
+		{&ListConversationsQuery{Prompt: "nginx"}, true},
+		{&ListConversationsQuery{Prompt: "apache"}, false},
+	}
+	for i, tt := range tests {
+		assert.Equal(t, tt.want, tt.query.Match(co), "case %d", i)
+	}
+}
+
+func TestConversation_LastUpdatedAt(t *testing.T) {
+	co := NewConversation()
+	assert.Equal(t, co.CreatedAt, co.LastUpdatedAt())
+	co.UpdatedAt = co.CreatedAt.Add(time.Hour)
+	assert.Equal(t, co.UpdatedAt, co.LastUpdatedAt())
+}
+
+func TestConversation_TruncateMessages(t *testing.T) {
+	co := NewConversation()
+	co.Messages = []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "q1"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a1"},
+		{Role: openai.ChatMessageRoleUser, Content: "q2"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a2"},
+	}
+	cachedAt := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	co.MarkCacheHit(1, cachedAt)
+	co.MarkCacheHit(3, cachedAt)
+	co.SetRAGSources(2, []*RAGSource{{Index: "docs", Path: "deploy.md", StartLine: 1, EndLine: 10}})
+
+	co.TruncateMessages(2)
+	assert.Equal(t, 2, len(co.Messages))
+	assert.Equal(t, map[int]time.Time{1: cachedAt}, co.CacheHits)
+	assert.Equal(t, 0, len(co.RAGSources))
+
+	// out of range
+	co.TruncateMessages(2)
+	assert.Equal(t, 2, len(co.Messages))
+}
+
+func TestConversation_SetSystemMessage(t *testing.T) {
+	co := NewConversation()
+	co.Messages = []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "q1"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a1"},
+	}
+	cachedAt := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	co.MarkCacheHit(1, cachedAt)
+
+	co.SetSystemMessage("Answer briefly.")
+	assert.Equal(t, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "Answer briefly."}, co.Messages[0])
+	assert.Equal(t, 3, len(co.Messages))
+	assert.Equal(t, map[int]time.Time{2: cachedAt}, co.CacheHits)
+
+	co.SetSystemMessage("Answer in Japanese.")
+	assert.Equal(t, "Answer in Japanese.", co.Messages[0].Content)
+	assert.Equal(t, 3, len(co.Messages))
+
+	// the summary of the compacted messages is kept
+	co = NewConversation()
+	co.Messages = []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: compactionSummaryPrefix + "The user asked q1."},
+		{Role: openai.ChatMessageRoleUser, Content: "q2"},
+	}
+	co.SetSystemMessage("Answer briefly.")
+	assert.Equal(t, 3, len(co.Messages))
+	assert.Equal(t, "Answer briefly.", co.Messages[0].Content)
+	assert.True(t, isCompactionSummary(co.Messages[1]))
+}
@@ -0,0 +1,464 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v2"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var DBCommand = &cli.Command{
+	Name:  "db",
+	Usage: "Manage the database that stores the conversations",
+	Subcommands: []*cli.Command{
+		DBBackupCommand,
+		DBCompactCommand,
+		DBMigrateCommand,
+		DBRekeyCommand,
+		DBRestoreCommand,
+		DBStatsCommand,
+		DBVerifyCommand,
+	},
+}
+
+var dbCacheFlag = &cli.BoolFlag{
+	Name:               "cache",
+	Usage:              "Target the cache database (cache.db) instead of the conversations database (gptx.db)",
+	DisableDefaultText: true,
+}
+
+var DBBackupCommand = &cli.Command{
+	Name:        "backup",
+	Usage:       "Back up the database to a file",
+	ArgsUsage:   "[file]",
+	Description: "Write a consistent copy of the database to the file. It can be run while gptx is used.",
+	Flags: []cli.Flag{
+		dbCacheFlag,
+	},
+	Action: dbBackupAction,
+}
+
+var dbBackupAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() != 1 {
+		return errors.New("missing file argument")
+	}
+	path := c.Args().First()
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	if c.Bool("cache") {
+		cache, err := r.CacheManager.Open()
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		if err := cache.Backup(path); err != nil {
+			return err
+		}
+	} else {
+		store, err := r.StoreManager.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := store.Backup(path); err != nil {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Backed up the database to %s (%s)\n", path, humanize.Bytes(uint64(fileSize(path))))
+	return nil
+})
+
+var DBRestoreCommand = &cli.Command{
+	Name:      "restore",
+	Usage:     "Restore the database from a backup file",
+	ArgsUsage: "[file]",
+	Description: `Replace the database with the backup file. The current database is backed up before it is replaced.
+If the backup was created by an older version of gptx, it is migrated the next time gptx runs.`,
+	Flags: []cli.Flag{
+		dbCacheFlag,
+	},
+	Action: dbRestoreAction,
+}
+
+var dbRestoreAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() != 1 {
+		return errors.New("missing file argument")
+	}
+	src := c.Args().First()
+
+	dst := r.PathResolver.DBFilePath()
+	buckets := []string{BucketConversations, BucketNameIndex}
+	if c.Bool("cache") {
+		dst = r.PathResolver.CacheDBFilePath()
+		buckets = []string{CacheBucketCaches, CacheBucketOrder}
+	}
+	if err := validateDBFile(src, buckets...); err != nil {
+		return err
+	}
+
+	// the database files must be closed before they are replaced
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		backupPath := fmt.Sprintf("%s.%s.bak", dst, time.Now().Format("20060102150405"))
+		if err := restoreDBFile(dst, backupPath); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(c.App.Writer, "Backed up the current database to %s\n", backupPath)
+	}
+	if err := restoreDBFile(src, dst); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Restored the database from %s\n", src)
+	return nil
+})
+
+var DBCompactCommand = &cli.Command{
+	Name:        "compact",
+	Usage:       "Compact the database to reclaim the unused space",
+	Description: "Rewrite the database files to reclaim the space of deleted data. gptx must not be used while compacting.",
+	Flags: []cli.Flag{
+		dbCacheFlag,
+	},
+	Action: dbCompactAction,
+}
+
+var dbCompactAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	// the database files must be closed before they are compacted
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	path := r.PathResolver.DBFilePath()
+	if c.Bool("cache") {
+		path = r.PathResolver.CacheDBFilePath()
+	}
+	before, after, err := compactDBFile(path)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Compacted %s: %s -> %s\n", path, humanize.Bytes(uint64(before)), humanize.Bytes(uint64(after)))
+	return nil
+})
+
+var DBVerifyCommand = &cli.Command{
+	Name:  "verify",
+	Usage: "Verify the consistency of the database and its indexes",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:               "repair",
+			Usage:              "Rebuild the indexes if any problems are found",
+			DisableDefaultText: true,
+		},
+	},
+	Action: dbVerifyAction,
+}
+
+var dbVerifyAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	repair := c.Bool("repair")
+	problems, cleared, err := store.Verify(repair)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		_, _ = fmt.Fprintln(c.App.Writer, "No problems found.")
+		return nil
+	}
+	for _, p := range problems {
+		_, _ = fmt.Fprintln(c.App.Writer, p)
+	}
+	if repair {
+		_, _ = fmt.Fprintf(c.App.Writer, "%d problem(s) found. The indexes have been rebuilt.\n", len(problems))
+		for _, n := range cleared {
+			_, _ = fmt.Fprintf(c.App.Writer, "The name '%s' of conversation %d has been cleared because another conversation has the same name. Rename it by 'gptx rename %d <new name>'\n", n.Name, n.Id, n.Id)
+		}
+		return nil
+	}
+	return fmt.Errorf("%d problem(s) found. run 'gptx db verify --repair' to rebuild the indexes", len(problems))
+})
+
+var DBStatsCommand = &cli.Command{
+	Name:   "stats",
+	Usage:  "Display the statistics of the databases",
+	Action: dbStatsAction,
+}
+
+var dbStatsAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	version, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	storeStats, err := store.BucketStats()
+	if err != nil {
+		return err
+	}
+	cacheStats, err := cache.BucketStats()
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := store.IsEncrypted()
+	if err != nil {
+		return err
+	}
+
+	w := c.App.Writer
+	_, _ = fmt.Fprintf(w, "Schema version: %d\n", version)
+	_, _ = fmt.Fprintf(w, "Encrypted: %t\n\n", encrypted)
+
+	t := NewSimpleTableWriter(w)
+	t.AppendHeader(table.Row{"DATABASE", "PATH", "SIZE"})
+	t.AppendRow(table.Row{"gptx.db", r.PathResolver.DBFilePath(), humanize.Bytes(uint64(fileSize(r.PathResolver.DBFilePath())))})
+	t.AppendRow(table.Row{"cache.db", r.PathResolver.CacheDBFilePath(), humanize.Bytes(uint64(fileSize(r.PathResolver.CacheDBFilePath())))})
+	t.Render()
+	_, _ = fmt.Fprintln(w)
+
+	t = NewSimpleTableWriter(w)
+	t.AppendHeader(table.Row{"DATABASE", "BUCKET", "KEYS", "SIZE"})
+	for _, s := range storeStats {
+		t.AppendRow(table.Row{"gptx.db", s.Name, s.Keys, humanize.Bytes(uint64(s.Size))})
+	}
+	for _, s := range cacheStats {
+		t.AppendRow(table.Row{"cache.db", s.Name, s.Keys, humanize.Bytes(uint64(s.Size))})
+	}
+	t.Render()
+	return nil
+})
+
+var DBMigrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "Migrate the database to the latest schema version",
+	Description: `Migrate the database to the latest schema version.
+The database is usually migrated automatically when gptx runs, and a backup is created before migrating.`,
+	Before: func(c *cli.Context) error {
+		// this command migrates the database by itself
+		c.App.Metadata["repository"].(*Repository).DisableAutoMigration = true
+		return nil
+	},
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:               "dry-run",
+			Aliases:            []string{"n"},
+			Usage:              "Execute the migrations without committing the changes",
+			DisableDefaultText: true,
+		},
+	},
+	Action: dbMigrateAction,
+}
+
+var dbMigrateAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	version, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	pending, err := store.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	w := c.App.Writer
+	_, _ = fmt.Fprintf(w, "Current schema version: %d\n", version)
+	_, _ = fmt.Fprintf(w, "Latest schema version: %d\n", LatestSchemaVersion())
+	if len(pending) == 0 {
+		_, _ = fmt.Fprintln(w, "The database is up to date.")
+		return nil
+	}
+
+	dryRun := c.Bool("dry-run")
+	if !dryRun {
+		backupPath := store.BackupPath(version)
+		if err := store.Backup(backupPath); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "Created a backup: %s\n", backupPath)
+	}
+
+	applied, err := store.Migrate(dryRun)
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		_, _ = fmt.Fprintf(w, "Applied version %d: %s\n", m.Version, m.Description)
+	}
+	if dryRun {
+		_, _ = fmt.Fprintf(w, "%d migration(s) would be applied (dry run)\n", len(applied))
+	} else {
+		_, _ = fmt.Fprintf(w, "Migrated the database to version %d\n", LatestSchemaVersion())
+	}
+	return nil
+})
+
+var DBRekeyCommand = &cli.Command{
+	Name:  "rekey",
+	Usage: "Change the secret of the encrypted databases",
+	Description: `Re-encrypt the data keys of the encrypted databases (gptx.db, cache.db and the document indexes) with a new secret.
+The current secret is loaded from the [encryption] section of the config. Specify the new secret with one of the options,
+and update the config to use the new secret after rekeying. Backups created before rekeying still need the old secret.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "new-key-file",
+			Usage: "Load the new secret from the `file`",
+		},
+		&cli.StringFlag{
+			Name:  "new-key-command",
+			Usage: "Load the new secret from the output of the `command`",
+		},
+		&cli.StringFlag{
+			Name:  "new-passphrase-env",
+			Usage: "Load the new secret from the environment variable `name`",
+		},
+		&cli.BoolFlag{
+			Name:               "rotate-data-key",
+			Usage:              "Also rotate the data keys and re-encrypt all the stored values",
+			DisableDefaultText: true,
+		},
+	},
+	Action: dbRekeyAction,
+}
+
+var dbRekeyAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	config := &EncryptionConfig{
+		KeyFile:       c.String("new-key-file"),
+		KeyCommand:    c.String("new-key-command"),
+		PassphraseEnv: c.String("new-passphrase-env"),
+	}
+	n := 0
+	for _, v := range []string{config.KeyFile, config.KeyCommand, config.PassphraseEnv} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("specify the new secret with one of --new-key-file, --new-key-command and --new-passphrase-env")
+	}
+	newSecret, err := NewEncryptionKeyResolver(config).Resolve()
+	if err != nil {
+		return err
+	}
+	rotate := c.Bool("rotate-data-key")
+
+	// all the databases are opened and checked before any of them is changed,
+	// because each of them is rekeyed in its own transaction.
+	var targets []*rekeyTarget
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	targets = append(targets, &rekeyTarget{Path: r.PathResolver.DBFilePath(), db: store.db, Rekey: store.Rekey})
+
+	cache, err := r.CacheManager.Open()
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	targets = append(targets, &rekeyTarget{Path: r.PathResolver.CacheDBFilePath(), db: cache.db, Rekey: cache.Rekey})
+
+	names, err := r.IndexManager.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		idx, err := r.IndexManager.Open(name, false)
+		if err != nil {
+			return err
+		}
+		defer idx.Close()
+		targets = append(targets, &rekeyTarget{Path: r.PathResolver.IndexFilePath(name), db: idx.db, Rekey: func(newSecret string, rotate bool) error {
+			return idx.Rekey(r.IndexManager.KeyResolver, newSecret, rotate)
+		}})
+	}
+
+	for _, t := range targets {
+		encrypted := false
+		if err := t.db.View(func(tx *bolt.Tx) error {
+			encrypted = isEncryptedDB(tx)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !encrypted {
+			return fmt.Errorf("%s is not encrypted. Enable the encryption in the config before rekeying", t.Path)
+		}
+	}
+
+	// the hash chain of the audit log is keyed with the secret, so it is resealed with the new secret
+	auditPath := r.PathResolver.AuditLogFilePath()
+	summary, err := VerifyAuditLog(auditPath, r.AuditKey)
+	if err != nil {
+		return err
+	}
+	reseal := r.AuditKey != nil && summary.Entries > summary.Unkeyed
+	if reseal {
+		targets = append(targets, &rekeyTarget{Path: auditPath, Rekey: func(newSecret string, _ bool) error {
+			summary, err = ResealAuditLog(auditPath, r.AuditKey, deriveAuditKey(newSecret))
+			return err
+		}})
+	}
+
+	if err := rekeyTargets(c.App.Writer, targets, newSecret, rotate); err != nil {
+		return err
+	}
+	if reseal {
+		_, _ = fmt.Fprintf(c.App.Writer, "The last hash of the audit log is now %s\n", summary.LastHash)
+	}
+	_, _ = fmt.Fprintln(c.App.Writer, "Update the [encryption] section of the config to use the new secret.")
+	return nil
+})
+
+// rekeyTarget is a database changed by the rekey command.
+type rekeyTarget struct {
+	Path  string
+	Rekey func(newSecret string, rotate bool) error
+	db    *bolt.DB
+}
+
+// rekeyTargets changes the secret of the databases in order.
+// If one of them fails, the returned error tells which databases have already been rekeyed with the new secret.
+func rekeyTargets(w io.Writer, targets []*rekeyTarget, newSecret string, rotate bool) error {
+	var rekeyed []string
+	for _, t := range targets {
+		if err := t.Rekey(newSecret, rotate); err != nil {
+			if len(rekeyed) == 0 {
+				return fmt.Errorf("failed to rekey %s: %w. No database has been changed", t.Path, err)
+			}
+			return fmt.Errorf("failed to rekey %s: %w. These databases have already been rekeyed with the new secret: %s", t.Path, err, strings.Join(rekeyed, ", "))
+		}
+		rekeyed = append(rekeyed, t.Path)
+		_, _ = fmt.Fprintf(w, "Rekeyed %s\n", t.Path)
+	}
+	return nil
+}
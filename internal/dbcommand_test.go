@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBMigrateCommand(t *testing.T) {
+	app := testNewApp(t)
+	r := app.Metadata["repository"].(*Repository)
+	testCreateLegacyDB(t, r.PathResolver.DBFilePath())
+
+	err := app.Run([]string{"gptx", "db", "migrate", "--dry-run"})
+	assert.NoError(t, err)
+	out := app.Writer.(*bytes.Buffer).String()
+	assert.Contains(t, out, "Current schema version: 0\n")
+	assert.Contains(t, out, fmt.Sprintf("%d migration(s) would be applied (dry run)\n", len(Migrations)))
+
+	app.Writer.(*bytes.Buffer).Reset()
+	err = app.Run([]string{"gptx", "db", "migrate"})
+	assert.NoError(t, err)
+	out = app.Writer.(*bytes.Buffer).String()
+	assert.Contains(t, out, "Created a backup: ")
+	assert.Contains(t, out, fmt.Sprintf("Migrated the database to version %d\n", LatestSchemaVersion()))
+
+	app.Writer.(*bytes.Buffer).Reset()
+	err = app.Run([]string{"gptx", "db", "migrate"})
+	assert.NoError(t, err)
+	assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "The database is up to date.\n")
+}
+
+func TestDBBackupAndRestoreCommand(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+	s, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	err = app.Run([]string{"gptx", "db", "backup", backupPath})
+	assert.NoError(t, err)
+	assert.FileExists(t, backupPath)
+
+	// the backup file must not be overwritten
+	err = app.Run([]string{"gptx", "db", "backup", backupPath})
+	assert.Error(t, err)
+
+	s, err = r.StoreManager.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.DeleteConversationById(1))
+	assert.NoError(t, r.StoreManager.Close())
+
+	err = app.Run([]string{"gptx", "db", "restore", backupPath})
+	assert.NoError(t, err)
+	assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "Restored the database from "+backupPath)
+
+	s, err = r.StoreManager.Open()
+	assert.NoError(t, err)
+	_, err = s.GetConversationById(1)
+	assert.NoError(t, err)
+}
+
+func TestDBMaintenanceCommands(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+	s, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+
+	t.Run("verify", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "db", "verify"})
+		assert.NoError(t, err)
+		assert.Equal(t, "No problems found.\n", app.Writer.(*bytes.Buffer).String())
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "db", "stats"})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.Contains(t, out, fmt.Sprintf("Schema version: %d\n", LatestSchemaVersion()))
+		assert.Regexp(t, `gptx\.db\s+conversations\s+3\s`, out)
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "db", "compact"})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "Compacted ")
+	})
+
+	t.Run("repair reports the cleared names", func(t *testing.T) {
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		assert.NoError(t, s.RenameConversationByKey(NewConversationKey("1"), "nginx"))
+		testDuplicateConversationName(t, s, 3, "nginx")
+
+		app.Writer.(*bytes.Buffer).Reset()
+		err = app.Run([]string{"gptx", "db", "verify", "--repair"})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "The name 'nginx' of conversation 3 has been cleared because another conversation has the same name. Rename it by 'gptx rename 3 <new name>'\n")
+	})
+}
+
+func TestDBRekeyCommand(t *testing.T) {
+	t.Setenv("GPTX_TEST_PASSPHRASE", "old secret")
+	t.Setenv("GPTX_TEST_NEW_PASSPHRASE", "new secret")
+
+	app := testNewApp(t)
+	r := app.Metadata["repository"].(*Repository)
+	writeConfig := func(env string) {
+		config := fmt.Sprintf("[encryption]\nenabled = true\npassphrase_env = %q\n", env)
+		assert.NoError(t, os.WriteFile(r.PathResolver.ConfigFilePath(), []byte(config), 0600))
+	}
+	writeConfig("GPTX_TEST_PASSPHRASE")
+
+	err := app.Run([]string{"gptx", "db", "stats"})
+	assert.NoError(t, err)
+	assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "Encrypted: true\n")
+	idx, err := r.IndexManager.Open("docs", true)
+	assert.NoError(t, err)
+	assert.NoError(t, idx.Close())
+	assert.NotNil(t, r.AuditKey)
+	testAppendAuditEntries(t, &AuditLog{Path: r.PathResolver.AuditLogFilePath(), Key: r.AuditKey}, 2)
+
+	t.Run("missing new secret", func(t *testing.T) {
+		err := app.Run([]string{"gptx", "db", "rekey"})
+		assert.Error(t, err)
+		err = app.Run([]string{"gptx", "db", "rekey", "--new-key-file", "key", "--new-passphrase-env", "GPTX_TEST_NEW_PASSPHRASE"})
+		assert.Error(t, err)
+	})
+
+	t.Run("plain index", func(t *testing.T) {
+		m := &IndexManager{PathResolver: r.PathResolver}
+		idx, err := m.Open("plain", true)
+		assert.NoError(t, err)
+		assert.NoError(t, idx.Close())
+		defer os.Remove(r.PathResolver.IndexFilePath("plain"))
+
+		// the encryption is disabled, so the plain index is not encrypted when it is opened
+		config := "[encryption]\npassphrase_env = \"GPTX_TEST_PASSPHRASE\"\n"
+		assert.NoError(t, os.WriteFile(r.PathResolver.ConfigFilePath(), []byte(config), 0600))
+		defer writeConfig("GPTX_TEST_PASSPHRASE")
+
+		app2 := NewApp(NewRepository(r.PathResolver))
+		app2.Writer = &bytes.Buffer{}
+		err = app2.Run([]string{"gptx", "db", "rekey", "--new-passphrase-env", "GPTX_TEST_NEW_PASSPHRASE"})
+		assert.EqualError(t, err, r.PathResolver.IndexFilePath("plain")+" is not encrypted. Enable the encryption in the config before rekeying")
+		assert.NotContains(t, app2.Writer.(*bytes.Buffer).String(), "Rekeyed")
+
+		// nothing has been changed
+		app3 := NewApp(NewRepository(r.PathResolver))
+		app3.Writer = &bytes.Buffer{}
+		assert.NoError(t, app3.Run([]string{"gptx", "db", "stats"}))
+	})
+
+	t.Run("rekey", func(t *testing.T) {
+		app.Writer.(*bytes.Buffer).Reset()
+		err := app.Run([]string{"gptx", "db", "rekey", "--new-passphrase-env", "GPTX_TEST_NEW_PASSPHRASE"})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.Contains(t, out, "Rekeyed "+r.PathResolver.DBFilePath()+"\n")
+		assert.Contains(t, out, "Rekeyed "+r.PathResolver.CacheDBFilePath()+"\n")
+		assert.Contains(t, out, "Rekeyed "+r.PathResolver.IndexFilePath("docs")+"\n")
+		assert.Contains(t, out, "Rekeyed "+r.PathResolver.AuditLogFilePath()+"\n")
+		assert.Contains(t, out, "The last hash of the audit log is now ")
+
+		// the old secret no longer unlocks the databases
+		app2 := NewApp(NewRepository(r.PathResolver))
+		app2.Writer = &bytes.Buffer{}
+		err = app2.Run([]string{"gptx", "db", "stats"})
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+
+		writeConfig("GPTX_TEST_NEW_PASSPHRASE")
+		app3 := NewApp(NewRepository(r.PathResolver))
+		app3.Writer = &bytes.Buffer{}
+		err = app3.Run([]string{"gptx", "db", "stats"})
+		assert.NoError(t, err)
+		assert.Contains(t, app3.Writer.(*bytes.Buffer).String(), "Encrypted: true\n")
+
+		// the audit log is resealed with the new secret
+		app3.Writer = &bytes.Buffer{}
+		err = app3.Run([]string{"gptx", "audit", "--verify"})
+		assert.NoError(t, err)
+		assert.Contains(t, app3.Writer.(*bytes.Buffer).String(), "Verified 2 entries.\nLast hash: ")
+
+		m := &IndexManager{
+			PathResolver: r.PathResolver,
+			KeyResolver:  NewEncryptionKeyResolver(&EncryptionConfig{PassphraseEnv: "GPTX_TEST_NEW_PASSPHRASE"}),
+		}
+		idx, err := m.Open("docs", false)
+		assert.NoError(t, err)
+		assert.NoError(t, idx.Close())
+	})
+}
+
+func TestRekeyTargets(t *testing.T) {
+	ok := func(string, bool) error { return nil }
+	fail := func(string, bool) error { return errors.New("broken") }
+
+	w := &bytes.Buffer{}
+	err := rekeyTargets(w, []*rekeyTarget{{Path: "a.db", Rekey: fail}, {Path: "b.db", Rekey: ok}}, "secret", false)
+	assert.EqualError(t, err, "failed to rekey a.db: broken. No database has been changed")
+	assert.Equal(t, "", w.String())
+
+	w.Reset()
+	err = rekeyTargets(w, []*rekeyTarget{{Path: "a.db", Rekey: ok}, {Path: "b.db", Rekey: ok}, {Path: "c.db", Rekey: fail}}, "secret", false)
+	assert.EqualError(t, err, "failed to rekey c.db: broken. These databases have already been rekeyed with the new secret: a.db, b.db")
+	assert.Equal(t, "Rekeyed a.db\nRekeyed b.db\n", w.String())
+}
@@ -0,0 +1,371 @@
+package internal
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// compactTxMaxSize is the maximum size of a transaction to copy the data while compacting a database.
+const compactTxMaxSize = 64 * 1024
+
+// backupDB writes a consistent copy of the database to the path.
+// It runs in a read transaction, so it can be done while the database is used.
+func backupDB(db *bolt.DB, path string) error {
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+}
+
+// Backup writes a consistent copy of the database to the path.
+func (s *Store) Backup(path string) error {
+	return backupDB(s.db, path)
+}
+
+// Backup writes a consistent copy of the cache database to the path.
+func (c *Cache) Backup(path string) error {
+	return backupDB(c.db, path)
+}
+
+// compactDBFile rewrites the database file to reclaim the unused space.
+// The database must not be opened while compacting it. It returns the file sizes before and after compacting.
+func compactDBFile(path string) (before int64, after int64, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = fi.Size()
+
+	src, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".compact"
+	_ = os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := bolt.Compact(dst, src, compactTxMaxSize); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return 0, 0, err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, 0, err
+	}
+	if err := src.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, 0, err
+	}
+
+	fi, err = os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, fi.Size(), nil
+}
+
+// validateDBFile checks that the file is a consistent database that has the buckets.
+func validateDBFile(path string, buckets ...string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if err != nil {
+		return fmt.Errorf("%s is not a valid database: %w", path, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		var checkErr error
+		for err := range tx.Check() {
+			// drain the channel to finish the check
+			if checkErr == nil {
+				checkErr = err
+			}
+		}
+		if checkErr != nil {
+			return fmt.Errorf("%s is corrupted: %w", path, checkErr)
+		}
+		for _, name := range buckets {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("%s does not have the bucket '%s'", path, name)
+			}
+		}
+		return nil
+	})
+}
+
+// restoreDBFile replaces the database file at dst with the file at src.
+// The database must not be opened while restoring it.
+func restoreDBFile(src string, dst string) (rErr error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := dst + ".restore"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// BucketStats is the statistics of a top-level bucket.
+type BucketStats struct {
+	Name string `json:"name"`
+	Keys int    `json:"keys"`
+	Size int    `json:"size"` // Bytes in use by the bucket
+}
+
+func dbBucketStats(db *bolt.DB) ([]*BucketStats, error) {
+	var stats []*BucketStats
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			s := b.Stats()
+			stats = append(stats, &BucketStats{
+				Name: string(name),
+				Keys: s.KeyN,
+				Size: s.BranchInuse + s.LeafInuse,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats, nil
+}
+
+// BucketStats returns the statistics of the buckets in the database.
+func (s *Store) BucketStats() ([]*BucketStats, error) {
+	return dbBucketStats(s.db)
+}
+
+// BucketStats returns the statistics of the buckets in the cache database.
+func (c *Cache) BucketStats() ([]*BucketStats, error) {
+	return dbBucketStats(c.db)
+}
+
+// ClearedName is a name of a conversation cleared by the repair because another conversation has the same name.
+type ClearedName struct {
+	Id   uint64
+	Name string
+}
+
+// Verify checks the consistency of the database and its indexes, and returns the found problems.
+// If repair is true, the indexes are rebuilt from the conversations, and the names cleared by the rebuild are returned.
+func (s *Store) Verify(repair bool) ([]string, []*ClearedName, error) {
+	var problems []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err.Error())
+		}
+		problems = append(problems, verifyIndexes(tx)...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cleared []*ClearedName
+	if repair && len(problems) > 0 {
+		if err := s.db.Update(func(tx *bolt.Tx) error {
+			_cleared, err := rebuildNameIndex(tx)
+			if err != nil {
+				return err
+			}
+			cleared = _cleared
+			if err := rebuildTimeIndexes(tx); err != nil {
+				return err
+			}
+			if err := rebuildPinnedIndex(tx); err != nil {
+				return err
+			}
+			if err := rebuildTagIndex(tx); err != nil {
+				return err
+			}
+			if err := pruneOrphanMessageEmbeddings(tx); err != nil {
+				return err
+			}
+			return rebuildSearchIndex(tx)
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return problems, cleared, nil
+}
+
+func verifyIndexes(tx *bolt.Tx) []string {
+	var problems []string
+	bc := tx.Bucket([]byte(BucketConversations))
+	bni := tx.Bucket([]byte(BucketNameIndex))
+	bci := tx.Bucket([]byte(BucketCreatedIndex))
+	bui := tx.Bucket([]byte(BucketUpdatedIndex))
+	bpi := tx.Bucket([]byte(BucketPinnedIndex))
+	bti := tx.Bucket([]byte(BucketTagIndex))
+	bsd := tx.Bucket([]byte(BucketSearchDocs))
+
+	count := 0
+	_ = bc.ForEach(func(k, v []byte) error {
+		count++
+		id := btouint64(k)
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			problems = append(problems, fmt.Sprintf("conversation %d can not be decoded: %v", id, err))
+			return nil
+		}
+		if co.Id != id {
+			problems = append(problems, fmt.Sprintf("conversation %d has a wrong id %d", id, co.Id))
+		}
+		if co.Name != "" {
+			if v := bni.Get([]byte(co.Name)); v == nil {
+				problems = append(problems, fmt.Sprintf("conversation %d: name '%s' is missing in the name index", id, co.Name))
+			} else if btouint64(v) != id {
+				problems = append(problems, fmt.Sprintf("conversation %d: name '%s' is indexed for conversation %d", id, co.Name, btouint64(v)))
+			}
+		}
+		if bci.Get(timeIndexKey(co.CreatedAt, id)) == nil {
+			problems = append(problems, fmt.Sprintf("conversation %d is missing in the created time index", id))
+		}
+		if bui.Get(timeIndexKey(co.LastUpdatedAt(), id)) == nil {
+			problems = append(problems, fmt.Sprintf("conversation %d is missing in the updated time index", id))
+		}
+		if bsd.Get(k) == nil {
+			problems = append(problems, fmt.Sprintf("conversation %d is missing in the search index", id))
+		}
+		if co.Pinned != (bpi.Get(k) != nil) {
+			problems = append(problems, fmt.Sprintf("conversation %d has a wrong entry in the pinned index", id))
+		}
+		for _, tag := range co.Tags {
+			if tb := bti.Bucket([]byte(tag)); tb == nil || tb.Get(k) == nil {
+				problems = append(problems, fmt.Sprintf("conversation %d: tag '%s' is missing in the tag index", id, tag))
+			}
+		}
+		return nil
+	})
+
+	_ = bpi.ForEach(func(k, _ []byte) error {
+		if bc.Get(k) == nil {
+			problems = append(problems, fmt.Sprintf("pinned index refers to the missing conversation %d", btouint64(k)))
+		}
+		return nil
+	})
+
+	_ = bni.ForEach(func(k, v []byte) error {
+		buf := bc.Get(v)
+		if buf == nil {
+			problems = append(problems, fmt.Sprintf("name '%s' refers to the missing conversation %d", k, btouint64(v)))
+			return nil
+		}
+		co := NewConversation()
+		if err := decodeConversation(tx, buf, co); err == nil && co.Name != string(k) {
+			problems = append(problems, fmt.Sprintf("name '%s' refers to conversation %d that is named '%s'", k, btouint64(v), co.Name))
+		}
+		return nil
+	})
+
+	if be := tx.Bucket([]byte(BucketMessageEmbeddings)); be != nil {
+		_ = be.ForEach(func(k, _ []byte) error {
+			if bc.Get(k) == nil {
+				problems = append(problems, fmt.Sprintf("message embeddings refer to the missing conversation %d", btouint64(k)))
+			}
+			return nil
+		})
+	}
+
+	for _, b := range []struct {
+		name   string
+		bucket *bolt.Bucket
+	}{
+		{"created time index", bci},
+		{"updated time index", bui},
+		{"search index", bsd},
+	} {
+		if n := b.bucket.Stats().KeyN; n != count {
+			problems = append(problems, fmt.Sprintf("%s has %d entries for %d conversations", b.name, n, count))
+		}
+	}
+	return problems
+}
+
+// rebuildNameIndex drops and rebuilds the name index in the transaction.
+// If multiple conversations have the same name, the oldest conversation keeps the name,
+// and the names of the others are cleared and returned.
+func rebuildNameIndex(tx *bolt.Tx) ([]*ClearedName, error) {
+	if err := tx.DeleteBucket([]byte(BucketNameIndex)); err != nil && err != bolt.ErrBucketNotFound {
+		return nil, err
+	}
+	bni, err := tx.CreateBucket([]byte(BucketNameIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	bc := tx.Bucket([]byte(BucketConversations))
+	var renamed []*Conversation
+	var cleared []*ClearedName
+	if err := bc.ForEach(func(k, v []byte) error {
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			return err
+		}
+		if co.Name == "" {
+			return nil
+		}
+		if bni.Get([]byte(co.Name)) != nil {
+			cleared = append(cleared, &ClearedName{Id: co.Id, Name: co.Name})
+			co.Name = ""
+			renamed = append(renamed, co)
+			return nil
+		}
+		return bni.Put([]byte(co.Name), append([]byte{}, k...))
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, co := range renamed {
+		buf, err := encodeConversation(tx, co)
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.Put(uint64tob(co.Id), buf); err != nil {
+			return nil, err
+		}
+	}
+	return cleared, nil
+}
+
+// fileSize returns the size of the file, or 0 if it does not exist.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Verify(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+	assert.NoError(t, s.RenameConversationByKey(NewConversationKey("1"), "nginx"))
+
+	problems, _, err := s.Verify(false)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// break the name index
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bni := tx.Bucket([]byte(BucketNameIndex))
+		if err := bni.Delete([]byte("nginx")); err != nil {
+			return err
+		}
+		return bni.Put([]byte("orphan"), uint64tob(100))
+	})
+	assert.NoError(t, err)
+
+	problems, _, err = s.Verify(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"conversation 1: name 'nginx' is missing in the name index",
+		"name 'orphan' refers to the missing conversation 100",
+	}, problems)
+
+	problems, _, err = s.Verify(true)
+	assert.NoError(t, err)
+	assert.Len(t, problems, 2)
+
+	problems, _, err = s.Verify(false)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+	co, err := s.GetConversationByName("nginx")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), co.Id)
+}
+
+func TestStore_Verify_DuplicateNames(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+	assert.NoError(t, s.RenameConversationByKey(NewConversationKey("1"), "nginx"))
+	testDuplicateConversationName(t, s, 3, "nginx")
+
+	problems, cleared, err := s.Verify(true)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, problems)
+	assert.Equal(t, []*ClearedName{{Id: 3, Name: "nginx"}}, cleared)
+
+	co, err := s.GetConversationById(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "", co.Name)
+	co, err = s.GetConversationByName("nginx")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), co.Id)
+}
+
+// testDuplicateConversationName sets the name to the conversation without updating the name index.
+func testDuplicateConversationName(t *testing.T, s *Store, id uint64, name string) {
+	t.Helper()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bc := tx.Bucket([]byte(BucketConversations))
+		co := NewConversation()
+		if err := decodeConversation(tx, bc.Get(uint64tob(id)), co); err != nil {
+			return err
+		}
+		co.Name = name
+		buf, err := encodeConversation(tx, co)
+		if err != nil {
+			return err
+		}
+		return bc.Put(uint64tob(id), buf)
+	})
+	assert.NoError(t, err)
+}
+
+func TestCompactDBFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gptx.db")
+	sm := &StoreManager{DBPath: path}
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.Init())
+	for i := 0; i < 20; i++ {
+		testCreateSearchConversations(t, s)
+	}
+	for i := uint64(1); i <= 60; i++ {
+		assert.NoError(t, s.DeleteConversationById(i))
+	}
+	assert.NoError(t, sm.Close())
+
+	before, after, err := compactDBFile(path)
+	assert.NoError(t, err)
+	assert.Less(t, after, before)
+	assert.NoError(t, validateDBFile(path, BucketConversations, BucketNameIndex))
+}
+
+func TestValidateDBFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.db")
+	assert.NoError(t, os.WriteFile(path, []byte("not a database"), 0600))
+	assert.Error(t, validateDBFile(path))
+
+	sm := testStoreManager(t)
+	assert.NoError(t, sm.Close())
+	assert.NoError(t, validateDBFile(sm.DBPath, BucketConversations))
+	assert.Error(t, validateDBFile(sm.DBPath, "unknown"))
+}
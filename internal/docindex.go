@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// IndexBucketInfo holds the metadata of the index (IndexInfo).
+	IndexBucketInfo = "info"
+
+	// IndexBucketChunks holds the chunks of the documents and their embeddings (IndexChunk) by the sequence numbers.
+	IndexBucketChunks = "chunks"
+)
+
+var indexInfoKey = []byte("info")
+
+var indexNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// IndexNotFoundError is returned when the index has not been built.
+type IndexNotFoundError struct {
+	Name string
+}
+
+func (e *IndexNotFoundError) Error() string {
+	return fmt.Sprintf("index not found: %s. build it with 'gptx index <dir> --name %s'", e.Name, e.Name)
+}
+
+// IndexInfo is the metadata of an index of the documents.
+type IndexInfo struct {
+	Name      string    `json:"name"`
+	Dir       string    `json:"dir"`   // The absolute path of the indexed directory
+	Model     string    `json:"model"` // The name of the embedder. Only the embeddings of the same model can be compared
+	Files     int       `json:"files"`
+	Chunks    int       `json:"chunks"`
+	IndexedAt time.Time `json:"indexed_at"`
+}
+
+// IndexChunk is a part of a document with its embedding.
+type IndexChunk struct {
+	Id        uint64    `json:"id"`
+	Path      string    `json:"path"`       // The path of the document relative to the indexed directory
+	StartLine int       `json:"start_line"` // The first line of the chunk (1-based)
+	EndLine   int       `json:"end_line"`   // The last line of the chunk
+	Text      string    `json:"text"`
+	Vector    []float32 `json:"vector"`
+}
+
+// ScoredChunk is a chunk returned by the search with its similarity to the query.
+type ScoredChunk struct {
+	*IndexChunk
+	Score float64
+}
+
+// IndexManager opens the indexes of the documents. Each index is a database file in the indexes directory.
+// Unlike the store and the cache, the indexes are opened only when they are used.
+type IndexManager struct {
+	PathResolver *PathResolver
+	// KeyResolver resolves the secret to open the encrypted indexes.
+	KeyResolver *EncryptionKeyResolver
+	// Encrypt encrypts the indexes when they are opened if they are not encrypted yet.
+	Encrypt bool
+}
+
+func checkValidIndexName(name string) error {
+	if !indexNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid index name: %q (use letters, digits, '.', '_' and '-')", name)
+	}
+	return nil
+}
+
+// Open opens the index. If create is false, it returns IndexNotFoundError if the index does not exist.
+func (m *IndexManager) Open(name string, create bool) (*DocIndex, error) {
+	if err := checkValidIndexName(name); err != nil {
+		return nil, err
+	}
+	path := m.PathResolver.IndexFilePath(name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if !create {
+			return nil, &IndexNotFoundError{Name: name}
+		}
+		if err := os.MkdirAll(m.PathResolver.IndexesDir(), os.FileMode(0700)); err != nil {
+			return nil, err
+		}
+	}
+
+	opt := *bolt.DefaultOptions
+	opt.Timeout = 1 * time.Second
+	db, err := bolt.Open(path, os.FileMode(0600), &opt)
+	if err != nil {
+		return nil, err
+	}
+	idx := &DocIndex{Name: name, db: db}
+	if err := idx.init(m); err != nil {
+		_ = idx.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// List returns the names of the indexes.
+func (m *IndexManager) List() ([]string, error) {
+	entries, err := os.ReadDir(m.PathResolver.IndexesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".db" {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), ".db"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DocIndex is a local vector store of the chunks of the documents.
+type DocIndex struct {
+	Name string
+	db   *bolt.DB
+}
+
+func (idx *DocIndex) init(m *IndexManager) error {
+	if err := idx.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{IndexBucketInfo, IndexBucketChunks} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	vc, err := unlockDB(idx.db, m.KeyResolver)
+	if err != nil {
+		return err
+	}
+	registerValueCipher(idx.db, vc)
+	if m.Encrypt && vc == nil {
+		if m.KeyResolver == nil {
+			return ErrEncryptionKeyRequired
+		}
+		secret, err := m.KeyResolver.Resolve()
+		if err != nil {
+			return err
+		}
+		if _, err := encryptDB(idx.db, secret, reencryptIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *DocIndex) Close() error {
+	if idx.db == nil {
+		// already closed
+		return nil
+	}
+	registerValueCipher(idx.db, nil)
+	if err := idx.db.Close(); err != nil {
+		return err
+	}
+	idx.db = nil
+	return nil
+}
+
+// Path returns the path of the database file of the index.
+func (idx *DocIndex) Path() string {
+	return idx.db.Path()
+}
+
+// Info returns the metadata of the index. It returns nil if the index has not been built yet.
+func (idx *DocIndex) Info() (*IndexInfo, error) {
+	var info *IndexInfo
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(IndexBucketInfo)).Get(indexInfoKey)
+		if v == nil {
+			return nil
+		}
+		buf, err := openValue(txCipher(tx), v)
+		if err != nil {
+			return err
+		}
+		info = &IndexInfo{}
+		return json.Unmarshal(buf, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Chunks returns all the chunks of the index.
+func (idx *DocIndex) Chunks() ([]*IndexChunk, error) {
+	var chunks []*IndexChunk
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(IndexBucketChunks)).ForEach(func(_, v []byte) error {
+			buf, err := openValue(txCipher(tx), v)
+			if err != nil {
+				return err
+			}
+			chunk := &IndexChunk{}
+			if err := json.Unmarshal(buf, chunk); err != nil {
+				return err
+			}
+			chunks = append(chunks, chunk)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// Replace replaces all the chunks of the index. The ids of the chunks are assigned by this method.
+func (idx *DocIndex) Replace(info *IndexInfo, chunks []*IndexChunk) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(IndexBucketChunks)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(IndexBucketChunks))
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			chunk.Id = id
+			buf, err := json.Marshal(chunk)
+			if err != nil {
+				return err
+			}
+			buf, err = sealValue(txCipher(tx), buf)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(uint64tob(id), buf); err != nil {
+				return err
+			}
+		}
+
+		info.Chunks = len(chunks)
+		buf, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		buf, err = sealValue(txCipher(tx), buf)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(IndexBucketInfo)).Put(indexInfoKey, buf)
+	})
+}
+
+// Search returns at most topK chunks most similar to the vector. The chunks less similar than minScore are ignored.
+func (idx *DocIndex) Search(vector []float32, topK int, minScore float64) ([]*ScoredChunk, error) {
+	chunks, err := idx.Chunks()
+	if err != nil {
+		return nil, err
+	}
+	var scored []*ScoredChunk
+	for _, chunk := range chunks {
+		score := cosineSimilarity(vector, chunk.Vector)
+		if score < minScore {
+			continue
+		}
+		scored = append(scored, &ScoredChunk{IndexChunk: chunk, Score: score})
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	if topK > 0 && len(scored) > topK {
+		scored = scored[:topK]
+	}
+	return scored, nil
+}
+
+func (idx *DocIndex) IsEncrypted() (bool, error) {
+	encrypted := false
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		encrypted = isEncryptedDB(tx)
+		return nil
+	})
+	return encrypted, err
+}
+
+// Rekey changes the secret of the encrypted index. If rotateDataKey is true, all the chunks are re-encrypted with a new data key.
+func (idx *DocIndex) Rekey(resolver *EncryptionKeyResolver, newSecret string, rotateDataKey bool) error {
+	_, err := rekeyDatabase(idx.db, resolver, newSecret, rotateDataKey, reencryptIndex)
+	return err
+}
+
+// reencryptIndex re-encrypts the values of the index database.
+func reencryptIndex(tx *bolt.Tx, old *ValueCipher) error {
+	for _, name := range []string{IndexBucketInfo, IndexBucketChunks} {
+		if err := reencryptBucket(tx, name, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"testing"
+)
+
+func testIndexManager(t *testing.T) *IndexManager {
+	t.Helper()
+	return &IndexManager{PathResolver: NewPathResolver(t.TempDir())}
+}
+
+func TestIndexManager_Open(t *testing.T) {
+	m := testIndexManager(t)
+
+	_, err := m.Open("docs", false)
+	assert.ErrorAs(t, err, new(*IndexNotFoundError))
+	_, err = m.Open("../docs", true)
+	assert.Error(t, err)
+
+	idx, err := m.Open("docs", true)
+	assert.NoError(t, err)
+	info, err := idx.Info()
+	assert.NoError(t, err)
+	assert.Nil(t, info)
+	assert.NoError(t, idx.Close())
+
+	names, err := m.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docs"}, names)
+}
+
+func TestDocIndex_Search(t *testing.T) {
+	m := testIndexManager(t)
+	idx, err := m.Open("docs", true)
+	assert.NoError(t, err)
+	defer idx.Close()
+
+	err = idx.Replace(&IndexInfo{Name: "docs", Model: "test"}, []*IndexChunk{
+		{Path: "a.md", StartLine: 1, EndLine: 2, Text: "a", Vector: []float32{1, 0}},
+		{Path: "b.md", StartLine: 1, EndLine: 5, Text: "b", Vector: []float32{0, 1}},
+		{Path: "c.md", StartLine: 3, EndLine: 4, Text: "c", Vector: []float32{1, 1}},
+	})
+	assert.NoError(t, err)
+
+	info, err := idx.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, info.Chunks)
+
+	chunks, err := idx.Search([]float32{1, 0.1}, 2, 0)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, "a.md", chunks[0].Path)
+	assert.Equal(t, "c.md", chunks[1].Path)
+
+	chunks, err = idx.Search([]float32{1, 0.1}, 0, 0.9)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 1)
+
+	// the chunks are replaced
+	err = idx.Replace(&IndexInfo{Name: "docs", Model: "test"}, []*IndexChunk{
+		{Path: "d.md", StartLine: 1, EndLine: 1, Text: "d", Vector: []float32{1, 0}},
+	})
+	assert.NoError(t, err)
+	all, err := idx.Chunks()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+	assert.Equal(t, uint64(1), all[0].Id)
+}
+
+func TestDocIndex_Encryption(t *testing.T) {
+	m := testIndexManager(t)
+	idx, err := m.Open("docs", true)
+	assert.NoError(t, err)
+	assert.NoError(t, idx.Replace(&IndexInfo{Name: "docs", Model: "test"}, []*IndexChunk{
+		{Path: "a.md", StartLine: 1, EndLine: 1, Text: "secret runbook", Vector: []float32{1, 0}},
+	}))
+	assert.NoError(t, idx.Close())
+
+	t.Setenv("GPTX_PASSPHRASE", "secret")
+	m.KeyResolver = NewEncryptionKeyResolver(&EncryptionConfig{})
+	m.Encrypt = true
+	idx, err = m.Open("docs", false)
+	assert.NoError(t, err)
+	encrypted, err := idx.IsEncrypted()
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+	assert.NoError(t, idx.db.View(func(tx *bolt.Tx) error {
+		assert.True(t, isSealedValue(tx.Bucket([]byte(IndexBucketChunks)).Get(uint64tob(1))))
+		return nil
+	}))
+	assert.NoError(t, idx.Close())
+
+	// the encrypted index can not be opened without the secret
+	_, err = (&IndexManager{PathResolver: m.PathResolver}).Open("docs", false)
+	assert.ErrorIs(t, err, ErrEncryptionKeyRequired)
+
+	idx, err = m.Open("docs", false)
+	assert.NoError(t, err)
+	defer idx.Close()
+	chunks, err := idx.Chunks()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret runbook", chunks[0].Text)
+}
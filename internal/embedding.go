@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultEmbeddingModel is the default model of the embeddings API.
+const defaultEmbeddingModel = "text-embedding-ada-002"
+
+// Embedder computes the embedding vectors of texts.
+type Embedder interface {
+	// Embed returns the embedding of the text.
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// Name returns the name of the model, which identifies the embeddings that can be compared with each other.
+	Name() string
+}
+
+// NewEmbedder creates an Embedder from the config.
+// The command is used instead of the embeddings API if it is set.
+func NewEmbedder(config *EmbeddingConfig, clientConfig openai.ClientConfig) Embedder {
+	if config.Command != "" {
+		return &CommandEmbedder{Command: config.Command}
+	}
+	model := config.Model
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	return &OpenAIEmbedder{ClientConfig: clientConfig, Model: model}
+}
+
+// OpenAIEmbedder computes the embeddings with the embeddings API of the provider.
+type OpenAIEmbedder struct {
+	ClientConfig openai.ClientConfig
+	Model        string
+}
+
+type embeddingRequest struct {
+	Input []string `json:"input"`
+	Model string   `json:"model"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *openai.APIError `json:"error,omitempty"`
+}
+
+// Embed sends the text to the embeddings API.
+// The request is built by itself because the client of the library only accepts the known models.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(&embeddingRequest{Input: []string{text}, Model: e.Model})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.ClientConfig.BaseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.ClientConfig.OrgID != "" {
+		req.Header.Set("OpenAI-Organization", e.ClientConfig.OrgID)
+	}
+	client := e.ClientConfig.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	ret := &embeddingResponse{}
+	if err := json.Unmarshal(b, ret); err != nil {
+		return nil, fmt.Errorf("invalid response of the embeddings API (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if ret.Error != nil {
+			ret.Error.StatusCode = resp.StatusCode
+			return nil, ret.Error
+		}
+		return nil, fmt.Errorf("the embeddings API returned status %d", resp.StatusCode)
+	}
+	if len(ret.Data) == 0 || len(ret.Data[0].Embedding) == 0 {
+		return nil, errors.New("the embeddings API returned no embedding")
+	}
+	return ret.Data[0].Embedding, nil
+}
+
+func (e *OpenAIEmbedder) Name() string {
+	return e.Model
+}
+
+// CommandEmbedder computes the embeddings with a local command such as a script that runs a local model.
+// The command reads the text from the standard input, and prints the embedding as a JSON array of numbers.
+type CommandEmbedder struct {
+	Command string
+}
+
+func (e *CommandEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	stdout := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "sh", "-c", e.Command)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run the embedding command: %w", err)
+	}
+	var v []float32
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+		return nil, fmt.Errorf("the embedding command must print a JSON array of numbers: %w", err)
+	}
+	if len(v) == 0 {
+		return nil, errors.New("the embedding command returned an empty embedding")
+	}
+	return v, nil
+}
+
+func (e *CommandEmbedder) Name() string {
+	return "command:" + e.Command
+}
+
+// cosineSimilarity returns the cosine similarity of the vectors. It returns 0 if their dimensions differ.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// embed computes the embedding of the text, and records the request to the audit log if it is sent to the API.
+func (c *ChatService) embed(ctx context.Context, text string) ([]float32, error) {
+	v, err := c.Embedder.Embed(ctx, text)
+	if _, ok := c.Embedder.(*OpenAIEmbedder); !ok || c.AuditLog == nil {
+		return v, err
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	e := newAuditEntry(AuditKindEmbedding, AuditCacheOff, c.Conversation)
+	e.Time = time.Now().UTC()
+	e.Model = c.Embedder.Name()
+	e.Endpoint = c.ClientConfig.BaseURL
+	e.RequestHash = hex.EncodeToString(sum[:])
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if c.AuditLog.IncludePrompts {
+		e.Messages = []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: text}}
+	}
+	if aerr := c.AuditLog.Append(e); aerr != nil {
+		return nil, fmt.Errorf("failed to write the audit log: %w", aerr)
+	}
+	return v, err
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	assert.InDelta(t, 1.0, cosineSimilarity([]float32{1, 2}, []float32{2, 4}), 1e-9)
+	assert.InDelta(t, 0.0, cosineSimilarity([]float32{1, 0}, []float32{0, 1}), 1e-9)
+	assert.InDelta(t, -1.0, cosineSimilarity([]float32{1, 0}, []float32{-1, 0}), 1e-9)
+	assert.Equal(t, 0.0, cosineSimilarity([]float32{1, 0}, []float32{1, 0, 0}))
+	assert.Equal(t, 0.0, cosineSimilarity([]float32{0, 0}, []float32{1, 0}))
+}
+
+func TestOpenAIEmbedder_Embed(t *testing.T) {
+	ms, err := mockserver.New(nil)
+	assert.NoError(t, err)
+	ts := httptest.NewServer(ms)
+	defer ts.Close()
+
+	config := openai.DefaultConfig("sk-dummykey")
+	config.BaseURL = ts.URL + "/v1"
+	e := NewEmbedder(&EmbeddingConfig{Model: "text-embedding-3-small"}, config)
+	assert.Equal(t, "text-embedding-3-small", e.Name())
+
+	v, err := e.Embed(context.Background(), "What is the capital of Japan?")
+	assert.NoError(t, err)
+	assert.Equal(t, mockserver.Embed("What is the capital of Japan?"), v)
+	assert.Equal(t, "text-embedding-3-small", ms.EmbeddingRequests()[0].Model)
+
+	// an error of the API
+	ets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": {"message": "invalid api key", "type": "invalid_request_error"}}`))
+	}))
+	defer ets.Close()
+	config.BaseURL = ets.URL + "/v1"
+	_, err = NewEmbedder(&EmbeddingConfig{}, config).Embed(context.Background(), "hello")
+	apiErr := &openai.APIError{}
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	assert.Equal(t, "invalid api key", apiErr.Message)
+}
+
+func TestCommandEmbedder_Embed(t *testing.T) {
+	e := NewEmbedder(&EmbeddingConfig{Command: `test "$(cat)" = hello && echo '[0.5, 1]'`}, openai.DefaultConfig(""))
+	v, err := e.Embed(context.Background(), "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{0.5, 1}, v)
+
+	_, err = e.Embed(context.Background(), "bye")
+	assert.Error(t, err)
+
+	_, err = NewEmbedder(&EmbeddingConfig{Command: "echo not-json"}, openai.DefaultConfig("")).Embed(context.Background(), "hello")
+	assert.Error(t, err)
+}
@@ -0,0 +1,551 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"sync"
+)
+
+// Encryption at rest
+//
+// The values in the databases are encrypted with a random data key (AES-256-GCM).
+// The data key is stored in the meta bucket of each database, encrypted with a key encryption key
+// that is derived from the secret configured in the [encryption] section (PBKDF2-HMAC-SHA256).
+// So rotating the secret only re-encrypts the data key, and the values are re-encrypted only when the data key is rotated.
+//
+// The keys of the search index are HMACs of the terms, so that the terms of the conversations are not stored in plaintext.
+// The names of the conversations and the tags are stored in plaintext as the keys of their indexes.
+
+const (
+	metaKeyEncryptionKey        = "encryption_key"
+	metaKeyEncryptionSalt       = "encryption_salt"
+	metaKeyEncryptionIterations = "encryption_iterations"
+)
+
+// encryptionKDFIterations is the number of PBKDF2 iterations to derive the key encryption key.
+// It is stored in the database, so changing it does not affect the existing databases.
+var encryptionKDFIterations = 200000
+
+// sealedValuePrefix is the prefix of the encrypted values. Plain values are JSON, so they never start with 0x00.
+var sealedValuePrefix = []byte{0x00, 'G', 'X', 0x01}
+
+// ErrEncryptionKeyRequired is returned when an encrypted database is opened without the encryption key.
+var ErrEncryptionKeyRequired = errors.New("the database is encrypted. configure the [encryption] section in the config to open it")
+
+// ErrInvalidEncryptionKey is returned when the configured secret can not decrypt the data key of the database.
+var ErrInvalidEncryptionKey = errors.New("the encryption key is invalid. check the [encryption] section in the config")
+
+// EncryptionKeyResolver resolves the secret to encrypt the databases from the config.
+// Like APIKeyResolver, the secret is resolved lazily on the first call of Resolve.
+//
+// The sources are checked in the following order:
+//
+//  1. key_file
+//  2. key_command
+//  3. the environment variable named by passphrase_env
+//  4. the GPTX_PASSPHRASE environment variable
+type EncryptionKeyResolver struct {
+	Config   *EncryptionConfig
+	secret   string
+	resolved bool
+	lock     sync.Mutex
+}
+
+func NewEncryptionKeyResolver(config *EncryptionConfig) *EncryptionKeyResolver {
+	return &EncryptionKeyResolver{
+		Config: config,
+	}
+}
+
+// Resolve returns the secret. It returns an error if no secret is configured.
+func (r *EncryptionKeyResolver) Resolve() (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.resolved {
+		return r.secret, nil
+	}
+
+	secret, err := r.resolve()
+	if err != nil {
+		return "", err
+	}
+	r.secret = secret
+	r.resolved = true
+	return secret, nil
+}
+
+func (r *EncryptionKeyResolver) resolve() (string, error) {
+	c := r.Config
+	if c.KeyFile != "" {
+		return readSecretFile("encryption.key_file", c.KeyFile)
+	}
+	if c.KeyCommand != "" {
+		return runSecretCommand("encryption.key_command", c.KeyCommand)
+	}
+	if c.PassphraseEnv != "" {
+		secret := os.Getenv(c.PassphraseEnv)
+		if secret == "" {
+			return "", fmt.Errorf("environment variable '%s' specified by encryption.passphrase_env is empty", c.PassphraseEnv)
+		}
+		return secret, nil
+	}
+	if secret := os.Getenv("GPTX_PASSPHRASE"); secret != "" {
+		return secret, nil
+	}
+	return "", errors.New("no encryption key is configured. set key_file, key_command or passphrase_env in the [encryption] section, or the GPTX_PASSPHRASE environment variable")
+}
+
+// ValueCipher encrypts and decrypts the values stored in a database with its data key.
+type ValueCipher struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+func newValueCipher(dataKey []byte) (*ValueCipher, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	// the index key is derived from the data key so that it is rotated together
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("gptx search index"))
+	return &ValueCipher{
+		aead:     aead,
+		indexKey: mac.Sum(nil),
+	}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts the value.
+func (c *ValueCipher) Seal(value []byte) ([]byte, error) {
+	return sealWithAEAD(c.aead, value)
+}
+
+// Open decrypts the value encrypted by Seal.
+func (c *ValueCipher) Open(buf []byte) ([]byte, error) {
+	return openWithAEAD(c.aead, buf)
+}
+
+// IndexKey returns the key of the term in the search index.
+func (c *ValueCipher) IndexKey(term string) []byte {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(term))
+	return mac.Sum(nil)[:16]
+}
+
+func sealWithAEAD(aead cipher.AEAD, value []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(sealedValuePrefix)+len(nonce)+len(value)+aead.Overhead())
+	buf = append(buf, sealedValuePrefix...)
+	buf = append(buf, nonce...)
+	return aead.Seal(buf, nonce, value, nil), nil
+}
+
+func openWithAEAD(aead cipher.AEAD, buf []byte) ([]byte, error) {
+	if !isSealedValue(buf) || len(buf) < len(sealedValuePrefix)+aead.NonceSize() {
+		return nil, errors.New("the value is not encrypted")
+	}
+	buf = buf[len(sealedValuePrefix):]
+	return aead.Open(nil, buf[:aead.NonceSize()], buf[aead.NonceSize():], nil)
+}
+
+func isSealedValue(buf []byte) bool {
+	return bytes.HasPrefix(buf, sealedValuePrefix)
+}
+
+// sealValue encrypts the value if the cipher is not nil.
+func sealValue(c *ValueCipher, value []byte) ([]byte, error) {
+	if c == nil {
+		return value, nil
+	}
+	return c.Seal(value)
+}
+
+// openValue decrypts the value if it is encrypted.
+// Plain values are returned as they are, so that the values stored before enabling the encryption can be read.
+func openValue(c *ValueCipher, buf []byte) ([]byte, error) {
+	if !isSealedValue(buf) {
+		return buf, nil
+	}
+	if c == nil {
+		return nil, ErrEncryptionKeyRequired
+	}
+	return c.Open(buf)
+}
+
+// valueCiphers maps the opened databases to their ciphers.
+// The functions working in a transaction look up the cipher with tx.DB().
+var valueCiphers sync.Map
+
+func registerValueCipher(db *bolt.DB, c *ValueCipher) {
+	if c == nil {
+		valueCiphers.Delete(db)
+		return
+	}
+	valueCiphers.Store(db, c)
+}
+
+// txCipher returns the cipher of the database of the transaction, or nil if it is not encrypted.
+func txCipher(tx *bolt.Tx) *ValueCipher {
+	if c, ok := valueCiphers.Load(tx.DB()); ok {
+		return c.(*ValueCipher)
+	}
+	return nil
+}
+
+// pbkdf2SHA256 derives a key from the secret (RFC 8018).
+func pbkdf2SHA256(secret []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, secret)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	key := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// isEncryptedDB returns true if the database of the transaction has a data key.
+func isEncryptedDB(tx *bolt.Tx) bool {
+	b := tx.Bucket([]byte(BucketMeta))
+	return b != nil && b.Get([]byte(metaKeyEncryptionKey)) != nil
+}
+
+// unwrapDataKey decrypts the data key stored in the database with the secret.
+func unwrapDataKey(tx *bolt.Tx, secret string) ([]byte, error) {
+	b := tx.Bucket([]byte(BucketMeta))
+	wrapped := b.Get([]byte(metaKeyEncryptionKey))
+	salt := b.Get([]byte(metaKeyEncryptionSalt))
+	iterations := b.Get([]byte(metaKeyEncryptionIterations))
+	if wrapped == nil || salt == nil || iterations == nil {
+		return nil, errors.New("the encryption metadata of the database is broken")
+	}
+	aead, err := newAEAD(pbkdf2SHA256([]byte(secret), salt, int(btouint64(iterations)), 32))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openWithAEAD(aead, wrapped)
+	if err != nil {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return dataKey, nil
+}
+
+// putDataKey encrypts the data key with the secret and stores it in the database.
+func putDataKey(tx *bolt.Tx, secret string, dataKey []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(BucketMeta))
+	if err != nil {
+		return err
+	}
+	salt, err := randomBytes(16)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(pbkdf2SHA256([]byte(secret), salt, encryptionKDFIterations, 32))
+	if err != nil {
+		return err
+	}
+	wrapped, err := sealWithAEAD(aead, dataKey)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(metaKeyEncryptionSalt), salt); err != nil {
+		return err
+	}
+	if err := b.Put([]byte(metaKeyEncryptionIterations), uint64tob(uint64(encryptionKDFIterations))); err != nil {
+		return err
+	}
+	return b.Put([]byte(metaKeyEncryptionKey), wrapped)
+}
+
+// unlockDB loads the data key of the encrypted database.
+// It returns nil if the database is not encrypted.
+func unlockDB(db *bolt.DB, resolver *EncryptionKeyResolver) (*ValueCipher, error) {
+	var c *ValueCipher
+	err := db.View(func(tx *bolt.Tx) error {
+		if !isEncryptedDB(tx) {
+			return nil
+		}
+		if resolver == nil {
+			return ErrEncryptionKeyRequired
+		}
+		secret, err := resolver.Resolve()
+		if err != nil {
+			return err
+		}
+		dataKey, err := unwrapDataKey(tx, secret)
+		if err != nil {
+			return err
+		}
+		c, err = newValueCipher(dataKey)
+		return err
+	})
+	return c, err
+}
+
+// encryptDB generates a new data key, stores it with the secret and re-encrypts the values with the function in a transaction.
+// It is used to encrypt a plain database and to rotate the data key.
+// The new cipher is registered before the transaction, so the values encoded in the function are encrypted with the new data key.
+func encryptDB(db *bolt.DB, secret string, reencrypt func(tx *bolt.Tx, old *ValueCipher) error) (*ValueCipher, error) {
+	dataKey, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newValueCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var old *ValueCipher
+	if v, ok := valueCiphers.Load(db); ok {
+		old = v.(*ValueCipher)
+	}
+	registerValueCipher(db, c)
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := reencrypt(tx, old); err != nil {
+			return err
+		}
+		return putDataKey(tx, secret, dataKey)
+	})
+	if err != nil {
+		registerValueCipher(db, old)
+		return nil, err
+	}
+	return c, nil
+}
+
+// reencryptBucket re-encrypts the values of the bucket that were encrypted with the old cipher (or not encrypted) with the cipher of the transaction.
+func reencryptBucket(tx *bolt.Tx, name string, old *ValueCipher) error {
+	b := tx.Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	type record struct {
+		key   []byte
+		value []byte
+	}
+	var records []record
+	if err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			// nested bucket
+			return nil
+		}
+		plain, err := openValue(old, v)
+		if err != nil {
+			return err
+		}
+		sealed, err := sealValue(txCipher(tx), plain)
+		if err != nil {
+			return err
+		}
+		// keys are only valid during the iteration, so they are copied
+		records = append(records, record{key: append([]byte{}, k...), value: sealed})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// the bucket must not be modified during the iteration
+	for _, r := range records {
+		if err := b.Put(r.key, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rekeyDB re-encrypts the data key of the database with the new secret.
+func rekeyDB(db *bolt.DB, oldSecret string, newSecret string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		dataKey, err := unwrapDataKey(tx, oldSecret)
+		if err != nil {
+			return err
+		}
+		return putDataKey(tx, newSecret, dataKey)
+	})
+}
+
+// rekeyDatabase changes the secret of the encrypted database.
+// If rotateDataKey is true, the data key is also rotated and the values are re-encrypted with the function.
+// It returns the new cipher if the data key is rotated.
+func rekeyDatabase(db *bolt.DB, resolver *EncryptionKeyResolver, newSecret string, rotateDataKey bool, reencrypt func(tx *bolt.Tx, old *ValueCipher) error) (*ValueCipher, error) {
+	encrypted := false
+	if err := db.View(func(tx *bolt.Tx) error {
+		encrypted = isEncryptedDB(tx)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return nil, fmt.Errorf("%s is not encrypted", db.Path())
+	}
+	if resolver == nil {
+		return nil, ErrEncryptionKeyRequired
+	}
+	oldSecret, err := resolver.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if rotateDataKey {
+		// the current data key has been unlocked with the old secret when the database was initialized
+		return encryptDB(db, newSecret, reencrypt)
+	}
+	return nil, rekeyDB(db, oldSecret, newSecret)
+}
+
+func (s *Store) setCipher(c *ValueCipher) {
+	s.m.cipher = c
+	registerValueCipher(s.db, c)
+}
+
+// IsEncrypted returns true if the database is encrypted.
+func (s *Store) IsEncrypted() (bool, error) {
+	encrypted := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		encrypted = isEncryptedDB(tx)
+		return nil
+	})
+	return encrypted, err
+}
+
+// encrypt encrypts the plain database with a new data key.
+func (s *Store) encrypt() error {
+	if s.m.KeyResolver == nil {
+		return ErrEncryptionKeyRequired
+	}
+	secret, err := s.m.KeyResolver.Resolve()
+	if err != nil {
+		return err
+	}
+	c, err := encryptDB(s.db, secret, reencryptStore)
+	if err != nil {
+		return err
+	}
+	s.m.cipher = c
+	return nil
+}
+
+// Rekey changes the secret of the encrypted database. If rotateDataKey is true, all the conversations are re-encrypted with a new data key.
+func (s *Store) Rekey(newSecret string, rotateDataKey bool) error {
+	c, err := rekeyDatabase(s.db, s.m.KeyResolver, newSecret, rotateDataKey, reencryptStore)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		s.m.cipher = c
+	}
+	return nil
+}
+
+// reencryptStore re-encrypts the conversations and the embeddings of the messages, and rebuilds the search index whose keys depend on the data key.
+func reencryptStore(tx *bolt.Tx, old *ValueCipher) error {
+	if err := reencryptBucket(tx, BucketConversations, old); err != nil {
+		return err
+	}
+	if err := reencryptMessageEmbeddings(tx, old); err != nil {
+		return err
+	}
+	return rebuildSearchIndex(tx)
+}
+
+func (c *Cache) setCipher(vc *ValueCipher) {
+	c.m.cipher = vc
+	registerValueCipher(c.db, vc)
+}
+
+// IsEncrypted returns true if the cache database is encrypted.
+func (c *Cache) IsEncrypted() (bool, error) {
+	encrypted := false
+	err := c.db.View(func(tx *bolt.Tx) error {
+		encrypted = isEncryptedDB(tx)
+		return nil
+	})
+	return encrypted, err
+}
+
+// encrypt encrypts the plain cache database with a new data key.
+func (c *Cache) encrypt() error {
+	if c.m.KeyResolver == nil {
+		return ErrEncryptionKeyRequired
+	}
+	secret, err := c.m.KeyResolver.Resolve()
+	if err != nil {
+		return err
+	}
+	vc, err := encryptDB(c.db, secret, reencryptCache)
+	if err != nil {
+		return err
+	}
+	c.m.cipher = vc
+	return nil
+}
+
+// Rekey changes the secret of the encrypted cache database. If rotateDataKey is true, all the cached responses are re-encrypted with a new data key.
+func (c *Cache) Rekey(newSecret string, rotateDataKey bool) error {
+	vc, err := rekeyDatabase(c.db, c.m.KeyResolver, newSecret, rotateDataKey, reencryptCache)
+	if err != nil {
+		return err
+	}
+	if vc != nil {
+		c.m.cipher = vc
+	}
+	return nil
+}
+
+func reencryptCache(tx *bolt.Tx, old *ValueCipher) error {
+	if err := reencryptBucket(tx, CacheBucketCaches, old); err != nil {
+		return err
+	}
+	for _, name := range []string{CacheBucketItems, CacheBucketEmbeddings} {
+		if tx.Bucket([]byte(name)) == nil {
+			continue
+		}
+		if err := reencryptBucket(tx, name, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// test vectors from RFC 7914
+	assert.Equal(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)))
+	assert.Equal(t, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), 1, 32)))
+	assert.Equal(t, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), 2, 32)))
+}
+
+func TestValueCipher(t *testing.T) {
+	key, err := randomBytes(32)
+	assert.NoError(t, err)
+	c, err := newValueCipher(key)
+	assert.NoError(t, err)
+
+	sealed, err := sealValue(c, []byte(`{"prompt":"secret"}`))
+	assert.NoError(t, err)
+	assert.True(t, isSealedValue(sealed))
+	assert.NotContains(t, string(sealed), "secret")
+
+	plain, err := openValue(c, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"prompt":"secret"}`, string(plain))
+
+	// plain values are read as they are
+	plain, err = openValue(c, []byte(`{"prompt":"plain"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"prompt":"plain"}`, string(plain))
+
+	_, err = openValue(nil, sealed)
+	assert.ErrorIs(t, err, ErrEncryptionKeyRequired)
+
+	other, err := newValueCipher(make([]byte, 32))
+	assert.NoError(t, err)
+	_, err = openValue(other, sealed)
+	assert.Error(t, err)
+
+	assert.Len(t, c.IndexKey("nginx"), 16)
+	assert.Equal(t, c.IndexKey("nginx"), c.IndexKey("nginx"))
+	assert.NotEqual(t, c.IndexKey("nginx"), other.IndexKey("nginx"))
+}
+
+func TestEncryptionKeyResolver_Resolve(t *testing.T) {
+	t.Run("key file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gptx.key")
+		assert.NoError(t, os.WriteFile(path, []byte("file-secret\n"), 0600))
+		secret, err := NewEncryptionKeyResolver(&EncryptionConfig{KeyFile: path}).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "file-secret", secret)
+	})
+
+	t.Run("key command", func(t *testing.T) {
+		secret, err := NewEncryptionKeyResolver(&EncryptionConfig{KeyCommand: "echo command-secret"}).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "command-secret", secret)
+	})
+
+	t.Run("passphrase env", func(t *testing.T) {
+		t.Setenv("TEST_GPTX_PASSPHRASE", "env-secret")
+		secret, err := NewEncryptionKeyResolver(&EncryptionConfig{PassphraseEnv: "TEST_GPTX_PASSPHRASE"}).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "env-secret", secret)
+
+		_, err = NewEncryptionKeyResolver(&EncryptionConfig{PassphraseEnv: "TEST_GPTX_PASSPHRASE_EMPTY"}).Resolve()
+		assert.EqualError(t, err, "environment variable 'TEST_GPTX_PASSPHRASE_EMPTY' specified by encryption.passphrase_env is empty")
+	})
+
+	t.Run("GPTX_PASSPHRASE", func(t *testing.T) {
+		t.Setenv("GPTX_PASSPHRASE", "")
+		_, err := NewEncryptionKeyResolver(&EncryptionConfig{}).Resolve()
+		assert.Error(t, err)
+
+		t.Setenv("GPTX_PASSPHRASE", "default-secret")
+		secret, err := NewEncryptionKeyResolver(&EncryptionConfig{}).Resolve()
+		assert.NoError(t, err)
+		assert.Equal(t, "default-secret", secret)
+	})
+}
+
+func TestStore_Encryption(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+	assert.NoError(t, s.Close())
+
+	openStore := func(t *testing.T, secret string, encrypt bool) (*StoreManager, *Store, error) {
+		t.Helper()
+		m := &StoreManager{DBPath: sm.DBPath, Encrypt: encrypt}
+		if secret != "" {
+			t.Setenv("TEST_GPTX_PASSPHRASE", secret)
+			m.KeyResolver = NewEncryptionKeyResolver(&EncryptionConfig{PassphraseEnv: "TEST_GPTX_PASSPHRASE"})
+		}
+		s, err := m.Open()
+		assert.NoError(t, err)
+		if err := s.Init(); err != nil {
+			_ = m.Close()
+			return nil, nil, err
+		}
+		return m, s, nil
+	}
+
+	t.Run("encrypt the plain database", func(t *testing.T) {
+		m, s, err := openStore(t, "secret", true)
+		assert.NoError(t, err)
+		defer m.Close()
+
+		encrypted, err := s.IsEncrypted()
+		assert.NoError(t, err)
+		assert.True(t, encrypted)
+
+		co, err := s.GetConversationById(1)
+		assert.NoError(t, err)
+		assert.Equal(t, "How do I configure nginx as a reverse proxy?", co.Prompt)
+
+		results, err := s.SearchConversations(&SearchQuery{Query: "nginx"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		// prefix terms match only the exact terms in the encrypted database
+		results, err = s.SearchConversations(&SearchQuery{Query: "ngin*"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 0)
+
+		assert.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+			_ = tx.Bucket([]byte(BucketConversations)).ForEach(func(k, v []byte) error {
+				assert.True(t, isSealedValue(v))
+				return nil
+			})
+			_ = tx.Bucket([]byte(BucketSearchDocs)).ForEach(func(k, v []byte) error {
+				assert.True(t, isSealedValue(v))
+				return nil
+			})
+			assert.Nil(t, tx.Bucket([]byte(BucketSearchIndex)).Bucket([]byte("nginx")))
+			return nil
+		}))
+
+		problems, _, err := s.Verify(false)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("open without the key", func(t *testing.T) {
+		_, _, err := openStore(t, "", false)
+		assert.ErrorIs(t, err, ErrEncryptionKeyRequired)
+		_, _, err = openStore(t, "wrong", false)
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+	})
+
+	t.Run("rekey", func(t *testing.T) {
+		m, s, err := openStore(t, "secret", true)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Rekey("new-secret", false))
+		assert.NoError(t, m.Close())
+
+		_, _, err = openStore(t, "secret", true)
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+
+		m, s, err = openStore(t, "new-secret", true)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Rekey("rotated-secret", true))
+		// the conversations are re-encrypted with the new data key in the same process
+		co, err := s.GetConversationById(3)
+		assert.NoError(t, err)
+		assert.Equal(t, "My nginx config returns 502", co.Prompt)
+		assert.NoError(t, m.Close())
+
+		m, s, err = openStore(t, "rotated-secret", false)
+		assert.NoError(t, err)
+		defer m.Close()
+		results, err := s.SearchConversations(&SearchQuery{Query: "tokyo"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+	})
+}
+
+func TestCache_Encryption(t *testing.T) {
+	cm := testCacheManager(t)
+	c, err := cm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, c.Set([]byte("key1"), []byte("plain value")))
+	assert.NoError(t, cm.Close())
+
+	t.Setenv("GPTX_PASSPHRASE", "secret")
+	cm.KeyResolver = NewEncryptionKeyResolver(&EncryptionConfig{})
+	cm.Encrypt = true
+	c, err = cm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, c.Init())
+	defer cm.Close()
+
+	assert.NoError(t, c.Set([]byte("key2"), []byte("secret value")))
+	for key, want := range map[string]string{"key1": "plain value", "key2": "secret value"} {
+		v, err := c.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(v))
+	}
+	assert.NoError(t, c.db.View(func(tx *bolt.Tx) error {
+		assert.True(t, isSealedValue(tx.Bucket([]byte(CacheBucketCaches)).Get([]byte("key1"))))
+		assert.True(t, isSealedValue(tx.Bucket([]byte(CacheBucketCaches)).Get([]byte("key2"))))
+		return nil
+	}))
+}
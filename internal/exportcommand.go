@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ExportCommand = &cli.Command{
+	Name:      "export",
+	Usage:     "Export conversations to Markdown, HTML, JSON or OpenAI fine-tuning JSONL",
+	ArgsUsage: `[conversation...]`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Specify an export `format` (" + strings.Join(exportFormatNames(), ", ") + ")",
+			Value:   "markdown",
+		},
+		&cli.StringFlag{
+			Name:    "label",
+			Aliases: []string{"l"},
+			Usage:   "Export the conversations with the `label`",
+		},
+		&cli.BoolFlag{
+			Name:               "all",
+			Aliases:            []string{"a"},
+			Usage:              "Export all the conversations",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:    "output-dir",
+			Aliases: []string{"o"},
+			Usage:   "Write each conversation to a file in the `directory` instead of the standard output",
+		},
+	},
+	Action: exportAction,
+}
+
+func exportFormatNames() []string {
+	names := make([]string, 0, len(ExportFormats))
+	for name := range ExportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var exportAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	format, ok := ExportFormats[c.String("format")]
+	if !ok {
+		return fmt.Errorf("invalid format: %s", c.String("format"))
+	}
+
+	label := c.String("label")
+	all := c.Bool("all")
+	if c.NArg() == 0 && label == "" && !all {
+		return errors.New("missing conversation argument(s). specify conversations, --label or --all")
+	}
+	if c.NArg() > 0 && (label != "" || all) {
+		return errors.New("conversation arguments can not be used with --label or --all")
+	}
+
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var list []*Conversation
+	if c.NArg() > 0 {
+		for _, key := range c.Args().Slice() {
+			co, err := store.GetConversationByKey(NewConversationKey(key))
+			if err != nil {
+				return err
+			}
+			list = append(list, co)
+		}
+	} else {
+		l, err := store.ListConversations(&ListConversationsQuery{Label: label})
+		if err != nil {
+			return err
+		}
+		list = l.Conversations
+	}
+
+	outputDir := c.String("output-dir")
+	if outputDir == "" {
+		return format.Write(c.App.Writer, list)
+	}
+
+	if err := os.MkdirAll(outputDir, os.FileMode(0700)); err != nil {
+		return err
+	}
+	for _, co := range list {
+		path := filepath.Join(outputDir, exportFileName(co, format))
+		if err := writeExportFile(path, format, co); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.App.Writer, path)
+	}
+	return nil
+})
+
+func writeExportFile(path string, format *ExportFormat, co *Conversation) (rErr error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && rErr == nil {
+			rErr = err
+		}
+	}()
+	return format.Write(f, []*Conversation{co})
+}
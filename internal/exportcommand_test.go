@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportCommand(t *testing.T) {
+	t.Run("export missing argument", func(t *testing.T) {
+		app := testNewApp(t)
+		err := app.Run([]string{"gptx", "export"})
+		assert.Error(t, err)
+	})
+
+	t.Run("export invalid format", func(t *testing.T) {
+		app := testNewApp(t)
+		err := app.Run([]string{"gptx", "export", "--format", "pdf", "1"})
+		assert.Error(t, err)
+		assert.Equal(t, "invalid format: pdf", err.Error())
+	})
+
+	t.Run("export markdown to stdout", func(t *testing.T) {
+		app := testNewApp(t)
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		testCreateSearchConversations(t, s)
+
+		err = app.Run([]string{"gptx", "export", "2"})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.True(t, strings.HasPrefix(out, "# Conversation 2\n"))
+		assert.Contains(t, out, "Tokyo is the capital city of Japan.")
+	})
+
+	t.Run("export label to directory", func(t *testing.T) {
+		app := testNewApp(t)
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		testCreateSearchConversations(t, s)
+
+		dir := filepath.Join(t.TempDir(), "exports")
+		err = app.Run([]string{"gptx", "export", "--label", "web", "--format", "html", "--output-dir", dir})
+		assert.NoError(t, err)
+
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "1.html", entries[0].Name())
+		assert.Equal(t, "3.html", entries[1].Name())
+	})
+
+	t.Run("export all as jsonl", func(t *testing.T) {
+		app := testNewApp(t)
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		testCreateSearchConversations(t, s)
+
+		err = app.Run([]string{"gptx", "export", "--all", "--format", "jsonl"})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.Equal(t, 3, strings.Count(out, "\n"))
+	})
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// ExportFormat is a format to export conversations.
+type ExportFormat struct {
+	Name      string
+	Extension string
+	Write     func(w io.Writer, list []*Conversation) error
+}
+
+var ExportFormats = map[string]*ExportFormat{
+	"markdown": {
+		Name:      "markdown",
+		Extension: "md",
+		Write:     writeMarkdownExport,
+	},
+	"html": {
+		Name:      "html",
+		Extension: "html",
+		Write:     writeHTMLExport,
+	},
+	"json": {
+		Name:      "json",
+		Extension: "json",
+		Write:     writeJSONExport,
+	},
+	"jsonl": {
+		Name:      "jsonl",
+		Extension: "jsonl",
+		Write:     writeFineTuningExport,
+	},
+}
+
+// exportTitle returns a human-readable title of the conversation.
+func exportTitle(co *Conversation) string {
+	if co.Title != "" {
+		return co.Title
+	}
+	if co.Name != "" {
+		return co.Name
+	}
+	return fmt.Sprintf("Conversation %d", co.Id)
+}
+
+// exportFileName returns a file name to export the conversation.
+// The name of the conversation is slugified, so that it can not be a path outside the output directory.
+func exportFileName(co *Conversation, f *ExportFormat) string {
+	if name := slugifyConversationName(co.Name); name != "" {
+		return fmt.Sprintf("%d-%s.%s", co.Id, name, f.Extension)
+	}
+	return fmt.Sprintf("%d.%s", co.Id, f.Extension)
+}
+
+func roleTitle(role string) string {
+	if role == "" {
+		return ""
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// writeMarkdownExport writes the conversations as readable Markdown transcripts.
+func writeMarkdownExport(w io.Writer, list []*Conversation) error {
+	for i, co := range list {
+		if i > 0 {
+			if _, err := fmt.Fprint(w, "\n---\n\n"); err != nil {
+				return err
+			}
+		}
+
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "# %s\n\n", exportTitle(co))
+		fmt.Fprintf(b, "- ID: %d\n", co.Id)
+		if co.Name != "" {
+			fmt.Fprintf(b, "- Name: %s\n", co.Name)
+		}
+		if co.Label != "" {
+			fmt.Fprintf(b, "- Label: %s\n", co.Label)
+		}
+		if co.Model != "" {
+			fmt.Fprintf(b, "- Model: %s\n", co.Model)
+		}
+		if len(co.Hooks) > 0 {
+			fmt.Fprintf(b, "- Hooks: %s\n", strings.Join(co.Hooks, ", "))
+		}
+		fmt.Fprintf(b, "- Created: %s\n", co.CreatedAt.Format(time.RFC3339))
+		for _, m := range co.Messages {
+			fmt.Fprintf(b, "\n## %s\n\n%s\n", roleTitle(m.Role), strings.TrimRight(m.Content, "\n"))
+		}
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var htmlExportTemplate = template.Must(template.New("html").Funcs(template.FuncMap{
+	"title":     exportTitle,
+	"roleTitle": roleTitle,
+	"rfc3339":   func(t time.Time) string { return t.Format(time.RFC3339) },
+	"join":      strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ if eq (len .) 1 }}{{ title (index . 0) }}{{ else }}Conversations{{ end }}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 860px; margin: 2em auto; padding: 0 1em; color: #24292f; }
+.meta { color: #57606a; font-size: 0.9em; }
+.message { border: 1px solid #d0d7de; border-radius: 6px; margin: 1em 0; padding: 0.5em 1em; }
+.message.user { background: #f6f8fa; }
+.message.system { background: #fff8c5; }
+.role { font-weight: bold; }
+.content { white-space: pre-wrap; font-family: inherit; margin: 0.5em 0; }
+</style>
+</head>
+<body>
+{{- range . }}
+<article id="conversation-{{ .Id }}">
+<h1>{{ title . }}</h1>
+<ul class="meta">
+<li>ID: {{ .Id }}</li>
+{{- if .Label }}
+<li>Label: {{ .Label }}</li>
+{{- end }}
+{{- if .Model }}
+<li>Model: {{ .Model }}</li>
+{{- end }}
+{{- if .Hooks }}
+<li>Hooks: {{ join .Hooks ", " }}</li>
+{{- end }}
+<li>Created: {{ rfc3339 .CreatedAt }}</li>
+</ul>
+{{- range .Messages }}
+<div class="message {{ .Role }}">
+<div class="role">{{ roleTitle .Role }}</div>
+<pre class="content">{{ .Content }}</pre>
+</div>
+{{- end }}
+</article>
+{{- end }}
+</body>
+</html>
+`))
+
+// writeHTMLExport writes the conversations as a standalone HTML document.
+func writeHTMLExport(w io.Writer, list []*Conversation) error {
+	return htmlExportTemplate.Execute(w, list)
+}
+
+// writeJSONExport writes the conversations as canonical JSON.
+// Like the inspect command, a single conversation is written as an object and multiple conversations as an array.
+func writeJSONExport(w io.Writer, list []*Conversation) error {
+	var v interface{} = list
+	if len(list) == 1 {
+		v = list[0]
+	}
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(buf))
+	return err
+}
+
+type fineTuningExample struct {
+	Messages []fineTuningMessage `json:"messages"`
+}
+
+type fineTuningMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// writeFineTuningExport writes the conversations in the OpenAI fine-tuning chat format (JSONL).
+// Each line is an example that contains the messages of a conversation.
+// Conversations without assistant messages are skipped because they are not useful for training.
+func writeFineTuningExport(w io.Writer, list []*Conversation) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, co := range list {
+		example := fineTuningExample{Messages: []fineTuningMessage{}}
+		hasAssistant := false
+		for _, m := range co.Messages {
+			if m.Role == openai.ChatMessageRoleAssistant {
+				hasAssistant = true
+			}
+			example.Messages = append(example.Messages, fineTuningMessage{Role: m.Role, Content: m.Content})
+		}
+		if !hasAssistant {
+			continue
+		}
+		if err := enc.Encode(example); err != nil {
+			return err
+		}
+	}
+	return nil
+}
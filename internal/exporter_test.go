@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func testExportConversation() *Conversation {
+	co := NewConversation()
+	co.Id = 1
+	co.Name = "nginx"
+	co.Label = "web"
+	co.Prompt = "How do I configure nginx?"
+	co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "You are a helpful assistant."})
+	co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "How do I configure nginx?"})
+	co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Use <proxy_pass>."})
+	return co
+}
+
+func TestWriteMarkdownExport(t *testing.T) {
+	b := &bytes.Buffer{}
+	err := writeMarkdownExport(b, []*Conversation{testExportConversation(), testExportConversation()})
+	assert.NoError(t, err)
+	out := b.String()
+	assert.True(t, strings.HasPrefix(out, "# nginx\n\n- ID: 1\n- Name: nginx\n- Label: web\n"))
+	assert.Contains(t, out, "\n## User\n\nHow do I configure nginx?\n")
+	assert.Contains(t, out, "\n## Assistant\n\nUse <proxy_pass>.\n")
+	assert.Equal(t, 1, strings.Count(out, "\n---\n"))
+}
+
+func TestWriteHTMLExport(t *testing.T) {
+	b := &bytes.Buffer{}
+	err := writeHTMLExport(b, []*Conversation{testExportConversation()})
+	assert.NoError(t, err)
+	out := b.String()
+	assert.Contains(t, out, "<title>nginx</title>")
+	assert.Contains(t, out, `<div class="message assistant">`)
+	// the content must be escaped
+	assert.Contains(t, out, "Use &lt;proxy_pass&gt;.")
+}
+
+func TestWriteJSONExport(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		err := writeJSONExport(b, []*Conversation{testExportConversation()})
+		assert.NoError(t, err)
+		co := NewConversation()
+		assert.NoError(t, json.Unmarshal(b.Bytes(), co))
+		assert.Equal(t, uint64(1), co.Id)
+		assert.Len(t, co.Messages, 3)
+	})
+
+	t.Run("multiple", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		err := writeJSONExport(b, []*Conversation{testExportConversation(), testExportConversation()})
+		assert.NoError(t, err)
+		var list []*Conversation
+		assert.NoError(t, json.Unmarshal(b.Bytes(), &list))
+		assert.Len(t, list, 2)
+	})
+}
+
+func TestWriteFineTuningExport(t *testing.T) {
+	noAnswer := NewConversation()
+	noAnswer.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hello"})
+
+	b := &bytes.Buffer{}
+	err := writeFineTuningExport(b, []*Conversation{testExportConversation(), noAnswer})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"messages":[{"role":"system","content":"You are a helpful assistant."},{"role":"user","content":"How do I configure nginx?"},{"role":"assistant","content":"Use <proxy_pass>."}]}`+"\n", b.String())
+}
+
+func TestExportFileName(t *testing.T) {
+	f := ExportFormats["markdown"]
+	co := testExportConversation()
+	assert.Equal(t, "1-nginx.md", exportFileName(co, f))
+
+	// the name can not be a path outside the output directory
+	co.Name = "../evil"
+	assert.Equal(t, "1-evil.md", exportFileName(co, f))
+	co.Name = "a/b"
+	assert.Equal(t, "1-a-b.md", exportFileName(co, f))
+	co.Name = "../.."
+	assert.Equal(t, "1.md", exportFileName(co, f))
+	co.Name = ""
+	assert.Equal(t, "1.md", exportFileName(co, f))
+}
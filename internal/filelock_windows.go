@@ -0,0 +1,17 @@
+//go:build windows
+
+package internal
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile takes the exclusive lock of the file. It blocks until the lock is released by the other processes.
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, ^uint32(0), ^uint32(0), &windows.Overlapped{})
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, ^uint32(0), ^uint32(0), &windows.Overlapped{})
+}
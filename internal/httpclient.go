@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// newHTTPClient creates an HTTP client for the OpenAI API from the config.
+// It applies the proxy, the additional CA bundle, the extra headers and the lazily resolved API key.
+func newHTTPClient(config *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no valid certificates found in the ca_file '%s'", config.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	var rt http.RoundTripper = transport
+	if len(config.Headers) > 0 {
+		rt = &headerTransport{
+			Headers: config.Headers,
+			Base:    rt,
+		}
+	}
+
+	rt = &apiKeyTransport{
+		Resolver: NewAPIKeyResolver(config),
+		Base:     rt,
+	}
+
+	return &http.Client{
+		Transport: rt,
+	}, nil
+}
+
+// headerTransport is an http.RoundTripper that adds extra headers to every request.
+type headerTransport struct {
+	Headers map[string]string
+	Base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the request, so we use a copy of it.
+	req = req.Clone(req.Context())
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	return t.Base.RoundTrip(req)
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Run("headers", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "secret", r.Header.Get("X-Gateway-Token"))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		c := NewConfig()
+		c.Headers = map[string]string{"X-Gateway-Token": "secret"}
+		client, err := newHTTPClient(c)
+		assert.NoError(t, err)
+
+		resp, err := client.Get(ts.URL)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		c := NewConfig()
+		c.Proxy = "http://proxy.example.com:8080"
+		client, err := newHTTPClient(c)
+		assert.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, "https://api.openai.com/v1", nil)
+		proxyURL, err := client.Transport.(*apiKeyTransport).Base.(*http.Transport).Proxy(req)
+		assert.NoError(t, err)
+		assert.Equal(t, &url.URL{Scheme: "http", Host: "proxy.example.com:8080"}, proxyURL)
+	})
+
+	t.Run("ca_file", func(t *testing.T) {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		// the default client does not trust the test server certificate
+		c := NewConfig()
+		client, err := newHTTPClient(c)
+		assert.NoError(t, err)
+		_, err = client.Get(ts.URL)
+		assert.Error(t, err)
+
+		caFile := testTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+		c.CAFile = caFile.Name()
+		client, err = newHTTPClient(c)
+		assert.NoError(t, err)
+		resp, err := client.Get(ts.URL)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("invalid ca_file", func(t *testing.T) {
+		c := NewConfig()
+		c.CAFile = testTempFile(t, []byte("invalid")).Name()
+		_, err := newHTTPClient(c)
+		assert.Error(t, err)
+	})
+}
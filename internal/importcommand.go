@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v2"
+	"os"
+	"time"
+)
+
+var ImportCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "Import conversations from gptx JSON exports or ChatGPT web export archives",
+	ArgsUsage: `[file...]`,
+	Description: `Import conversations from the files. Use "-" to read the standard input.
+The supported formats are the JSON exported by "gptx export --format json" and the ChatGPT web export
+(the "conversations.json" file or the zip archive that contains it).`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Specify an input `format` (auto, gptx or chatgpt)",
+			Value:   ImportFormatAuto,
+		},
+		&cli.StringFlag{
+			Name:    "label",
+			Aliases: []string{"l"},
+			Usage:   "Set the `label` to the imported conversations that do not have a label",
+			Value:   "imported",
+		},
+		&cli.BoolFlag{
+			Name:               "dry-run",
+			Aliases:            []string{"n"},
+			Usage:              "Display the summary of the conversations to import without storing them",
+			DisableDefaultText: true,
+		},
+	},
+	Action: importAction,
+}
+
+var importAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing file argument(s)")
+	}
+
+	format := c.String("format")
+	switch format {
+	case ImportFormatAuto, ImportFormatGptx, ImportFormatChatGPT:
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+
+	var items []*ImportItem
+	for _, file := range c.Args().Slice() {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = readImportData(os.Stdin)
+		} else {
+			data, err = readImportFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		parsed, err := parseImportData(data, format)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		items = append(items, parsed...)
+	}
+
+	label := c.String("label")
+	list := make([]*Conversation, 0, len(items))
+	for _, item := range items {
+		if item.Conversation.Label == "" {
+			item.Conversation.Label = label
+		}
+		list = append(list, item.Conversation)
+	}
+
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	dryRun := c.Bool("dry-run")
+	if err := store.ImportConversations(list, dryRun); err != nil {
+		return err
+	}
+
+	t := NewSimpleTableWriter(c.App.Writer)
+	t.AppendHeader(table.Row{
+		"SOURCE",
+		"ID",
+		"NAME",
+		"LABEL",
+		"MESSAGES",
+		"CREATED",
+	})
+	for _, item := range items {
+		co := item.Conversation
+		t.AppendRow([]interface{}{
+			truncateChars(item.Source, 50),
+			co.Id,
+			co.Name,
+			co.Label,
+			len(co.Messages),
+			co.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	t.Render()
+
+	for _, item := range items {
+		if item.Name != "" && item.Name != item.Conversation.Name {
+			_, _ = fmt.Fprintf(c.App.Writer, "The name %q is renamed to %q\n", item.Name, item.Conversation.Name)
+		}
+	}
+
+	if dryRun {
+		_, _ = fmt.Fprintf(c.App.Writer, "%d conversation(s) would be imported (dry run)\n", len(items))
+	} else {
+		_, _ = fmt.Fprintf(c.App.Writer, "%d conversation(s) imported\n", len(items))
+	}
+	return nil
+})
+
+func readImportFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readImportData(f)
+}
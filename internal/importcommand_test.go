@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportCommand(t *testing.T) {
+	t.Run("import missing argument", func(t *testing.T) {
+		app := testNewApp(t)
+		err := app.Run([]string{"gptx", "import"})
+		assert.Error(t, err)
+		assert.Equal(t, "missing file argument(s)", err.Error())
+	})
+
+	t.Run("import chatgpt export", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "conversations.json")
+		assert.NoError(t, os.WriteFile(file, []byte(testChatGPTExport), 0600))
+
+		app := testNewApp(t)
+		err := app.Run([]string{"gptx", "import", "--dry-run", file})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "1 conversation(s) would be imported (dry run)")
+
+		err = app.Run([]string{"gptx", "import", file})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "1 conversation(s) imported")
+
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		co, err := s.GetConversationByName("nginx-reverse-proxy")
+		assert.NoError(t, err)
+		assert.Equal(t, "imported", co.Label)
+		assert.Len(t, co.Messages, 2)
+	})
+
+	t.Run("import gptx export with unsafe names", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "export.json")
+		assert.NoError(t, os.WriteFile(file, []byte(`[{"id":1,"name":"../evil"},{"id":2,"name":"nginx"}]`), 0600))
+
+		app := testNewApp(t)
+		err := app.Run([]string{"gptx", "import", "--dry-run", file})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.Contains(t, out, `The name "../evil" is renamed to "evil"`)
+		assert.NotContains(t, out, `The name "nginx"`)
+		assert.Contains(t, out, "2 conversation(s) would be imported (dry run)")
+	})
+}
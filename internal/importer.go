@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ImportFormatAuto    = "auto"
+	ImportFormatGptx    = "gptx"
+	ImportFormatChatGPT = "chatgpt"
+)
+
+// chatGPTExportFile is the file name of the conversations in a ChatGPT web export archive.
+const chatGPTExportFile = "conversations.json"
+
+// ImportItem is a conversation to import.
+type ImportItem struct {
+	Source       string // The title or the name of the conversation in the source data
+	Name         string // The name of the conversation in the source data. The imported name can be different from it
+	Conversation *Conversation
+}
+
+// readImportData reads the data to import. If the data is a zip archive, it reads the conversations.json in it.
+func readImportData(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return data, nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != chatGPTExportFile && !strings.HasSuffix(f.Name, "/"+chatGPTExportFile) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s is not found in the archive", chatGPTExportFile)
+}
+
+// parseImportData parses the data in the format and returns the conversations to import.
+func parseImportData(data []byte, format string) ([]*ImportItem, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return []*ImportItem{}, nil
+	}
+
+	// both formats can be a single object or an array of objects
+	var raws []json.RawMessage
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, err
+		}
+	} else {
+		raws = []json.RawMessage{data}
+	}
+
+	items := make([]*ImportItem, 0, len(raws))
+	for i, raw := range raws {
+		f := format
+		if f == ImportFormatAuto {
+			f = detectImportFormat(raw)
+		}
+
+		var item *ImportItem
+		var err error
+		switch f {
+		case ImportFormatGptx:
+			item, err = parseGptxConversation(raw)
+		case ImportFormatChatGPT:
+			item, err = parseChatGPTConversation(raw)
+		default:
+			return nil, fmt.Errorf("invalid format: %s", f)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the conversation at index %d: %w", i, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func detectImportFormat(raw json.RawMessage) string {
+	var probe struct {
+		Mapping json.RawMessage `json:"mapping"`
+	}
+	if err := json.Unmarshal(raw, &probe); err == nil && probe.Mapping != nil {
+		return ImportFormatChatGPT
+	}
+	return ImportFormatGptx
+}
+
+func parseGptxConversation(raw json.RawMessage) (*ImportItem, error) {
+	co := NewConversation()
+	co.CreatedAt = time.Time{}
+	if err := json.Unmarshal(raw, co); err != nil {
+		return nil, err
+	}
+	source := co.Name
+	if source == "" {
+		source = strconv.FormatUint(co.Id, 10)
+	}
+	name := co.Name
+	if name != "" && !isSafeConversationName(name) {
+		co.Name = slugifyConversationName(name)
+	}
+	co.Id = 0
+	if co.Prompt == "" {
+		co.Prompt = firstUserMessage(co.Messages)
+	}
+	return &ImportItem{Source: source, Name: name, Conversation: co}, nil
+}
+
+// isSafeConversationName reports whether the name is valid and can be used as a part of a file name.
+// The names of the imported conversations are not trusted, because they are exported to files with their names.
+func isSafeConversationName(name string) bool {
+	if checkValidConversationName(name) != nil || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\") && strings.IndexFunc(name, unicode.IsControl) == -1
+}
+
+type chatGPTConversation struct {
+	Title       string                  `json:"title"`
+	CreateTime  float64                 `json:"create_time"`
+	UpdateTime  float64                 `json:"update_time"`
+	CurrentNode string                  `json:"current_node"`
+	Mapping     map[string]*chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Id      string          `json:"id"`
+	Message *chatGPTMessage `json:"message"`
+	Parent  string          `json:"parent"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	Content struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+}
+
+// parseChatGPTConversation parses a conversation in the ChatGPT web export format.
+// The conversation is a tree of messages because the messages can be edited and regenerated in the web UI.
+// Only the branch that ends with the current node is imported.
+func parseChatGPTConversation(raw json.RawMessage) (*ImportItem, error) {
+	src := &chatGPTConversation{}
+	if err := json.Unmarshal(raw, src); err != nil {
+		return nil, err
+	}
+
+	var branch []*chatGPTNode
+	visited := map[string]bool{}
+	for id := src.CurrentNode; id != "" && !visited[id]; {
+		visited[id] = true
+		node, ok := src.Mapping[id]
+		if !ok {
+			break
+		}
+		branch = append(branch, node)
+		id = node.Parent
+	}
+
+	co := NewConversation()
+	co.CreatedAt = unixFloatToTime(src.CreateTime)
+	co.UpdatedAt = unixFloatToTime(src.UpdateTime)
+	for i := len(branch) - 1; i >= 0; i-- {
+		m := branch[i].Message
+		if m == nil || m.Content.ContentType != "text" {
+			continue
+		}
+		switch m.Author.Role {
+		case openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant, openai.ChatMessageRoleSystem:
+		default:
+			// tool messages are not supported
+			continue
+		}
+
+		var texts []string
+		for _, part := range m.Content.Parts {
+			var text string
+			if err := json.Unmarshal(part, &text); err != nil {
+				// non-text parts such as images are skipped
+				continue
+			}
+			texts = append(texts, text)
+		}
+		content := strings.Join(texts, "\n")
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		co.AddMessage(openai.ChatCompletionMessage{Role: m.Author.Role, Content: content})
+		if m.Metadata.ModelSlug != "" {
+			co.Model = m.Metadata.ModelSlug
+		}
+	}
+	co.Prompt = firstUserMessage(co.Messages)
+	co.Name = slugifyConversationName(src.Title)
+
+	source := src.Title
+	if source == "" {
+		source = "(untitled)"
+	}
+	return &ImportItem{Source: source, Conversation: co}, nil
+}
+
+func firstUserMessage(messages []openai.ChatCompletionMessage) string {
+	for _, m := range messages {
+		if m.Role == openai.ChatMessageRoleUser {
+			return m.Content
+		}
+	}
+	return ""
+}
+
+func unixFloatToTime(v float64) time.Time {
+	if v <= 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+const maxSlugLength = 50
+
+// slugifyConversationName converts a title into a conversation name that is easy to type in the command line.
+func slugifyConversationName(title string) string {
+	b := &strings.Builder{}
+	hyphen := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteRune('-')
+			hyphen = true
+		}
+	}
+	runes := []rune(strings.TrimRight(b.String(), "-"))
+	if len(runes) > maxSlugLength {
+		runes = []rune(strings.TrimRight(string(runes[:maxSlugLength]), "-"))
+	}
+	name := string(runes)
+	if idRegex.MatchString(name) {
+		// using only numbers for a name is not allowed
+		name = "chat-" + name
+	}
+	return name
+}
+
+// ImportConversations stores the conversations with new ids in a transaction.
+// The timestamps of the conversations are preserved. If a name is already used,
+// a numeric suffix is added to the name. If dryRun is true, the changes are rolled back,
+// but the conversations are updated with the ids and names that would be assigned.
+func (s *Store) ImportConversations(list []*Conversation, dryRun bool) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bni := tx.Bucket([]byte(BucketNameIndex))
+		now := time.Now().UTC()
+		for _, co := range list {
+			co.Name = uniqueConversationName(bni, co.Name)
+			if co.CreatedAt.IsZero() {
+				co.CreatedAt = now
+			}
+			if co.UpdatedAt.IsZero() {
+				co.UpdatedAt = co.CreatedAt
+			}
+			if err := createConversation(tx, co); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+func uniqueConversationName(bni *bolt.Bucket, name string) string {
+	if name == "" || bni.Get([]byte(name)) == nil {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d", name, i)
+		if bni.Get([]byte(candidate)) == nil {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testChatGPTExport = `[
+  {
+    "title": "Nginx Reverse Proxy",
+    "create_time": 1700000000.5,
+    "update_time": 1700000100.0,
+    "current_node": "c",
+    "mapping": {
+      "root": {"id": "root", "message": null, "parent": null, "children": ["a"]},
+      "a": {"id": "a", "parent": "root", "children": ["b", "b2"], "message": {"author": {"role": "user"}, "content": {"content_type": "text", "parts": ["How do I configure nginx?"]}}},
+      "b2": {"id": "b2", "parent": "a", "children": [], "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["A discarded answer."]}}},
+      "b": {"id": "b", "parent": "a", "children": ["c"], "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Use proxy_pass."]}, "metadata": {"model_slug": "gpt-4"}}},
+      "c": {"id": "c", "parent": "b", "children": [], "message": {"author": {"role": "tool"}, "content": {"content_type": "text", "parts": ["tool output"]}}}
+    }
+  }
+]`
+
+func TestParseImportData(t *testing.T) {
+	t.Run("chatgpt", func(t *testing.T) {
+		items, err := parseImportData([]byte(testChatGPTExport), ImportFormatAuto)
+		assert.NoError(t, err)
+		assert.Len(t, items, 1)
+		co := items[0].Conversation
+		assert.Equal(t, "Nginx Reverse Proxy", items[0].Source)
+		assert.Equal(t, "nginx-reverse-proxy", co.Name)
+		assert.Equal(t, "How do I configure nginx?", co.Prompt)
+		assert.Equal(t, "gpt-4", co.Model)
+		assert.Equal(t, []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "How do I configure nginx?"},
+			{Role: openai.ChatMessageRoleAssistant, Content: "Use proxy_pass."},
+		}, co.Messages)
+		assert.Equal(t, time.Unix(1700000000, 5e8).UTC(), co.CreatedAt)
+		assert.Equal(t, time.Unix(1700000100, 0).UTC(), co.UpdatedAt)
+	})
+
+	t.Run("gptx", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		assert.NoError(t, writeJSONExport(b, []*Conversation{testExportConversation()}))
+		items, err := parseImportData(b.Bytes(), ImportFormatAuto)
+		assert.NoError(t, err)
+		assert.Len(t, items, 1)
+		co := items[0].Conversation
+		assert.Equal(t, uint64(0), co.Id)
+		assert.Equal(t, "nginx", co.Name)
+		assert.Equal(t, "web", co.Label)
+		assert.Len(t, co.Messages, 3)
+	})
+
+	t.Run("gptx with unsafe names", func(t *testing.T) {
+		items, err := parseImportData([]byte(`[{"id":1,"name":"../evil"},{"id":2,"name":"a\\b"},{"id":3,"name":"123"},{"id":4,"name":"Deploy_Notes"}]`), ImportFormatGptx)
+		assert.NoError(t, err)
+		assert.Len(t, items, 4)
+		assert.Equal(t, "../evil", items[0].Name)
+		assert.Equal(t, "evil", items[0].Conversation.Name)
+		assert.Equal(t, "a-b", items[1].Conversation.Name)
+		assert.Equal(t, "chat-123", items[2].Conversation.Name)
+		// the safe names are kept as they are
+		assert.Equal(t, "Deploy_Notes", items[3].Conversation.Name)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := parseImportData([]byte(`{}`), "foo")
+		assert.Error(t, err)
+	})
+}
+
+func TestReadImportData(t *testing.T) {
+	b := &bytes.Buffer{}
+	zw := zip.NewWriter(b)
+	w, err := zw.Create("export/conversations.json")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(testChatGPTExport))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	data, err := readImportData(b)
+	assert.NoError(t, err)
+	assert.Equal(t, testChatGPTExport, string(data))
+}
+
+func TestSlugifyConversationName(t *testing.T) {
+	assert.Equal(t, "nginx-reverse-proxy", slugifyConversationName("Nginx: Reverse Proxy!"))
+	assert.Equal(t, "日本の首都", slugifyConversationName("日本の首都"))
+	assert.Equal(t, "chat-2024", slugifyConversationName("2024"))
+	assert.Equal(t, "", slugifyConversationName("???"))
+}
+
+func TestStore_ImportConversations(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+
+	existing := NewConversation()
+	existing.Name = "nginx"
+	assert.NoError(t, s.CreateConversation(existing))
+
+	createdAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	newImports := func() []*Conversation {
+		a := NewConversation()
+		a.Name = "nginx"
+		a.CreatedAt = createdAt
+		b := NewConversation()
+		b.Name = "nginx"
+		return []*Conversation{a, b}
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		list := newImports()
+		assert.NoError(t, s.ImportConversations(list, true))
+		assert.Equal(t, uint64(2), list[0].Id)
+		assert.Equal(t, "nginx-2", list[0].Name)
+		assert.Equal(t, "nginx-3", list[1].Name)
+
+		_, err := s.GetConversationById(2)
+		assert.Error(t, err)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		list := newImports()
+		assert.NoError(t, s.ImportConversations(list, false))
+
+		co, err := s.GetConversationByName("nginx-2")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), co.Id)
+		assert.Equal(t, createdAt, co.CreatedAt)
+		assert.Equal(t, createdAt, co.UpdatedAt)
+
+		co, err = s.GetConversationByName("nginx-3")
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), co.Id)
+	})
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"path/filepath"
+)
+
+var IndexCommand = &cli.Command{
+	Name:      "index",
+	Usage:     "Build a local index of the documents in a directory for 'gptx chat --rag'",
+	ArgsUsage: "<dir>",
+	Description: `Split the text files in the directory into chunks, compute their embeddings and store them in a local index
+under the gptx home directory. The hidden files and directories, and the binary files are skipped.
+Running it again for the same name rebuilds the index, and reuses the embeddings of the unchanged chunks.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "name",
+			Aliases: []string{"n"},
+			Usage:   "Specify a `name` for the index. The default is the name of the directory",
+		},
+		&cli.StringSliceFlag{
+			Name:  "include",
+			Usage: "Index only the files whose names match the `pattern` (e.g. \"*.md\"). It can be specified multiple times",
+		},
+		&cli.IntFlag{
+			Name:  "chunk-size",
+			Usage: "The maximum number of characters of a chunk. The default is chunk_size in the [rag] section of the config",
+		},
+	},
+	Action: indexAction,
+}
+
+var indexAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() != 1 {
+		return errors.New("a directory is required")
+	}
+	dir, err := filepath.Abs(c.Args().First())
+	if err != nil {
+		return err
+	}
+	name := c.String("name")
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+	chunkSize := c.Int("chunk-size")
+	if chunkSize == 0 && r.Config.RAG != nil {
+		chunkSize = r.Config.RAG.ChunkSize
+	}
+	if chunkSize < 0 {
+		return errors.New("invalid value for flag chunk-size: it must be positive")
+	}
+
+	sv, err := r.NewChatService(c.App.Writer, c.App.ErrWriter)
+	if err != nil {
+		return err
+	}
+	idx, err := r.IndexManager.Open(name, true)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	indexer := &Indexer{
+		// the chunks are sent to the embedding API, so they are redacted like the chat messages
+		Embed: func(ctx context.Context, text string) ([]float32, error) {
+			return sv.embed(ctx, sv.redactText(text))
+		},
+		Model:     sv.Embedder.Name(),
+		ChunkSize: chunkSize,
+		Include:   c.StringSlice("include"),
+	}
+	result, err := indexer.Index(context.Background(), idx, dir)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "Indexed %d file(s) into %d chunk(s) as %s (%d embedding(s) computed)\n", result.Files, result.Chunks, name, result.Embedded)
+	return nil
+})
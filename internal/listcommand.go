@@ -1,10 +1,16 @@
 package internal
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/urfave/cli/v2"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -21,7 +27,7 @@ var ListCommand = &cli.Command{
 		&cli.BoolFlag{
 			Name:               "reverse",
 			Aliases:            []string{"r"},
-			Usage:              "Sort the conversations in descending id order",
+			Usage:              "Sort the conversations in descending order",
 			DisableDefaultText: true,
 		},
 		&cli.IntFlag{
@@ -35,6 +41,85 @@ var ListCommand = &cli.Command{
 			Aliases: []string{"l"},
 			Usage:   "Filter the conversations by `label`",
 		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "Filter the conversations created since the `time` (e.g. 7d, 12h, 2006-01-02 or RFC3339)",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "Filter the conversations created until the `time`. A date includes the whole day",
+		},
+		&cli.StringFlag{
+			Name:  "updated-since",
+			Usage: "Filter the conversations updated since the `time`",
+		},
+		&cli.StringFlag{
+			Name:  "updated-until",
+			Usage: "Filter the conversations updated until the `time`. A date includes the whole day",
+		},
+		&cli.StringFlag{
+			Name:    "name",
+			Aliases: []string{"n"},
+			Usage:   "Filter the conversations by a glob `pattern` of the name (e.g. 'deploy-*')",
+		},
+		&cli.StringFlag{
+			Name:  "hook",
+			Usage: "Filter the conversations by `hook`",
+		},
+		&cli.StringFlag{
+			Name:  "model",
+			Usage: "Filter the conversations by `model`",
+		},
+		&cli.IntFlag{
+			Name:        "min-messages",
+			Usage:       "Filter the conversations that have at least the `number` of messages",
+			DefaultText: "0 (no limit)",
+		},
+		&cli.StringFlag{
+			Name:  "prompt",
+			Usage: "Filter the conversations whose prompt contains the `text` (case-insensitive)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "Filter the conversations by `tag`. It can be specified multiple times",
+		},
+		&cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "Filter the conversations by metadata (`key=value`). It can be specified multiple times",
+		},
+		&cli.BoolFlag{
+			Name:               "all",
+			Aliases:            []string{"a"},
+			Usage:              "Include the archived conversations",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:               "archived",
+			Usage:              "Only list the archived conversations",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:               "starred",
+			Usage:              "Only list the starred conversations",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:    "sort",
+			Aliases: []string{"s"},
+			Usage:   "Sort the conversations by `key` (id, created, updated or messages)",
+			Value:   SortById,
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Specify an output `format` (table, json, csv or template)",
+			Value:   "table",
+		},
+		&cli.StringFlag{
+			Name:    "template",
+			Aliases: []string{"t"},
+			Usage:   "Specify a Go `template` applied to each conversation for the template format (e.g. '{{.Id}} {{.Name}}')",
+		},
 		&cli.BoolFlag{
 			Name:               "quiet",
 			Aliases:            []string{"q"},
@@ -47,9 +132,29 @@ var ListCommand = &cli.Command{
 
 var listAction = repositoryAwareAction(func(c *cli.Context, r *Repository) (err error) {
 	query := &ListConversationsQuery{
-		Reverse: c.Bool("reverse"),
-		Limit:   c.Int("limit"),
-		Label:   c.String("label"),
+		Reverse:     c.Bool("reverse"),
+		Limit:       c.Int("limit"),
+		Label:       c.String("label"),
+		Sort:        c.String("sort"),
+		Name:        c.String("name"),
+		Hook:        c.String("hook"),
+		Model:       c.String("model"),
+		MinMessages: c.Int("min-messages"),
+		Prompt:      c.String("prompt"),
+		Starred:     c.Bool("starred"),
+		Tags:        c.StringSlice("tag"),
+		PinnedFirst: true,
+	}
+	if query.Metadata, err = parseMetadataFlags(c.StringSlice("meta")); err != nil {
+		return err
+	}
+
+	if c.Bool("archived") {
+		archived := true
+		query.Archived = &archived
+	} else if !c.Bool("all") {
+		archived := false
+		query.Archived = &archived
 	}
 
 	quiet := c.Bool("quiet")
@@ -62,6 +167,35 @@ var listAction = repositoryAwareAction(func(c *cli.Context, r *Repository) (err
 		query.Begin = &begin
 	}
 
+	if query.CreatedSince, err = getTimeValueFromStringFlag(c, "since"); err != nil {
+		return err
+	}
+	if query.CreatedUntil, err = getUntilTimeValueFromStringFlag(c, "until"); err != nil {
+		return err
+	}
+	if query.UpdatedSince, err = getTimeValueFromStringFlag(c, "updated-since"); err != nil {
+		return err
+	}
+	if query.UpdatedUntil, err = getUntilTimeValueFromStringFlag(c, "updated-until"); err != nil {
+		return err
+	}
+
+	format := c.String("format")
+	var tmpl *template.Template
+	switch format {
+	case "table", "json", "csv":
+	case "template":
+		if c.String("template") == "" {
+			return errors.New("template format requires the --template flag")
+		}
+		tmpl, err = template.New("list").Parse(c.String("template"))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+
 	store, err := r.StoreManager.Open()
 	if err != nil {
 		return err
@@ -73,35 +207,80 @@ var listAction = repositoryAwareAction(func(c *cli.Context, r *Repository) (err
 		return err
 	}
 
-	t := NewSimpleTableWriter(c.App.Writer)
-	if !quiet {
+	if quiet {
+		t := NewSimpleTableWriter(c.App.Writer)
+		for _, c := range list.Conversations {
+			t.AppendRow([]interface{}{c.Id})
+		}
+		t.Render()
+		return nil
+	}
+
+	switch format {
+	case "json":
+		buf, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.App.Writer, string(buf))
+	case "csv":
+		w := csv.NewWriter(c.App.Writer)
+		if err := w.Write([]string{"id", "prompt", "messages", "name", "label", "hooks", "model", "created", "updated", "state", "title"}); err != nil {
+			return err
+		}
+		for _, c := range list.Conversations {
+			if err := w.Write([]string{
+				strconv.FormatUint(c.Id, 10),
+				c.Prompt,
+				strconv.Itoa(len(c.Messages)),
+				c.Name,
+				c.Label,
+				strings.Join(c.Hooks, ","),
+				c.Model,
+				c.CreatedAt.Format(time.RFC3339),
+				c.LastUpdatedAt().Format(time.RFC3339),
+				strings.Join(c.States(), ","),
+				c.Title,
+			}); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	case "template":
+		for _, co := range list.Conversations {
+			if err := tmpl.Execute(c.App.Writer, co); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(c.App.Writer)
+		}
+	default:
+		t := NewSimpleTableWriter(c.App.Writer)
 		t.AppendHeader(table.Row{
 			"ID",
-			"PROMPT",
+			"TITLE",
 			"MESSAGES",
 			"NAME",
 			"LABEL",
 			"HOOKS",
 			"CREATED",
 			"ELAPSED",
+			"STATE",
 		})
-	}
-	for _, c := range list.Conversations {
-		if !quiet {
+		for _, c := range list.Conversations {
 			t.AppendRow([]interface{}{
 				c.Id,
-				truncateChars(strings.ReplaceAll(c.Prompt, "\n", " "), 50),
+				truncateChars(c.DisplayTitle(), 50),
 				len(c.Messages),
 				c.Name,
 				c.Label,
 				strings.Join(c.Hooks, ", "),
 				c.CreatedAt.Format(time.RFC3339),
 				humanize.Time(c.CreatedAt),
+				strings.Join(c.States(), ", "),
 			})
-		} else {
-			t.AppendRow([]interface{}{c.Id})
 		}
+		t.Render()
 	}
-	t.Render()
 	return nil
 })
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -28,6 +29,54 @@ func TestListCommand(t *testing.T) {
 		out := app.Writer.(*bytes.Buffer).String()
 		// t.Log(out)
 		// just check the header line
-		assert.Regexp(t, `^ID\s+PROMPT\s+MESSAGES\s+NAME\s+LABEL\s+HOOKS\s+CREATED\s+ELAPSED`, out)
+		assert.Regexp(t, `^ID\s+TITLE\s+MESSAGES\s+NAME\s+LABEL\s+HOOKS\s+CREATED\s+ELAPSED`, out)
+	})
+
+	t.Run("list with filters and formats", func(t *testing.T) {
+		app := testNewApp(t)
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+
+		for i := 1; i <= 5; i++ {
+			co := NewConversation()
+			co.Name = fmt.Sprintf("test-conversation-%d", i)
+			co.Prompt = fmt.Sprintf("prompt %d", i)
+			if i%2 == 0 {
+				co.Model = "gpt-4"
+			}
+			err := s.CreateConversation(co)
+			assert.NoError(t, err)
+		}
+
+		err = app.Run([]string{"gptx", "list", "--model", "gpt-4", "--since", "1h", "--format", "json"})
+		assert.NoError(t, err)
+		list := &ConversationList{}
+		err = json.Unmarshal(app.Writer.(*bytes.Buffer).Bytes(), list)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, list.Count)
+		assert.Equal(t, uint64(2), list.Conversations[0].Id)
+		assert.Equal(t, uint64(4), list.Conversations[1].Id)
+
+		app.Writer = &bytes.Buffer{}
+		err = app.Run([]string{"gptx", "list", "--name", "*-3", "--format", "csv"})
+		assert.NoError(t, err)
+		out := app.Writer.(*bytes.Buffer).String()
+		assert.Regexp(t, `^id,prompt,messages,name,label,hooks,model,created,updated,state,title\n3,prompt 3,0,test-conversation-3,,,,`, out)
+
+		app.Writer = &bytes.Buffer{}
+		err = app.Run([]string{"gptx", "list", "--sort", "created", "--reverse", "--format", "template", "--template", "{{.Id}}:{{.Name}}", "--limit", "2"})
+		assert.NoError(t, err)
+		assert.Equal(t, "5:test-conversation-5\n4:test-conversation-4\n", app.Writer.(*bytes.Buffer).String())
+	})
+
+	t.Run("list with invalid flags", func(t *testing.T) {
+		app := testNewApp(t)
+		assert.Error(t, app.Run([]string{"gptx", "list", "--since", "yesterday"}))
+		assert.Error(t, app.Run([]string{"gptx", "list", "--format", "xml"}))
+		assert.Error(t, app.Run([]string{"gptx", "list", "--format", "template"}))
+		assert.Error(t, app.Run([]string{"gptx", "list", "--sort", "name"}))
 	})
 }
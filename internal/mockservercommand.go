@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/urfave/cli/v2"
+	"net/http"
+	"time"
+)
+
+var MockServerCommand = &cli.Command{
+	Name:  "mock-server",
+	Usage: "Run a fake OpenAI API server that returns scripted responses",
+	Description: `The mock server serves OpenAI compatible chat completion endpoints (including streaming).
+Set "base_url" in the config to the printed URL to run gptx against it.
+If no scripted response matches a request, the server echoes back the last user message.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "addr",
+			Aliases: []string{"a"},
+			Usage:   "Specify an `address` to listen on",
+			Value:   "127.0.0.1:8080",
+		},
+		&cli.StringFlag{
+			Name:    "responses",
+			Aliases: []string{"f"},
+			Usage:   "Load scripted responses from a TOML or JSON `file`",
+		},
+	},
+	Action: mockServerAction,
+}
+
+func mockServerAction(c *cli.Context) error {
+	handler, err := newMockServerHandler(c.String("responses"))
+	if err != nil {
+		return err
+	}
+
+	addr := c.String("addr")
+	_, _ = fmt.Fprintf(c.App.Writer, "Mock server is listening on http://%s/v1\n", addr)
+
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintf(c.App.ErrWriter, "%s %s %s\n", time.Now().Format(time.RFC3339), req.Method, req.URL.Path)
+			handler.ServeHTTP(w, req)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+func newMockServerHandler(responsesFile string) (*mockserver.Server, error) {
+	var script *mockserver.Script
+	if responsesFile != "" {
+		s, err := mockserver.LoadScript(responsesFile)
+		if err != nil {
+			return nil, err
+		}
+		script = s
+	}
+	return mockserver.New(script)
+}
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewMockServerHandler(t *testing.T) {
+	t.Run("without responses file", func(t *testing.T) {
+		h, err := newMockServerHandler("")
+		assert.NoError(t, err)
+		assert.NotNil(t, h)
+	})
+
+	t.Run("with responses file", func(t *testing.T) {
+		f := testTempFile(t, []byte(`
+[[responses]]
+match = "hello"
+content = "Hi!"
+`))
+		h, err := newMockServerHandler(f.Name())
+		assert.NoError(t, err)
+		assert.NotNil(t, h)
+	})
+
+	t.Run("invalid responses file", func(t *testing.T) {
+		_, err := newMockServerHandler("/tmp/file-not-found")
+		assert.Error(t, err)
+	})
+}
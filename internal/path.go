@@ -42,6 +42,18 @@ func (r *PathResolver) HistoryFilePath() string {
 	return filepath.Join(r.Dir, "history.txt")
 }
 
+func (r *PathResolver) AuditLogFilePath() string {
+	return filepath.Join(r.Dir, "audit.jsonl")
+}
+
+func (r *PathResolver) IndexesDir() string {
+	return filepath.Join(r.Dir, "indexes")
+}
+
+func (r *PathResolver) IndexFilePath(name string) string {
+	return filepath.Join(r.IndexesDir(), name+".db")
+}
+
 func (r *PathResolver) LibExecDir() string {
 	return filepath.Join(r.Dir, "libexec")
 }
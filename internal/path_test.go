@@ -47,6 +47,12 @@ func TestPathResolver_HistoryFilePath(t *testing.T) {
 	assert.Equal(t, filepath.Join("/tmp/gptx", "history.txt"), pr.HistoryFilePath())
 }
 
+func TestPathResolver_IndexFilePath(t *testing.T) {
+	pr := NewPathResolver("/tmp/gptx")
+	assert.Equal(t, filepath.Join("/tmp/gptx", "indexes"), pr.IndexesDir())
+	assert.Equal(t, filepath.Join("/tmp/gptx", "indexes", "docs.db"), pr.IndexFilePath("docs"))
+}
+
 func TestPathResolver_LibExecDir(t *testing.T) {
 	pr := NewPathResolver("/tmp/gptx")
 	assert.Equal(t, filepath.Join("/tmp/gptx", "libexec"), pr.LibExecDir())
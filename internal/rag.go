@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// defaultRAGTopK is the default number of the chunks injected into the prompt.
+	defaultRAGTopK = 4
+
+	// defaultIndexChunkSize is the default maximum number of characters of a chunk.
+	defaultIndexChunkSize = 1500
+)
+
+// ragInstruction is prepended to the chunks injected into the prompt.
+const ragInstruction = `Use the following excerpts of the documents to answer the question.
+Cite the excerpts you used by their numbers such as [1]. If they are not relevant to the question, answer without them.`
+
+// RAGSource is a chunk of a document that was injected into a prompt.
+type RAGSource struct {
+	Index     string  `json:"index"`
+	Path      string  `json:"path"`
+	StartLine int     `json:"start_line"`
+	EndLine   int     `json:"end_line"`
+	Score     float64 `json:"score"`
+}
+
+// Citation returns the location of the chunk such as "runbooks/deploy.md:10-42".
+func (s *RAGSource) Citation() string {
+	return fmt.Sprintf("%s:%d-%d", s.Path, s.StartLine, s.EndLine)
+}
+
+// Indexer chunks the documents in a directory and computes their embeddings.
+type Indexer struct {
+	Embed     func(ctx context.Context, text string) ([]float32, error)
+	Model     string   // The name of the embedder
+	ChunkSize int      // The maximum number of characters of a chunk
+	Include   []string // Glob patterns of the file names to index. If it is empty, all the text files are indexed
+}
+
+// IndexResult is the summary of the indexing.
+type IndexResult struct {
+	Files    int // The number of the indexed files
+	Chunks   int // The number of the chunks
+	Embedded int // The number of the chunks whose embeddings were computed. The others reused the embeddings of the previous index
+}
+
+// Index replaces the chunks of the index with the documents in the directory.
+// The hidden files and directories, and the binary files are skipped.
+// The embeddings of the unchanged chunks are reused if the index was built with the same model.
+func (ix *Indexer) Index(ctx context.Context, idx *DocIndex, dir string) (*IndexResult, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	for _, pattern := range ix.Include {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	chunkSize := ix.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultIndexChunkSize
+	}
+
+	reusable := map[string][]float32{}
+	info, err := idx.Info()
+	if err != nil {
+		return nil, err
+	}
+	if info != nil && info.Model == ix.Model {
+		previous, err := idx.Chunks()
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range previous {
+			reusable[chunk.Text] = chunk.Vector
+		}
+	}
+
+	result := &IndexResult{}
+	var chunks []*IndexChunk
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !ix.includes(d.Name()) {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !isTextContent(b) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fileChunks := chunkText(filepath.ToSlash(rel), string(b), chunkSize)
+		if len(fileChunks) == 0 {
+			return nil
+		}
+		for _, chunk := range fileChunks {
+			if v, ok := reusable[chunk.Text]; ok {
+				chunk.Vector = v
+				continue
+			}
+			v, err := ix.Embed(ctx, chunk.Text)
+			if err != nil {
+				return fmt.Errorf("failed to compute the embedding of %s: %w", chunk.Path, err)
+			}
+			chunk.Vector = v
+			result.Embedded++
+		}
+		chunks = append(chunks, fileChunks...)
+		result.Files++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Chunks = len(chunks)
+
+	if err := idx.Replace(&IndexInfo{
+		Name:      idx.Name,
+		Dir:       dir,
+		Model:     ix.Model,
+		Files:     result.Files,
+		IndexedAt: time.Now().UTC(),
+	}, chunks); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (ix *Indexer) includes(name string) bool {
+	if len(ix.Include) == 0 {
+		return true
+	}
+	for _, pattern := range ix.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isTextContent reports whether the content of a file looks like a text.
+func isTextContent(b []byte) bool {
+	head := b
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return bytes.IndexByte(head, 0) == -1 && utf8.Valid(b)
+}
+
+// chunkText splits the text into the chunks of at most size characters at the line boundaries.
+// A line longer than the size is split into multiple chunks. Blank chunks are skipped.
+func chunkText(path string, text string, size int) []*IndexChunk {
+	var chunks []*IndexChunk
+	var buf strings.Builder
+	start := 0
+	flush := func(end int) {
+		if strings.TrimSpace(buf.String()) != "" {
+			chunks = append(chunks, &IndexChunk{
+				Path:      path,
+				StartLine: start,
+				EndLine:   end,
+				Text:      buf.String(),
+			})
+		}
+		buf.Reset()
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		n := i + 1
+		// the long line is split into the pieces of the size
+		for len(line) > size {
+			if buf.Len() > 0 {
+				flush(n - 1)
+			}
+			cut := size
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			start = n
+			buf.WriteString(line[:cut])
+			flush(n)
+			line = line[cut:]
+		}
+		if buf.Len() > 0 && buf.Len()+1+len(line) > size {
+			flush(n - 1)
+		}
+		if buf.Len() == 0 {
+			start = n
+		} else {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(line)
+	}
+	flush(len(lines))
+	return chunks
+}
+
+// ragPrompt injects the chunks with their numbers and locations into the prompt.
+func ragPrompt(prompt string, chunks []*ScoredChunk) string {
+	var b strings.Builder
+	b.WriteString(ragInstruction)
+	b.WriteString("\n\n")
+	for i, chunk := range chunks {
+		_, _ = fmt.Fprintf(&b, "[%d] %s:%d-%d\n\"\"\"\n%s\n\"\"\"\n\n", i+1, chunk.Path, chunk.StartLine, chunk.EndLine, strings.TrimRight(chunk.Text, "\n"))
+	}
+	b.WriteString("Question:\n")
+	b.WriteString(prompt)
+	return b.String()
+}
+
+// augmentPrompt retrieves the chunks of the index most similar to the prompt, and injects them into the prompt.
+// It returns the prompt as it is if no chunk is similar enough.
+func (c *ChatService) augmentPrompt(ctx context.Context, prompt string) (string, []*RAGSource, error) {
+	idx, err := c.IndexManager.Open(c.RAGIndex, false)
+	if err != nil {
+		return "", nil, err
+	}
+	defer idx.Close()
+
+	info, err := idx.Info()
+	if err != nil {
+		return "", nil, err
+	}
+	if info == nil {
+		return "", nil, &IndexNotFoundError{Name: c.RAGIndex}
+	}
+	if info.Model != c.Embedder.Name() {
+		return "", nil, fmt.Errorf("the index %s was built with the embedding model %q, but %q is configured. rebuild it with 'gptx index'", c.RAGIndex, info.Model, c.Embedder.Name())
+	}
+
+	// the prompt is sent to the embedding API, so it is redacted like the chat messages
+	vector, err := c.embed(ctx, c.redactText(prompt))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to compute the embedding of the prompt: %w", err)
+	}
+	chunks, err := idx.Search(vector, c.RAGTopK, c.RAGMinScore)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(chunks) == 0 {
+		return prompt, nil, nil
+	}
+
+	sources := make([]*RAGSource, 0, len(chunks))
+	for _, chunk := range chunks {
+		sources = append(sources, &RAGSource{
+			Index:     c.RAGIndex,
+			Path:      chunk.Path,
+			StartLine: chunk.StartLine,
+			EndLine:   chunk.EndLine,
+			Score:     chunk.Score,
+		})
+	}
+	return ragPrompt(prompt, chunks), sources, nil
+}
+
+// reportRAGSources writes the injected chunks to ErrWriter if Verbose is set.
+func (c *ChatService) reportRAGSources(sources []*RAGSource) {
+	if !c.Verbose || c.ErrWriter == nil {
+		return
+	}
+	if len(sources) == 0 {
+		_, _ = fmt.Fprintf(c.ErrWriter, "No chunk of the index %s is relevant to the prompt\n", c.RAGIndex)
+		return
+	}
+	_, _ = fmt.Fprintf(c.ErrWriter, "Injected %d chunk(s) of the index %s into the prompt:\n", len(sources), c.RAGIndex)
+	for i, s := range sources {
+		_, _ = fmt.Fprintf(c.ErrWriter, "  [%d] %s (score %.3f)\n", i+1, s.Citation(), s.Score)
+	}
+}
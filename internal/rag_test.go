@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkText(t *testing.T) {
+	chunks := chunkText("a.md", "line1\nline2\n\nline4\nline5", 12)
+	var got []string
+	for _, c := range chunks {
+		got = append(got, c.Text)
+	}
+	assert.Equal(t, []string{"line1\nline2\n", "line4\nline5"}, got)
+	assert.Equal(t, 1, chunks[0].StartLine)
+	assert.Equal(t, 3, chunks[0].EndLine)
+	assert.Equal(t, 4, chunks[1].StartLine)
+	assert.Equal(t, 5, chunks[1].EndLine)
+	assert.Equal(t, "a.md", chunks[1].Path)
+
+	// a long line is split
+	chunks = chunkText("b.md", "short\n"+strings.Repeat("x", 25), 10)
+	assert.Len(t, chunks, 4)
+	assert.Equal(t, "short", chunks[0].Text)
+	assert.Equal(t, strings.Repeat("x", 10), chunks[1].Text)
+	assert.Equal(t, 2, chunks[1].StartLine)
+	assert.Equal(t, strings.Repeat("x", 5), chunks[3].Text)
+
+	// blank texts have no chunk
+	assert.Len(t, chunkText("c.md", "\n  \n", 10), 0)
+}
+
+func TestIsTextContent(t *testing.T) {
+	assert.True(t, isTextContent([]byte("# Runbook\n")))
+	assert.False(t, isTextContent([]byte{0x89, 'P', 'N', 'G', 0x00}))
+	assert.False(t, isTextContent([]byte{0xff, 0xfe}))
+}
+
+func TestIndexer_Index(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "runbooks"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "runbooks", "deploy.md"), []byte("# Deploy\nRun make deploy.\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("Restart the worker.\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "logo.png"), []byte{0x89, 'P', 'N', 'G', 0x00}, 0644))
+
+	idx, err := testIndexManager(t).Open("docs", true)
+	assert.NoError(t, err)
+	defer idx.Close()
+
+	var embedded []string
+	ix := &Indexer{
+		Embed: func(ctx context.Context, text string) ([]float32, error) {
+			embedded = append(embedded, text)
+			return []float32{1, float32(len(text))}, nil
+		},
+		Model: "test",
+	}
+	result, err := ix.Index(context.Background(), idx, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, &IndexResult{Files: 2, Chunks: 2, Embedded: 2}, result)
+
+	info, err := idx.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, dir, info.Dir)
+	assert.Equal(t, "test", info.Model)
+	assert.Equal(t, 2, info.Files)
+
+	// the embeddings of the unchanged chunks are reused
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("Restart the worker twice.\n"), 0644))
+	result, err = ix.Index(context.Background(), idx, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, &IndexResult{Files: 2, Chunks: 2, Embedded: 1}, result)
+	assert.Len(t, embedded, 3)
+
+	// only the files matching the patterns are indexed
+	ix.Include = []string{"*.md"}
+	result, err = ix.Index(context.Background(), idx, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Files)
+	chunks, err := idx.Chunks()
+	assert.NoError(t, err)
+	assert.Equal(t, "runbooks/deploy.md", chunks[0].Path)
+}
+
+func TestRagPrompt(t *testing.T) {
+	prompt := ragPrompt("How do I deploy?", []*ScoredChunk{
+		{IndexChunk: &IndexChunk{Path: "runbooks/deploy.md", StartLine: 1, EndLine: 2, Text: "# Deploy\nRun make deploy.\n"}, Score: 0.9},
+	})
+	assert.True(t, strings.HasPrefix(prompt, ragInstruction))
+	assert.Contains(t, prompt, "[1] runbooks/deploy.md:1-2\n\"\"\"\n# Deploy\nRun make deploy.\n\"\"\"\n")
+	assert.True(t, strings.HasSuffix(prompt, "Question:\nHow do I deploy?"))
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrRedactionEnforced is returned when the redaction is disabled while it is enforced by the config.
+var ErrRedactionEnforced = errors.New("redaction is enforced by the [redaction] section of the config. --no-redact is not allowed")
+
+// redactionPattern finds values to redact.
+// If the regular expression has a capturing group, only the first group is replaced.
+type redactionPattern struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(s string, start, end int) bool // optional validation of a match
+}
+
+// redactionDetectors are the built-in detectors. They are applied in this order.
+var redactionDetectors = []*redactionPattern{
+	{
+		name: "private_key",
+		re:   regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`),
+	},
+	{
+		name: "aws_access_key",
+		re:   regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`),
+	},
+	{
+		name: "aws_secret_key",
+		re:   regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`),
+	},
+	{
+		name: "jwt",
+		re:   regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	},
+	{
+		// assignments of secrets such as the lines of .env files
+		name: "env_secret",
+		re:   regexp.MustCompile(`(?im)^\s*(?:export\s+)?[A-Z0-9_]*(?:SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*\s*=\s*["']?([^\s"'#]+)`),
+	},
+	{
+		name: "email",
+		re:   regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+	},
+	{
+		name:  "ip",
+		re:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|[0-9A-Fa-f:.]*:[0-9A-Fa-f:.]*:[0-9A-Fa-f:.]*`),
+		valid: isIPAddressMatch,
+	},
+}
+
+// defaultRedactionDetectors are the detectors enabled by default.
+// email and ip are not enabled by default because they are often a legitimate part of a question.
+var defaultRedactionDetectors = []string{"private_key", "aws_access_key", "aws_secret_key", "jwt", "env_secret"}
+
+// redactionPlaceholderRegexp matches the placeholders of the redacted values.
+var redactionPlaceholderRegexp = regexp.MustCompile(`\[REDACTED_[A-Z0-9_]+_\d+\]`)
+
+func isIPAddressMatch(s string, start, end int) bool {
+	// the match must not be a part of a word such as "std::vector"
+	if start > 0 && isWordByte(s[start-1]) || end < len(s) && isWordByte(s[end]) {
+		return false
+	}
+	return net.ParseIP(s[start:end]) != nil
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// Redactor replaces secrets in the prompts with placeholders before they are sent to the API.
+type Redactor struct {
+	patterns []*redactionPattern
+}
+
+// NewRedactor creates a Redactor from the config.
+// The custom rules are applied after the built-in detectors.
+func NewRedactor(config *RedactionConfig) (*Redactor, error) {
+	r := &Redactor{}
+	for _, name := range config.Detectors {
+		p := findRedactionDetector(name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		r.patterns = append(r.patterns, p)
+	}
+	// keep the built-in order regardless of the order in the config
+	sort.SliceStable(r.patterns, func(i, j int) bool {
+		return redactionDetectorIndex(r.patterns[i].name) < redactionDetectorIndex(r.patterns[j].name)
+	})
+	for _, rule := range config.Rules {
+		if rule.Name == "" || rule.Pattern == "" {
+			return nil, errors.New("a redaction rule requires a name and a pattern")
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of the redaction rule %q: %w", rule.Name, err)
+		}
+		r.patterns = append(r.patterns, &redactionPattern{name: rule.Name, re: re})
+	}
+	return r, nil
+}
+
+func findRedactionDetector(name string) *redactionPattern {
+	for _, p := range redactionDetectors {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func redactionDetectorIndex(name string) int {
+	for i, p := range redactionDetectors {
+		if p.name == name {
+			return i
+		}
+	}
+	return len(redactionDetectors)
+}
+
+// NewRedaction starts a redaction. The same value is replaced with the same placeholder within a redaction.
+func (r *Redactor) NewRedaction() *Redaction {
+	return &Redaction{
+		redactor:     r,
+		placeholders: map[string]string{},
+		values:       map[string]string{},
+		counters:     map[string]int{},
+		counts:       map[string]int{},
+	}
+}
+
+// Redaction holds the values replaced by a Redactor so that they can be restored.
+type Redaction struct {
+	redactor     *Redactor
+	placeholders map[string]string // value -> placeholder
+	values       map[string]string // placeholder -> value
+	counters     map[string]int    // pattern name -> number of the distinct values
+	counts       map[string]int    // placeholder -> number of the replacements
+	order        []*RedactedValue
+}
+
+// RedactedValue describes a redacted value without the value itself.
+type RedactedValue struct {
+	Name        string // The name of the detector or the rule
+	Placeholder string
+	Count       int
+}
+
+// Redact replaces the secrets in the text with placeholders.
+func (rd *Redaction) Redact(s string) string {
+	for _, p := range rd.redactor.patterns {
+		s = rd.redactPattern(p, s)
+	}
+	return s
+}
+
+func (rd *Redaction) redactPattern(p *redactionPattern, s string) string {
+	matches := p.re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 {
+			// replace only the first capturing group
+			start, end = m[2], m[3]
+		}
+		if start < 0 || start == end || start < last {
+			continue
+		}
+		value := s[start:end]
+		if redactionPlaceholderRegexp.MatchString(value) {
+			continue
+		}
+		if p.valid != nil && !p.valid(s, start, end) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(rd.placeholder(p.name, value))
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func (rd *Redaction) placeholder(name, value string) string {
+	ph, ok := rd.placeholders[value]
+	if !ok {
+		rd.counters[name]++
+		ph = fmt.Sprintf("[REDACTED_%s_%d]", placeholderName(name), rd.counters[name])
+		rd.placeholders[value] = ph
+		rd.values[ph] = value
+		rd.order = append(rd.order, &RedactedValue{Name: name, Placeholder: ph})
+	}
+	rd.counts[ph]++
+	return ph
+}
+
+func placeholderName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Restore replaces the placeholders in the text with the original values.
+func (rd *Redaction) Restore(s string) string {
+	if len(rd.values) == 0 {
+		return s
+	}
+	return redactionPlaceholderRegexp.ReplaceAllStringFunc(s, func(ph string) string {
+		if v, ok := rd.values[ph]; ok {
+			return v
+		}
+		return ph
+	})
+}
+
+// Values returns the redacted values in the order they were found.
+func (rd *Redaction) Values() []*RedactedValue {
+	ret := make([]*RedactedValue, 0, len(rd.order))
+	for _, v := range rd.order {
+		ret = append(ret, &RedactedValue{Name: v.Name, Placeholder: v.Placeholder, Count: rd.counts[v.Placeholder]})
+	}
+	return ret
+}
+
+// Report writes what was redacted. It writes nothing if nothing was redacted.
+func (rd *Redaction) Report(w io.Writer) {
+	values := rd.Values()
+	if len(values) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "Redacted %d value(s) before sending the request:\n", len(values))
+	for _, v := range values {
+		_, _ = fmt.Fprintf(w, "  %s %s (%d occurrence(s))\n", v.Placeholder, v.Name, v.Count)
+	}
+}
+
+// redactMessages returns a copy of the messages whose contents are redacted.
+// It returns a nil Redaction if the redaction is disabled.
+func (c *ChatService) redactMessages(messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, *Redaction) {
+	if c.Redactor == nil {
+		return messages, nil
+	}
+	rd := c.Redactor.NewRedaction()
+	ret := make([]openai.ChatCompletionMessage, len(messages))
+	for i, m := range messages {
+		m.Content = rd.Redact(m.Content)
+		ret[i] = m
+	}
+	if c.Verbose && c.ErrWriter != nil {
+		rd.Report(c.ErrWriter)
+	}
+	return ret, rd
+}
+
+// redactText returns the redacted text. It is used for the text sent to the API other than the chat messages, such as the text to embed.
+func (c *ChatService) redactText(text string) string {
+	if c.Redactor == nil {
+		return text
+	}
+	return c.Redactor.NewRedaction().Redact(text)
+}
+
+// restoreRedacted restores the redacted values in the completion if it is enabled.
+func (c *ChatService) restoreRedacted(rd *Redaction, s string) string {
+	if rd == nil || !c.RestoreRedacted {
+		return s
+	}
+	return rd.Restore(s)
+}
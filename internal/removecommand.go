@@ -1,23 +1,74 @@
 package internal
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/urfave/cli/v2"
-	"strconv"
+	"strings"
+	"time"
 )
 
 var DeleteCommand = &cli.Command{
 	Name:      "remove",
 	Aliases:   []string{"rm"},
 	Usage:     "Remove one or more conversations",
-	ArgsUsage: `[conversation_id...]`,
-	Action:    deleteAction,
+	ArgsUsage: `[conversation...]`,
+	Description: `Remove the conversations specified by ids or names.
+You can also remove the conversations in bulk with the filter options instead of the arguments.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "label",
+			Aliases: []string{"l"},
+			Usage:   "Remove the conversations with the `label`",
+		},
+		&cli.StringFlag{
+			Name:    "name",
+			Aliases: []string{"n"},
+			Usage:   "Remove the conversations whose names match the glob `pattern` (e.g. 'tmp-*')",
+		},
+		&cli.StringFlag{
+			Name:  "older-than",
+			Usage: "Remove the conversations that have not been updated since the `time` (e.g. 30d, 12h, 2006-01-02 or RFC3339)",
+		},
+		&cli.IntFlag{
+			Name:        "max-messages",
+			Usage:       "Remove the conversations that have at most the `number` of messages",
+			DefaultText: "0 (no limit)",
+		},
+		&cli.BoolFlag{
+			Name:               "dry-run",
+			Usage:              "Display the conversations to remove without removing them",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:               "yes",
+			Aliases:            []string{"y"},
+			Usage:              "Remove the conversations without confirmation",
+			DisableDefaultText: true,
+		},
+	},
+	Action: deleteAction,
 }
 
 var deleteAction = repositoryAwareAction(func(c *cli.Context, r *Repository) (err error) {
-	if c.NArg() == 0 {
+	query := &ListConversationsQuery{
+		Label:       c.String("label"),
+		Name:        c.String("name"),
+		MaxMessages: c.Int("max-messages"),
+	}
+	if query.UpdatedUntil, err = getTimeValueFromStringFlag(c, "older-than"); err != nil {
+		return err
+	}
+	bulk := query.Label != "" || query.Name != "" || query.MaxMessages > 0 || query.UpdatedUntil != nil
+
+	if c.NArg() == 0 && !bulk {
 		return fmt.Errorf("missing conversation id")
 	}
+	if c.NArg() > 0 && bulk {
+		return errors.New("conversation arguments can not be used with the filter options")
+	}
 
 	store, err := r.StoreManager.Open()
 	if err != nil {
@@ -25,14 +76,69 @@ var deleteAction = repositoryAwareAction(func(c *cli.Context, r *Repository) (er
 	}
 	defer store.Close()
 
-	for _, id := range c.Args().Slice() {
-		uint64v, err := strconv.ParseUint(id, 10, 64)
-		if err != nil {
-			return fmt.Errorf("invalid conversation id: %s", id)
+	if !bulk {
+		// remove the conversations specified by the arguments without confirmation as before
+		ids := make([]uint64, 0, c.NArg())
+		for _, key := range c.Args().Slice() {
+			co, err := store.GetConversationByKey(NewConversationKey(key))
+			if err != nil {
+				return err
+			}
+			ids = append(ids, co.Id)
 		}
-		if err := store.DeleteConversationById(uint64v); err != nil {
-			return err
+		if c.Bool("dry-run") {
+			for _, id := range ids {
+				_, _ = fmt.Fprintln(c.App.Writer, id)
+			}
+			return nil
+		}
+		return store.DeleteConversations(ids)
+	}
+
+	list, err := store.ListConversations(query)
+	if err != nil {
+		return err
+	}
+	if len(list.Conversations) == 0 {
+		_, _ = fmt.Fprintln(c.App.Writer, "No conversations to remove.")
+		return nil
+	}
+
+	t := NewSimpleTableWriter(c.App.Writer)
+	t.AppendHeader(table.Row{"ID", "PROMPT", "MESSAGES", "NAME", "LABEL", "UPDATED"})
+	ids := make([]uint64, 0, len(list.Conversations))
+	for _, co := range list.Conversations {
+		t.AppendRow([]interface{}{
+			co.Id,
+			truncateChars(strings.ReplaceAll(co.Prompt, "\n", " "), 50),
+			len(co.Messages),
+			co.Name,
+			co.Label,
+			co.LastUpdatedAt().Format(time.RFC3339),
+		})
+		ids = append(ids, co.Id)
+	}
+	t.Render()
+
+	if c.Bool("dry-run") {
+		_, _ = fmt.Fprintf(c.App.Writer, "%d conversation(s) would be removed (dry run)\n", len(ids))
+		return nil
+	}
+
+	if !c.Bool("yes") {
+		_, _ = fmt.Fprintf(c.App.Writer, "Remove %d conversation(s)? [y/N]: ", len(ids))
+		answer, _ := bufio.NewReader(c.App.Reader).ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+		default:
+			_, _ = fmt.Fprintln(c.App.Writer, "Canceled.")
+			return nil
 		}
 	}
+
+	if err := store.DeleteConversations(ids); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.App.Writer, "%d conversation(s) removed\n", len(ids))
 	return nil
 })
@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	"strings"
 	"testing"
 )
 
@@ -37,3 +40,68 @@ func TestRemoveCommand(t *testing.T) {
 	})
 
 }
+
+func TestRemoveCommand_Bulk(t *testing.T) {
+	setup := func(t *testing.T) (*cli.App, *Store) {
+		app := testNewApp(t)
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		testCreateSearchConversations(t, s)
+		assert.NoError(t, s.RenameConversationByKey(NewConversationKey("2"), "japan"))
+		return app, s
+	}
+
+	t.Run("remove by name", func(t *testing.T) {
+		app, _ := setup(t)
+		err := app.Run([]string{"gptx", "remove", "japan"})
+		assert.NoError(t, err)
+
+		s, err := app.Metadata["repository"].(*Repository).StoreManager.Open()
+		assert.NoError(t, err)
+		_, err = s.GetConversationById(2)
+		assert.IsType(t, &ConversationNotFoundError{}, err)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		app, _ := setup(t)
+		err := app.Run([]string{"gptx", "remove", "--label", "web", "--dry-run"})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "2 conversation(s) would be removed (dry run)")
+
+		s, err := app.Metadata["repository"].(*Repository).StoreManager.Open()
+		assert.NoError(t, err)
+		_, err = s.GetConversationById(1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		app, _ := setup(t)
+		app.Reader = strings.NewReader("n\n")
+		err := app.Run([]string{"gptx", "remove", "--label", "web"})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "Canceled.")
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		app, _ := setup(t)
+		app.Reader = strings.NewReader("y\n")
+		err := app.Run([]string{"gptx", "remove", "--label", "web"})
+		assert.NoError(t, err)
+		assert.Contains(t, app.Writer.(*bytes.Buffer).String(), "2 conversation(s) removed")
+
+		s, err := app.Metadata["repository"].(*Repository).StoreManager.Open()
+		assert.NoError(t, err)
+		l, err := s.ListConversations(&ListConversationsQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, l.Conversations, 1)
+		assert.Equal(t, uint64(2), l.Conversations[0].Id)
+	})
+
+	t.Run("arguments with filters", func(t *testing.T) {
+		app, _ := setup(t)
+		err := app.Run([]string{"gptx", "remove", "--label", "web", "1"})
+		assert.Error(t, err)
+	})
+}
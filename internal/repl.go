@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chzyer/readline"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// replCommand is a dot-command of the REPL that acts on the chat service.
+type replCommand struct {
+	Name  string
+	Args  string
+	Usage string
+	Run   func(w io.Writer, sv *ChatService, arg string) error
+}
+
+// replCommands are the dot-commands other than the ones that control the REPL itself (.editor, .exit, .quit and .help).
+var replCommands = []*replCommand{
+	{Name: ".model", Args: "[name]", Usage: "Show or change the model", Run: replModel},
+	{Name: ".temp", Args: "[value]", Usage: "Show or change the temperature (0-2)", Run: replTemp},
+	{Name: ".system", Args: "[text]", Usage: "Show or set the system message of the conversation", Run: replSystem},
+	{Name: ".history", Usage: "Print the conversation so far", Run: replHistory},
+	{Name: ".retry", Usage: "Regenerate the last answer", Run: replRetry},
+	{Name: ".undo", Usage: "Drop the last message and its answer", Run: replUndo},
+	{Name: ".new", Usage: "Start a new conversation", Run: replNew},
+	{Name: ".resume", Args: "<conversation>", Usage: "Switch to an existing conversation", Run: replResume},
+	{Name: ".name", Args: "[name]", Usage: "Show or set the name of the conversation", Run: replName},
+	{Name: ".label", Args: "[label]", Usage: "Show or set the label of the conversation", Run: replLabel},
+	{Name: ".tokens", Usage: "Show the estimated number of tokens of the conversation", Run: replTokens},
+	{Name: ".save", Args: "<file>", Usage: "Export the conversation to the file. The format is chosen by the extension (.md, .html, .json or .jsonl)", Run: replSave},
+}
+
+// replHelp returns the help text of the REPL.
+func replHelp() string {
+	var b strings.Builder
+	b.WriteString("Available commands:\n")
+	line := func(name, args, usage string) {
+		_, _ = fmt.Fprintf(&b, "  %-22s %s\n", strings.TrimSpace(name+" "+args), usage)
+	}
+	line(".editor", "", "Enter editor mode to input multi-line text")
+	for _, cmd := range replCommands {
+		line(cmd.Name, cmd.Args, cmd.Usage)
+	}
+	line(".exit", "", "Exit REPL")
+	line(".quit", "", "Alias for .exit")
+	line(".help", "", "Show this help")
+	b.WriteString("\nPress Ctrl+C to abort current expression, Ctrl+D to exit the REPL")
+	return b.String()
+}
+
+// replCompleter returns the completer of the dot-commands.
+func replCompleter() *readline.PrefixCompleter {
+	items := []readline.PrefixCompleterInterface{readline.PcItem(".editor")}
+	for _, cmd := range replCommands {
+		items = append(items, readline.PcItem(cmd.Name))
+	}
+	items = append(items, readline.PcItem(".exit"), readline.PcItem(".quit"), readline.PcItem(".help"))
+	return readline.NewPrefixCompleter(items...)
+}
+
+// runREPLCommand runs the dot-command of the line. It returns false if the command is unknown.
+func runREPLCommand(w io.Writer, sv *ChatService, line string) (bool, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	for _, cmd := range replCommands {
+		if cmd.Name == name {
+			return true, cmd.Run(w, sv, strings.TrimSpace(arg))
+		}
+	}
+	return false, nil
+}
+
+// updateStoredConversation stores the change of the conversation if it has been stored.
+// If touch is false, the last updated time of the conversation is not changed.
+func (c *ChatService) updateStoredConversation(touch bool) error {
+	if c.OnMemory || c.Conversation.IsNew() {
+		// the conversation is stored with the change by the next message
+		return nil
+	}
+	store, err := c.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if touch {
+		return store.UpdateConversation(c.Conversation)
+	}
+	return store.UpdateConversationState(c.Conversation)
+}
+
+func replModel(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		_, _ = fmt.Fprintf(w, "Model: %s\n", sv.Model)
+		return nil
+	}
+	sv.Model = arg
+	_, _ = fmt.Fprintf(w, "Model set to %s\n", sv.Model)
+	return nil
+}
+
+func replTemp(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		_, _ = fmt.Fprintf(w, "Temperature: %s\n", strconv.FormatFloat(float64(sv.Temperature), 'f', -1, 32))
+		return nil
+	}
+	v, err := strconv.ParseFloat(arg, 32)
+	if err != nil || v < 0 || v > 2 {
+		return fmt.Errorf("invalid temperature: %s (it must be between 0 and 2)", arg)
+	}
+	sv.Temperature = float32(v)
+	_, _ = fmt.Fprintf(w, "Temperature set to %s\n", strconv.FormatFloat(v, 'f', -1, 32))
+	return nil
+}
+
+func replSystem(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		for _, m := range sv.Conversation.Messages {
+			if m.Role == openai.ChatMessageRoleSystem && !isCompactionSummary(m) {
+				_, _ = fmt.Fprintln(w, m.Content)
+				return nil
+			}
+		}
+		_, _ = fmt.Fprintln(w, "No system message")
+		return nil
+	}
+	sv.Conversation.SetSystemMessage(arg)
+	if err := sv.updateStoredConversation(true); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(w, "System message set")
+	return nil
+}
+
+func replHistory(w io.Writer, sv *ChatService, _ string) error {
+	if len(sv.Conversation.Messages) == 0 {
+		_, _ = fmt.Fprintln(w, "No messages yet")
+		return nil
+	}
+	for i, m := range sv.Conversation.Messages {
+		if i > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		_, _ = fmt.Fprintf(w, "%s:\n%s\n", roleTitle(m.Role), m.Content)
+	}
+	return nil
+}
+
+func replRetry(_ io.Writer, sv *ChatService, _ string) error {
+	return sv.Retry()
+}
+
+func replUndo(w io.Writer, sv *ChatService, _ string) error {
+	index := -1
+	for i := len(sv.Conversation.Messages) - 1; i >= 0; i-- {
+		if sv.Conversation.Messages[i].Role == openai.ChatMessageRoleUser {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return errors.New("no message to undo")
+	}
+	n := len(sv.Conversation.Messages) - index
+	sv.Conversation.TruncateMessages(index)
+	if err := sv.updateStoredConversation(true); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "Removed %d message(s)\n", n)
+	return nil
+}
+
+func replNew(w io.Writer, sv *ChatService, _ string) error {
+	co := NewConversation()
+	// the hooks of the current conversation are kept
+	co.Hooks = sv.Conversation.Hooks
+	sv.Conversation = co
+	_, _ = fmt.Fprintln(w, "Started a new conversation")
+	return nil
+}
+
+func replResume(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		return errors.New("a conversation is required")
+	}
+	current := sv.Conversation
+	if err := sv.InitConversation(arg, "", ""); err != nil {
+		return err
+	}
+	if err := sv.LoadHooks(nil); err != nil {
+		sv.Conversation = current
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "Resumed %s (%d message(s))\n", exportTitle(sv.Conversation), len(sv.Conversation.Messages))
+	return nil
+}
+
+func replName(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		if sv.Conversation.Name == "" {
+			_, _ = fmt.Fprintln(w, "No name")
+		} else {
+			_, _ = fmt.Fprintf(w, "Name: %s\n", sv.Conversation.Name)
+		}
+		return nil
+	}
+	if err := checkValidConversationName(arg); err != nil {
+		return err
+	}
+	if sv.Conversation.IsNew() {
+		// the stored conversations check the duplicate names when they are updated
+		ok, err := sv.isExistsConversationByName(arg)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("conversation with name %q already exists", arg)
+		}
+	}
+	old := sv.Conversation.Name
+	sv.Conversation.Name = arg
+	if err := sv.updateStoredConversation(false); err != nil {
+		sv.Conversation.Name = old
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "Named the conversation %s\n", arg)
+	return nil
+}
+
+func replLabel(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		if sv.Conversation.Label == "" {
+			_, _ = fmt.Fprintln(w, "No label")
+		} else {
+			_, _ = fmt.Fprintf(w, "Label: %s\n", sv.Conversation.Label)
+		}
+		return nil
+	}
+	old := sv.Conversation.Label
+	sv.Conversation.Label = arg
+	if err := sv.updateStoredConversation(false); err != nil {
+		sv.Conversation.Label = old
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "Labeled the conversation %s\n", arg)
+	return nil
+}
+
+func replTokens(w io.Writer, sv *ChatService, _ string) error {
+	_, _ = fmt.Fprintf(w, "About %d token(s) in %d message(s)\n", estimateTokens(sv.Conversation.Messages), len(sv.Conversation.Messages))
+	return nil
+}
+
+func replSave(w io.Writer, sv *ChatService, arg string) error {
+	if arg == "" {
+		return errors.New("a file is required")
+	}
+	if len(sv.Conversation.Messages) == 0 {
+		return errors.New("no messages to save")
+	}
+	format := ExportFormats["markdown"]
+	ext := strings.TrimPrefix(filepath.Ext(arg), ".")
+	for _, f := range ExportFormats {
+		if f.Extension == ext {
+			format = f
+			break
+		}
+	}
+
+	if _, err := os.Stat(arg); err == nil {
+		return fmt.Errorf("%s already exists", arg)
+	}
+	f, err := os.OpenFile(arg, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := format.Write(f, []*Conversation{sv.Conversation}); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "Saved the conversation to %s as %s\n", arg, format.Name)
+	return nil
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testREPLChatService(t *testing.T) (*Repository, *ChatService, *mockserver.Server, *bytes.Buffer) {
+	t.Helper()
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+
+	ms, err := mockserver.New(&mockserver.Script{
+		Responses: []*mockserver.Response{
+			{Model: "other-model", Content: "answer from the other model"},
+			{Match: `(?i)capital of japan`, Content: "Tokyo"},
+			{Content: "I see."},
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(ms)
+	t.Cleanup(ts.Close)
+	r.ClientConfig.BaseURL = ts.URL + "/v1"
+
+	sv, err := r.NewChatService(app.Writer, app.ErrWriter)
+	assert.NoError(t, err)
+	sv.DisableOutputAnimation()
+	sv.NoLoading = true
+	sv.Model = "test-model"
+	assert.NoError(t, sv.InitConversation("", "", ""))
+	assert.NoError(t, sv.LoadHooks(nil))
+	return r, sv, ms, app.Writer.(*bytes.Buffer)
+}
+
+func testGetConversation(t *testing.T, r *Repository, id uint64) *Conversation {
+	t.Helper()
+	store, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	defer store.Close()
+	co, err := store.GetConversationById(id)
+	assert.NoError(t, err)
+	return co
+}
+
+func TestRunREPLCommand(t *testing.T) {
+	t.Run("unknown command", func(t *testing.T) {
+		_, sv, _, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+		ok, err := runREPLCommand(w, sv, ".unknown")
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("model and temp", func(t *testing.T) {
+		_, sv, ms, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		ok, err := runREPLCommand(w, sv, ".model other-model")
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".temp 0.5")
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".temp 3")
+		assert.EqualError(t, err, "invalid temperature: 3 (it must be between 0 and 2)")
+		_, err = runREPLCommand(w, sv, ".temp abc")
+		assert.Error(t, err)
+		_, err = runREPLCommand(w, sv, ".model")
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".temp")
+		assert.NoError(t, err)
+		assert.Equal(t, "Model set to other-model\nTemperature set to 0.5\nModel: other-model\nTemperature: 0.5\n", w.String())
+
+		assert.NoError(t, sv.Chat("hello"))
+		reqs := ms.Requests()
+		assert.Equal(t, 1, len(reqs))
+		assert.Equal(t, "other-model", reqs[0].Model)
+		assert.Equal(t, float32(0.5), reqs[0].Temperature)
+		assert.Equal(t, "other-model", sv.Conversation.Model)
+	})
+
+	t.Run("system", func(t *testing.T) {
+		r, sv, ms, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		_, err := runREPLCommand(w, sv, ".system")
+		assert.NoError(t, err)
+		assert.Equal(t, "No system message\n", w.String())
+
+		assert.NoError(t, sv.Chat("hello"))
+		_, err = runREPLCommand(w, sv, ".system Answer briefly.")
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".system Answer in Japanese.")
+		assert.NoError(t, err)
+		w.Reset()
+		_, err = runREPLCommand(w, sv, ".system")
+		assert.NoError(t, err)
+		assert.Equal(t, "Answer in Japanese.\n", w.String())
+
+		co := testGetConversation(t, r, sv.Conversation.Id)
+		assert.Equal(t, 3, len(co.Messages))
+		assert.Equal(t, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "Answer in Japanese."}, co.Messages[0])
+
+		assert.NoError(t, sv.Chat("what is the capital of japan?"))
+		reqs := ms.Requests()
+		assert.Equal(t, "Answer in Japanese.", reqs[1].Messages[0].Content)
+	})
+
+	t.Run("history and tokens", func(t *testing.T) {
+		_, sv, _, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		_, err := runREPLCommand(w, sv, ".history")
+		assert.NoError(t, err)
+		assert.Equal(t, "No messages yet\n", w.String())
+
+		assert.NoError(t, sv.Chat("What is the capital of Japan?"))
+		w.Reset()
+		_, err = runREPLCommand(w, sv, ".history")
+		assert.NoError(t, err)
+		assert.Equal(t, "User:\nWhat is the capital of Japan?\n\nAssistant:\nTokyo\n", w.String())
+
+		w.Reset()
+		_, err = runREPLCommand(w, sv, ".tokens")
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("About %d token(s) in 2 message(s)\n", estimateTokens(sv.Conversation.Messages)), w.String())
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		r, sv, ms, out := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		_, err := runREPLCommand(w, sv, ".retry")
+		assert.EqualError(t, err, "no answer to retry")
+
+		assert.NoError(t, sv.Chat("What is the capital of Japan?"))
+		out.Reset()
+		_, err = runREPLCommand(w, sv, ".retry")
+		assert.NoError(t, err)
+		assert.Equal(t, "Tokyo\n", out.String())
+		assert.False(t, sv.RefreshCache)
+
+		// the cached response is not used
+		reqs := ms.Requests()
+		assert.Equal(t, 2, len(reqs))
+		assert.Equal(t, reqs[0].Messages, reqs[1].Messages)
+
+		co := testGetConversation(t, r, sv.Conversation.Id)
+		assert.Equal(t, 2, len(co.Messages))
+		assert.Equal(t, "Tokyo", co.Messages[1].Content)
+	})
+
+	t.Run("undo", func(t *testing.T) {
+		r, sv, _, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		_, err := runREPLCommand(w, sv, ".undo")
+		assert.EqualError(t, err, "no message to undo")
+
+		assert.NoError(t, sv.Chat("hello"))
+		assert.NoError(t, sv.Chat("What is the capital of Japan?"))
+		_, err = runREPLCommand(w, sv, ".undo")
+		assert.NoError(t, err)
+		assert.Equal(t, "Removed 2 message(s)\n", w.String())
+
+		co := testGetConversation(t, r, sv.Conversation.Id)
+		assert.Equal(t, []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "hello"},
+			{Role: openai.ChatMessageRoleAssistant, Content: "I see."},
+		}, co.Messages)
+	})
+
+	t.Run("new and resume", func(t *testing.T) {
+		_, sv, _, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		assert.NoError(t, sv.Chat("hello"))
+		id := sv.Conversation.Id
+		_, err := runREPLCommand(w, sv, ".new")
+		assert.NoError(t, err)
+		assert.True(t, sv.Conversation.IsNew())
+
+		assert.NoError(t, sv.Chat("What is the capital of Japan?"))
+		assert.NotEqual(t, id, sv.Conversation.Id)
+		assert.Equal(t, 2, len(sv.Conversation.Messages))
+
+		_, err = runREPLCommand(w, sv, ".resume")
+		assert.EqualError(t, err, "a conversation is required")
+		_, err = runREPLCommand(w, sv, ".resume 999")
+		assert.Error(t, err)
+
+		w.Reset()
+		_, err = runREPLCommand(w, sv, ".resume 1")
+		assert.NoError(t, err)
+		assert.Equal(t, "Resumed Conversation 1 (2 message(s))\n", w.String())
+		assert.Equal(t, id, sv.Conversation.Id)
+		assert.Equal(t, "hello", sv.Conversation.Messages[0].Content)
+	})
+
+	t.Run("name and label", func(t *testing.T) {
+		r, sv, _, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+
+		// the name and the label of a new conversation are stored with the first message
+		_, err := runREPLCommand(w, sv, ".name first")
+		assert.NoError(t, err)
+		assert.NoError(t, sv.Chat("hello"))
+		co := testGetConversation(t, r, sv.Conversation.Id)
+		assert.Equal(t, "first", co.Name)
+
+		_, err = runREPLCommand(w, sv, ".new")
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".name first")
+		assert.EqualError(t, err, `conversation with name "first" already exists`)
+		_, err = runREPLCommand(w, sv, ".name 123")
+		assert.Error(t, err)
+
+		assert.NoError(t, sv.Chat("What is the capital of Japan?"))
+		_, err = runREPLCommand(w, sv, ".name first")
+		assert.Error(t, err)
+		assert.Equal(t, "", sv.Conversation.Name)
+		_, err = runREPLCommand(w, sv, ".name second")
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".label travel")
+		assert.NoError(t, err)
+
+		co = testGetConversation(t, r, sv.Conversation.Id)
+		assert.Equal(t, "second", co.Name)
+		assert.Equal(t, "travel", co.Label)
+
+		w.Reset()
+		_, err = runREPLCommand(w, sv, ".name")
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".label")
+		assert.NoError(t, err)
+		assert.Equal(t, "Name: second\nLabel: travel\n", w.String())
+	})
+
+	t.Run("save", func(t *testing.T) {
+		_, sv, _, _ := testREPLChatService(t)
+		w := &bytes.Buffer{}
+		dir := t.TempDir()
+
+		_, err := runREPLCommand(w, sv, ".save "+filepath.Join(dir, "empty.md"))
+		assert.EqualError(t, err, "no messages to save")
+
+		assert.NoError(t, sv.Chat("What is the capital of Japan?"))
+		_, err = runREPLCommand(w, sv, ".save "+filepath.Join(dir, "chat.json"))
+		assert.NoError(t, err)
+		_, err = runREPLCommand(w, sv, ".save "+filepath.Join(dir, "chat.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Saved the conversation to "+filepath.Join(dir, "chat.json")+" as json\nSaved the conversation to "+filepath.Join(dir, "chat.txt")+" as markdown\n", w.String())
+
+		b, err := os.ReadFile(filepath.Join(dir, "chat.json"))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(b), "{"))
+		assert.Contains(t, string(b), "Tokyo")
+		b, err = os.ReadFile(filepath.Join(dir, "chat.txt"))
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), "Tokyo")
+
+		// an existing file is not overwritten
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.md"), []byte("my notes\n"), 0644))
+		_, err = runREPLCommand(w, sv, ".save "+filepath.Join(dir, "notes.md"))
+		assert.EqualError(t, err, filepath.Join(dir, "notes.md")+" already exists")
+		b, err = os.ReadFile(filepath.Join(dir, "notes.md"))
+		assert.NoError(t, err)
+		assert.Equal(t, "my notes\n", string(b))
+	})
+}
+
+func TestReplHelp(t *testing.T) {
+	help := replHelp()
+	for _, name := range []string{".editor", ".exit", ".quit", ".help"} {
+		assert.Contains(t, help, "  "+name+" ")
+	}
+	for _, cmd := range replCommands {
+		assert.Contains(t, help, "  "+cmd.Name+" ")
+	}
+}
@@ -3,12 +3,14 @@ package internal
 import (
 	"fmt"
 	"github.com/briandowns/spinner"
+	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/kohkimakimoto/gptx/internal/builtin"
 	"github.com/sashabaranov/go-openai"
 	"github.com/urfave/cli/v2"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,6 +22,13 @@ type Repository struct {
 	ClientConfig openai.ClientConfig
 	CacheManager *CacheManager
 	StoreManager *StoreManager
+	IndexManager *IndexManager
+	// AuditKey is the key of the hash chain of the audit log.
+	// It is derived from the encryption secret if the database is encrypted, otherwise it is nil.
+	AuditKey []byte
+	// DisableAutoMigration disables migrating the database automatically.
+	// It is used by the commands that manage the database by themselves.
+	DisableAutoMigration bool
 	// The following parameters are used internally of this object.
 	inited bool
 	lock   sync.RWMutex
@@ -98,16 +107,40 @@ func (r *Repository) Init() error {
 		return err
 	}
 
-	// Load config values from environment variables
-	if v := os.Getenv("OPENAI_API_KEY"); v != "" && r.Config.OpenAIAPIKey == "" {
-		r.Config.OpenAIAPIKey = v
+	// Load config values from environment variables.
+	// OPENAI_API_KEY is not loaded here, because it is the last source of the API key checked by the APIKeyResolver.
+	if v := os.Getenv("OPENAI_BASE_URL"); v != "" && r.Config.BaseURL == "" {
+		r.Config.BaseURL = v
 	}
+	if v := os.Getenv("OPENAI_ORGANIZATION"); v != "" && r.Config.Organization == "" {
+		r.Config.Organization = v
+	}
+
+	r.ClientConfig = openai.DefaultConfig("")
+	if r.Config.BaseURL != "" {
+		r.ClientConfig.BaseURL = strings.TrimRight(r.Config.BaseURL, "/")
+	}
+	r.ClientConfig.OrgID = r.Config.Organization
+	httpClient, err := newHTTPClient(r.Config)
+	if err != nil {
+		return err
+	}
+	r.ClientConfig.HTTPClient = httpClient
 
-	r.ClientConfig = openai.DefaultConfig(r.Config.OpenAIAPIKey)
+	// the databases share the secret of the encryption at rest
+	var keyResolver *EncryptionKeyResolver
+	encrypt := false
+	if r.Config.Encryption != nil {
+		keyResolver = NewEncryptionKeyResolver(r.Config.Encryption)
+		encrypt = r.Config.Encryption.Enabled
+	}
 
 	// init store
 	r.StoreManager = &StoreManager{
-		DBPath: r.PathResolver.DBFilePath(),
+		DBPath:               r.PathResolver.DBFilePath(),
+		DisableAutoMigration: r.DisableAutoMigration,
+		KeyResolver:          keyResolver,
+		Encrypt:              encrypt,
 	}
 	store, err := r.StoreManager.Open()
 	if err != nil {
@@ -117,11 +150,49 @@ func (r *Repository) Init() error {
 	if err := store.Init(); err != nil {
 		return err
 	}
+	encrypted, err := store.IsEncrypted()
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		// the secret has been resolved to unlock the database
+		secret, err := keyResolver.Resolve()
+		if err != nil {
+			return err
+		}
+		r.AuditKey = deriveAuditKey(secret)
+	}
+	// prune the old conversations by the retention policy.
+	// it is skipped when the database may not be migrated yet.
+	if r.Config.Retention != nil && r.Config.Retention.MaxAgeDays > 0 && !r.DisableAutoMigration {
+		before := time.Now().Add(-time.Duration(r.Config.Retention.MaxAgeDays) * 24 * time.Hour)
+		if _, err := store.PruneConversations(before); err != nil {
+			return err
+		}
+	}
 
 	// init Cache
+	var maxCacheSize uint64
+	if r.Config.MaxCacheSize != "" {
+		maxCacheSize, err = humanize.ParseBytes(r.Config.MaxCacheSize)
+		if err != nil {
+			return fmt.Errorf("invalid max_cache_size: %w", err)
+		}
+	}
+	var cacheTTL time.Duration
+	if r.Config.CacheTTL != "" {
+		cacheTTL, err = parseDurationSpec(r.Config.CacheTTL)
+		if err != nil {
+			return fmt.Errorf("invalid cache_ttl: %w", err)
+		}
+	}
 	r.CacheManager = &CacheManager{
-		DBPath:    r.PathResolver.CacheDBFilePath(),
-		MaxLength: r.Config.MaxCacheLength,
+		DBPath:      r.PathResolver.CacheDBFilePath(),
+		MaxLength:   r.Config.MaxCacheLength,
+		MaxSize:     int64(maxCacheSize),
+		TTL:         cacheTTL,
+		KeyResolver: keyResolver,
+		Encrypt:     encrypt,
 	}
 	cache, err := r.CacheManager.Open()
 	if err != nil {
@@ -132,18 +203,75 @@ func (r *Repository) Init() error {
 		return err
 	}
 
+	// the indexes are opened when they are used
+	r.IndexManager = &IndexManager{
+		PathResolver: r.PathResolver,
+		KeyResolver:  keyResolver,
+		Encrypt:      encrypt,
+	}
+
 	r.inited = true
 
 	return nil
 }
 
-func (r *Repository) NewChatService(w io.Writer) (*ChatService, error) {
+// NewChatService creates a chat service that writes the answers to w, and the warnings and the verbose output to errW.
+func (r *Repository) NewChatService(w io.Writer, errW io.Writer) (*ChatService, error) {
 	c := &ChatService{}
 	c.PathResolver = r.PathResolver
 	c.ClientConfig = r.ClientConfig
 	c.StoreManager = r.StoreManager
 	c.CacheManager = r.CacheManager
 	c.HookFactory = &HookFactory{}
+	if r.Config.Title != nil {
+		c.AutoTitle = r.Config.Title.Auto
+		c.TitleModel = r.Config.Title.Model
+	}
+	c.CompactionKeep = defaultCompactionKeep
+	if r.Config.Compaction != nil {
+		c.CompactionThreshold = r.Config.Compaction.Threshold
+		c.CompactionKeep = r.Config.Compaction.Keep
+		c.CompactionModel = r.Config.Compaction.Model
+	}
+	if r.Config.CachePolicy != nil {
+		c.CachePolicy = NewCachePolicy(r.Config.CachePolicy)
+	}
+	embeddingConfig := r.Config.Embedding
+	if embeddingConfig == nil {
+		embeddingConfig = NewEmbeddingConfig()
+	}
+	c.Embedder = NewEmbedder(embeddingConfig, r.ClientConfig)
+	c.IndexManager = r.IndexManager
+	if r.Config.SemanticSearch != nil {
+		c.EmbedConversations = r.Config.SemanticSearch.Enabled
+	}
+	c.RAGTopK = defaultRAGTopK
+	if r.Config.RAG != nil {
+		c.RAGTopK = r.Config.RAG.TopK
+		c.RAGMinScore = r.Config.RAG.MinScore
+	}
+	if r.Config.SemanticCache != nil && r.Config.SemanticCache.Enabled {
+		if r.Config.SemanticCache.Threshold <= 0 || r.Config.SemanticCache.Threshold > 1 {
+			return nil, fmt.Errorf("invalid threshold of the semantic cache: %v (it must be greater than 0 and at most 1)", r.Config.SemanticCache.Threshold)
+		}
+		c.SemanticCache = &SemanticCache{Threshold: r.Config.SemanticCache.Threshold}
+	}
+	if r.Config.Redaction != nil && r.Config.Redaction.Enabled {
+		redactor, err := NewRedactor(r.Config.Redaction)
+		if err != nil {
+			return nil, err
+		}
+		c.Redactor = redactor
+		c.RestoreRedacted = r.Config.Redaction.Restore
+	}
+	c.ErrWriter = errW
+	if r.Config.Audit != nil && r.Config.Audit.Enabled {
+		c.AuditLog = &AuditLog{
+			Path:           r.PathResolver.AuditLogFilePath(),
+			IncludePrompts: r.Config.Audit.IncludePrompts,
+			Key:            r.AuditKey,
+		}
+	}
 	c.Writer = &OutputWriter{
 		Writer:         w,
 		UseAnimation:   isTerminal(w),
@@ -3,7 +3,12 @@ package internal
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/urfave/cli/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestRepositoryAwareAction(t *testing.T) {
@@ -31,7 +36,86 @@ func TestRepository_NewChatService(t *testing.T) {
 	app := testNewApp(t)
 	r, err := getRepository(app)
 	assert.NoError(t, err)
-	cs, err := r.NewChatService(app.Writer)
+	cs, err := r.NewChatService(app.Writer, app.ErrWriter)
 	assert.NoError(t, err)
 	assert.NotNil(t, cs)
+	// the warnings of the commands are written to the error writer of the app
+	assert.Equal(t, app.ErrWriter, cs.ErrWriter)
+}
+
+func TestRepository_Init(t *testing.T) {
+	t.Run("client config from environment variables", func(t *testing.T) {
+		t.Setenv("OPENAI_BASE_URL", "https://gateway.example.com/v1/")
+		t.Setenv("OPENAI_ORGANIZATION", "org-123")
+
+		app := testNewApp(t)
+		r, err := getRepository(app)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://gateway.example.com/v1", r.ClientConfig.BaseURL)
+		assert.Equal(t, "org-123", r.ClientConfig.OrgID)
+	})
+}
+
+func TestRepository_Init_APIKeyFileOverOpenAIAPIKeyEnv(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-default-env")
+	app := testNewApp(t)
+	r := app.Metadata["repository"].(*Repository)
+	keyFile := testTempFile(t, []byte("sk-file\n")).Name()
+	assert.NoError(t, os.MkdirAll(r.PathResolver.Dir, 0700))
+	err := os.WriteFile(r.PathResolver.ConfigFilePath(), []byte(`openai_api_key_file = "`+keyFile+`"`), 0600)
+	assert.NoError(t, err)
+
+	r, err = getRepository(app)
+	assert.NoError(t, err)
+	// the environment variable is not copied into the config, so that 'gptx config' does not print it
+	assert.Equal(t, "", r.Config.OpenAIAPIKey)
+
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	resp, err := r.ClientConfig.HTTPClient.Get(ts.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "Bearer sk-file", authorization)
+}
+
+func TestRepository_Init_DoesNotResolveAPIKey(t *testing.T) {
+	app := testNewApp(t)
+	r := app.Metadata["repository"].(*Repository)
+	marker := filepath.Join(r.PathResolver.Dir, "key-command-executed")
+	err := os.WriteFile(r.PathResolver.ConfigFilePath(), []byte(`openai_api_key_command = "touch `+marker+` && echo sk-command"`), 0600)
+	assert.NoError(t, err)
+
+	// commands that do not send any API requests never run the key command
+	err = app.Run([]string{"gptx", "list"})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, marker)
+}
+
+func TestRepository_Init_Retention(t *testing.T) {
+	r := testNewRepository(t)
+	err := os.WriteFile(r.PathResolver.ConfigFilePath(), []byte("[retention]\nmax_age_days = 30\n"), 0600)
+	assert.NoError(t, err)
+
+	// store conversations before the repository is initialized
+	sm := &StoreManager{DBPath: r.PathResolver.DBFilePath()}
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.Init())
+	old := NewConversation()
+	old.CreatedAt = time.Now().Add(-31 * 24 * time.Hour)
+	assert.NoError(t, s.ImportConversations([]*Conversation{old, NewConversation()}, false))
+	assert.NoError(t, sm.Close())
+
+	assert.NoError(t, r.Init())
+	defer r.Close()
+	s, err = r.StoreManager.Open()
+	assert.NoError(t, err)
+	l, err := s.ListConversations(&ListConversationsQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, l.Conversations, 1)
+	assert.Equal(t, uint64(2), l.Conversations[0].Id)
 }
@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+const (
+	// BucketMeta holds the metadata of the database such as the schema version.
+	BucketMeta = "meta"
+
+	metaKeySchemaVersion = "schema_version"
+)
+
+// Migration is a change of the database schema.
+// Migrations are applied in order, and the schema version of the database is the version of the last applied migration.
+type Migration struct {
+	Version     uint64
+	Description string
+	Migrate     func(tx *bolt.Tx) error
+}
+
+// Migrations is the list of all the migrations of the database schema.
+// NEVER change the existing migrations. Append a new migration to change the schema.
+var Migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "Encode the conversations in JSON instead of gob",
+		Migrate:     migrateGobToJSON,
+	},
+	{
+		Version:     2,
+		Description: "Rebuild the time indexes and the search index",
+		Migrate: func(tx *bolt.Tx) error {
+			if err := rebuildTimeIndexes(tx); err != nil {
+				return err
+			}
+			return rebuildSearchIndex(tx)
+		},
+	},
+	{
+		Version:     3,
+		Description: "Add the pinned index",
+		Migrate:     rebuildPinnedIndex,
+	},
+	{
+		Version:     4,
+		Description: "Add the tag index",
+		Migrate:     rebuildTagIndex,
+	},
+	{
+		Version:     5,
+		Description: "Add the bucket of the message embeddings for the semantic search",
+		Migrate: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(BucketMessageEmbeddings))
+			return err
+		},
+	},
+}
+
+// LatestSchemaVersion returns the schema version that this version of gptx uses.
+func LatestSchemaVersion() uint64 {
+	return Migrations[len(Migrations)-1].Version
+}
+
+type SchemaVersionTooNewError struct {
+	Version uint64
+}
+
+func (e *SchemaVersionTooNewError) Error() string {
+	return fmt.Sprintf("the database schema version %d is newer than the supported version %d. please upgrade gptx", e.Version, LatestSchemaVersion())
+}
+
+// encodeConversation encodes the conversation to store it in the database.
+// JSON is used as a stable on-disk encoding that does not depend on the Go struct definition.
+// If the database is encrypted, the encoded value is encrypted.
+func encodeConversation(tx *bolt.Tx, co *Conversation) ([]byte, error) {
+	buf, err := json.Marshal(co)
+	if err != nil {
+		return nil, err
+	}
+	return sealValue(txCipher(tx), buf)
+}
+
+// decodeConversation decodes the conversation stored in the database.
+func decodeConversation(tx *bolt.Tx, buf []byte, co *Conversation) error {
+	buf, err := openValue(txCipher(tx), buf)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, co)
+}
+
+func getSchemaVersion(tx *bolt.Tx) uint64 {
+	b := tx.Bucket([]byte(BucketMeta))
+	if b == nil {
+		return 0
+	}
+	v := b.Get([]byte(metaKeySchemaVersion))
+	if v == nil {
+		return 0
+	}
+	return btouint64(v)
+}
+
+func putSchemaVersion(tx *bolt.Tx, version uint64) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(BucketMeta))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(metaKeySchemaVersion), uint64tob(version))
+}
+
+func pendingMigrations(version uint64) []*Migration {
+	var pending []*Migration
+	for _, m := range Migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// SchemaVersion returns the schema version of the database.
+func (s *Store) SchemaVersion() (uint64, error) {
+	var version uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		version = getSchemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+// PendingMigrations returns the migrations that are not applied to the database yet.
+func (s *Store) PendingMigrations() ([]*Migration, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > LatestSchemaVersion() {
+		return nil, &SchemaVersionTooNewError{Version: version}
+	}
+	return pendingMigrations(version), nil
+}
+
+// Migrate applies the pending migrations in a transaction and returns the applied migrations.
+// If dryRun is true, the migrations are executed but the changes are rolled back.
+func (s *Store) Migrate(dryRun bool) ([]*Migration, error) {
+	var applied []*Migration
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		version := getSchemaVersion(tx)
+		if version > LatestSchemaVersion() {
+			return &SchemaVersionTooNewError{Version: version}
+		}
+		for _, m := range pendingMigrations(version) {
+			if err := m.Migrate(tx); err != nil {
+				return fmt.Errorf("failed to migrate the database to version %d: %w", m.Version, err)
+			}
+			if err := putSchemaVersion(tx, m.Version); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// BackupPath returns a path of the backup file that is created before migrating the database.
+func (s *Store) BackupPath(version uint64) string {
+	return fmt.Sprintf("%s.v%d-%s.bak", s.db.Path(), version, time.Now().Format("20060102150405"))
+}
+
+// migrateGobToJSON re-encodes the conversations stored by older versions with encoding/gob in JSON.
+func migrateGobToJSON(tx *bolt.Tx) error {
+	bc := tx.Bucket([]byte(BucketConversations))
+	if bc == nil {
+		return nil
+	}
+
+	type record struct {
+		key   []byte
+		value []byte
+	}
+	var records []record
+	if err := bc.ForEach(func(k, v []byte) error {
+		co := NewConversation()
+		if err := deserialize(v, co); err != nil {
+			return fmt.Errorf("failed to decode the conversation %d: %w", btouint64(k), err)
+		}
+		buf, err := encodeConversation(tx, co)
+		if err != nil {
+			return err
+		}
+		// keys and values are only valid during the iteration, so they are copied
+		records = append(records, record{key: append([]byte{}, k...), value: buf})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// the bucket must not be modified during the iteration
+	for _, r := range records {
+		if err := bc.Put(r.key, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
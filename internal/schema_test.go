@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+// testCreateLegacyDB creates a database in the format of the versions before the schema versioning.
+func testCreateLegacyDB(t *testing.T, path string) {
+	t.Helper()
+	db, err := bolt.Open(path, 0600, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bc, err := tx.CreateBucket([]byte(BucketConversations))
+		if err != nil {
+			return err
+		}
+		bni, err := tx.CreateBucket([]byte(BucketNameIndex))
+		if err != nil {
+			return err
+		}
+		co := NewConversation()
+		co.Id, _ = bc.NextSequence()
+		co.Name = "legacy"
+		co.Prompt = "What is the capital city of Japan?"
+		co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: co.Prompt})
+		co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Tokyo."})
+		buf, err := serialize(co)
+		if err != nil {
+			return err
+		}
+		if err := bni.Put([]byte(co.Name), uint64tob(co.Id)); err != nil {
+			return err
+		}
+		return bc.Put(uint64tob(co.Id), buf)
+	})
+	assert.NoError(t, err)
+}
+
+func TestStore_Init_Migration(t *testing.T) {
+	t.Run("new database", func(t *testing.T) {
+		sm := testStoreManager(t)
+		s, err := sm.Open()
+		assert.NoError(t, err)
+		version, err := s.SchemaVersion()
+		assert.NoError(t, err)
+		assert.Equal(t, LatestSchemaVersion(), version)
+	})
+
+	t.Run("legacy database", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "gptx.db")
+		testCreateLegacyDB(t, path)
+
+		sm := &StoreManager{DBPath: path}
+		defer sm.Close()
+		s, err := sm.Open()
+		assert.NoError(t, err)
+		assert.NoError(t, s.Init())
+
+		version, err := s.SchemaVersion()
+		assert.NoError(t, err)
+		assert.Equal(t, LatestSchemaVersion(), version)
+
+		co, err := s.GetConversationByName("legacy")
+		assert.NoError(t, err)
+		assert.Equal(t, "Tokyo.", co.Messages[1].Content)
+
+		results, err := s.SearchConversations(&SearchQuery{Query: "tokyo"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		backups, err := filepath.Glob(filepath.Join(dir, "gptx.db.v0-*.bak"))
+		assert.NoError(t, err)
+		assert.Len(t, backups, 1)
+	})
+
+	t.Run("disable auto migration", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gptx.db")
+		testCreateLegacyDB(t, path)
+
+		sm := &StoreManager{DBPath: path, DisableAutoMigration: true}
+		defer sm.Close()
+		s, err := sm.Open()
+		assert.NoError(t, err)
+		assert.NoError(t, s.Init())
+
+		pending, err := s.PendingMigrations()
+		assert.NoError(t, err)
+		assert.Len(t, pending, len(Migrations))
+
+		applied, err := s.Migrate(true)
+		assert.NoError(t, err)
+		assert.Len(t, applied, len(Migrations))
+		version, err := s.SchemaVersion()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), version)
+	})
+
+	t.Run("too new database", func(t *testing.T) {
+		sm := testStoreManager(t)
+		s, err := sm.Open()
+		assert.NoError(t, err)
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			return putSchemaVersion(tx, LatestSchemaVersion()+1)
+		})
+		assert.NoError(t, err)
+
+		err = s.Init()
+		assert.Error(t, err)
+		assert.IsType(t, &SchemaVersionTooNewError{}, err)
+	})
+}
+
+func TestEncodeAndDecodeConversation(t *testing.T) {
+	s, err := testStoreManager(t).Open()
+	assert.NoError(t, err)
+	defer s.Close()
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		co := testExportConversation()
+		buf, err := encodeConversation(tx, co)
+		assert.NoError(t, err)
+		assert.True(t, json.Valid(buf))
+
+		ret := NewConversation()
+		assert.NoError(t, decodeConversation(tx, buf, ret))
+		assert.Equal(t, co.Messages, ret.Messages)
+		assert.Equal(t, co.Name, ret.Name)
+		assert.True(t, co.CreatedAt.Equal(ret.CreatedAt))
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,453 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/sashabaranov/go-openai"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// BucketSearchIndex is an inverted index that maps a term to the conversations containing it.
+	// Each term has a nested bucket whose keys are conversation ids and values are term counts per role.
+	BucketSearchIndex = "search_index"
+	// BucketSearchDocs maps a conversation id to the terms indexed for it.
+	// It is used to remove the old postings when a conversation is updated or deleted.
+	BucketSearchDocs = "search_docs"
+)
+
+// SearchRolePrompt is a pseudo role that represents the initial prompt of a conversation.
+const SearchRolePrompt = "prompt"
+
+const (
+	minTermLength = 2
+	maxTermLength = 64
+)
+
+// tokenize splits the text into lower-cased terms.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		n := utf8.RuneCountInString(f)
+		if n < minTermLength || n > maxTermLength {
+			continue
+		}
+		terms = append(terms, f)
+	}
+	return terms
+}
+
+// conversationTerms returns the term counts per role of the conversation.
+func conversationTerms(co *Conversation) map[string]map[string]int {
+	postings := map[string]map[string]int{}
+	add := func(role string, text string) {
+		for _, term := range tokenize(text) {
+			if postings[term] == nil {
+				postings[term] = map[string]int{}
+			}
+			postings[term][role]++
+		}
+	}
+	add(SearchRolePrompt, co.Prompt)
+	for _, m := range co.Messages {
+		add(m.Role, m.Content)
+	}
+	return postings
+}
+
+// indexConversation updates the search index for the conversation in the transaction.
+func indexConversation(tx *bolt.Tx, co *Conversation) error {
+	if err := unindexConversation(tx, co.Id); err != nil {
+		return err
+	}
+
+	bi := tx.Bucket([]byte(BucketSearchIndex))
+	vc := txCipher(tx)
+	id := uint64tob(co.Id)
+	postings := conversationTerms(co)
+	terms := make([]string, 0, len(postings))
+	for term, roles := range postings {
+		tb, err := bi.CreateBucketIfNotExists(searchTermKey(vc, term))
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(roles)
+		if err != nil {
+			return err
+		}
+		if err := tb.Put(id, buf); err != nil {
+			return err
+		}
+		terms = append(terms, term)
+	}
+
+	buf, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	// the terms are the contents of the conversation, so they are encrypted if the database is encrypted
+	buf, err = sealValue(vc, buf)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(BucketSearchDocs)).Put(id, buf)
+}
+
+// searchTermKey returns the key of the term in the search index.
+// If the database is encrypted, the key is a keyed hash of the term.
+func searchTermKey(vc *ValueCipher, term string) []byte {
+	if vc == nil {
+		return []byte(term)
+	}
+	return vc.IndexKey(term)
+}
+
+// unindexConversation removes the conversation from the search index in the transaction.
+func unindexConversation(tx *bolt.Tx, id uint64) error {
+	bd := tx.Bucket([]byte(BucketSearchDocs))
+	buf := bd.Get(uint64tob(id))
+	if buf == nil {
+		return nil
+	}
+	vc := txCipher(tx)
+	buf, err := openValue(vc, buf)
+	if err != nil {
+		return err
+	}
+	var terms []string
+	if err := json.Unmarshal(buf, &terms); err != nil {
+		return err
+	}
+
+	bi := tx.Bucket([]byte(BucketSearchIndex))
+	for _, term := range terms {
+		key := searchTermKey(vc, term)
+		tb := bi.Bucket(key)
+		if tb == nil {
+			continue
+		}
+		if err := tb.Delete(uint64tob(id)); err != nil {
+			return err
+		}
+		if k, _ := tb.Cursor().First(); k == nil {
+			// remove the empty term bucket
+			if err := bi.DeleteBucket(key); err != nil {
+				return err
+			}
+		}
+	}
+	return bd.Delete(uint64tob(id))
+}
+
+// rebuildSearchIndex drops and rebuilds the whole search index in the transaction.
+func rebuildSearchIndex(tx *bolt.Tx) error {
+	for _, name := range []string{BucketSearchIndex, BucketSearchDocs} {
+		if tx.Bucket([]byte(name)) != nil {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket([]byte(BucketConversations)).ForEach(func(k, v []byte) error {
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			return err
+		}
+		return indexConversation(tx, co)
+	})
+}
+
+// RebuildSearchIndex rebuilds the search index from all the stored conversations.
+func (s *Store) RebuildSearchIndex() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return rebuildSearchIndex(tx)
+	})
+}
+
+type SearchQuery struct {
+	Query    string   // Search terms. All terms must match. A term that ends with "*" matches as a prefix.
+	Roles    []string // If specified, only these roles are searched. The initial prompt is represented as the "prompt" role.
+	Label    string
+	Tags     []string          // The conversations must have all the tags
+	Metadata map[string]string // The conversations must have all the key/value pairs
+	Limit    int
+}
+
+type SearchResult struct {
+	Conversation *Conversation  `json:"-"`
+	Id           uint64         `json:"id"`
+	Name         string         `json:"name,omitempty"`
+	Label        string         `json:"label,omitempty"`
+	Prompt       string         `json:"prompt"`
+	Score        float64        `json:"score"`
+	Matches      []*SearchMatch `json:"matches"`
+}
+
+type SearchMatch struct {
+	Role       string   `json:"role"`
+	Index      int      `json:"index"` // The message index. It is -1 for the prompt.
+	Snippet    string   `json:"snippet"`
+	Highlights [][2]int `json:"highlights"`      // Byte offsets of the matched terms in the snippet.
+	Score      float64  `json:"score,omitempty"` // The similarity of the message to the query in the semantic search.
+}
+
+const maxSearchMatchesPerConversation = 3
+
+type queryTerm struct {
+	value  string
+	prefix bool
+}
+
+func parseSearchQuery(q string) []queryTerm {
+	var terms []queryTerm
+	for _, f := range strings.Fields(q) {
+		prefix := strings.HasSuffix(f, "*")
+		for _, t := range tokenize(f) {
+			terms = append(terms, queryTerm{value: t, prefix: prefix})
+		}
+	}
+	return terms
+}
+
+// SearchConversations searches the conversations with the full-text search index.
+// The results are ranked by a TF-IDF score.
+func (s *Store) SearchConversations(query *SearchQuery) ([]*SearchResult, error) {
+	terms := parseSearchQuery(query.Query)
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+
+	roles := map[string]bool{}
+	for _, r := range query.Roles {
+		roles[r] = true
+	}
+	acceptRole := func(role string) bool {
+		return len(roles) == 0 || roles[role]
+	}
+
+	var results []*SearchResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bc := tx.Bucket([]byte(BucketConversations))
+		bi := tx.Bucket([]byte(BucketSearchIndex))
+		total := float64(bc.Stats().KeyN)
+
+		// scores holds the scores of the candidate conversations that match all terms so far.
+		var scores map[uint64]float64
+		for _, term := range terms {
+			tfs := map[uint64]int{}
+			if err := forEachTermBucket(bi, txCipher(tx), term, func(tb *bolt.Bucket) error {
+				return tb.ForEach(func(k, v []byte) error {
+					counts := map[string]int{}
+					if err := json.Unmarshal(v, &counts); err != nil {
+						return err
+					}
+					for role, n := range counts {
+						if acceptRole(role) {
+							tfs[btouint64(k)] += n
+						}
+					}
+					return nil
+				})
+			}); err != nil {
+				return err
+			}
+
+			idf := math.Log(1 + total/float64(len(tfs)+1))
+			next := map[uint64]float64{}
+			for id, tf := range tfs {
+				if tf == 0 {
+					continue
+				}
+				if scores != nil {
+					if _, ok := scores[id]; !ok {
+						continue
+					}
+				}
+				next[id] = scores[id] + (1+math.Log(float64(tf)))*idf
+			}
+			scores = next
+			if len(scores) == 0 {
+				break
+			}
+		}
+
+		re := searchTermsRegexp(terms)
+		filter := &ListConversationsQuery{Label: query.Label, Tags: query.Tags, Metadata: query.Metadata}
+		for id, score := range scores {
+			buf := bc.Get(uint64tob(id))
+			if buf == nil {
+				continue
+			}
+			co := NewConversation()
+			if err := decodeConversation(tx, buf, co); err != nil {
+				return err
+			}
+			if !filter.Match(co) {
+				continue
+			}
+			results = append(results, &SearchResult{
+				Conversation: co,
+				Id:           co.Id,
+				Name:         co.Name,
+				Label:        co.Label,
+				Prompt:       co.Prompt,
+				Score:        math.Round(score*1000) / 1000,
+				Matches:      findSearchMatches(co, re, acceptRole),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id > results[j].Id
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	if results == nil {
+		results = []*SearchResult{}
+	}
+	return results, nil
+}
+
+// forEachTermBucket calls the function with the buckets of the terms that match the query term.
+// If the database is encrypted, prefix terms match only the exact terms because the keys are hashed.
+func forEachTermBucket(bi *bolt.Bucket, vc *ValueCipher, term queryTerm, fn func(tb *bolt.Bucket) error) error {
+	if !term.prefix || vc != nil {
+		if tb := bi.Bucket(searchTermKey(vc, term.value)); tb != nil {
+			return fn(tb)
+		}
+		return nil
+	}
+
+	c := bi.Cursor()
+	prefix := []byte(term.value)
+	for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), term.value); k, _ = c.Next() {
+		if tb := bi.Bucket(k); tb != nil {
+			if err := fn(tb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func searchTermsRegexp(terms []queryTerm) *regexp.Regexp {
+	quoted := make([]string, 0, len(terms))
+	for _, t := range terms {
+		quoted = append(quoted, regexp.QuoteMeta(t.value))
+	}
+	// longer terms first so that the longest match is highlighted
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+func findSearchMatches(co *Conversation, re *regexp.Regexp, acceptRole func(string) bool) []*SearchMatch {
+	matches := []*SearchMatch{}
+	try := func(role string, index int, text string) {
+		if len(matches) >= maxSearchMatchesPerConversation || !acceptRole(role) {
+			return
+		}
+		if m := newSearchMatch(role, index, text, re); m != nil {
+			matches = append(matches, m)
+		}
+	}
+	try(SearchRolePrompt, -1, co.Prompt)
+	for i, m := range co.Messages {
+		if i == 0 && m.Role == openai.ChatMessageRoleUser && m.Content == co.Prompt && len(matches) > 0 {
+			// the first user message is usually the same as the prompt
+			continue
+		}
+		try(m.Role, i, m.Content)
+	}
+	return matches
+}
+
+const (
+	snippetBefore = 30
+	snippetLength = 120
+)
+
+func newSearchMatch(role string, index int, text string, re *regexp.Regexp) *SearchMatch {
+	loc := re.FindStringIndex(text)
+	if loc == nil {
+		return nil
+	}
+
+	snippet := searchSnippet(text, loc[0])
+	highlights := [][2]int{}
+	for _, l := range re.FindAllStringIndex(snippet, -1) {
+		highlights = append(highlights, [2]int{l[0], l[1]})
+	}
+	return &SearchMatch{
+		Role:       role,
+		Index:      index,
+		Snippet:    snippet,
+		Highlights: highlights,
+	}
+}
+
+// searchSnippet returns a single line excerpt of the text around the byte offset.
+func searchSnippet(text string, pos int) string {
+	start := pos - snippetBefore
+	if start < 0 {
+		start = 0
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	end := start + snippetLength
+	if end > len(text) {
+		end = len(text)
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	snippet := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, text[start:end])
+	prefix := ""
+	if start > 0 {
+		prefix = "..."
+	}
+	suffix := ""
+	if end < len(text) {
+		suffix = "..."
+	}
+	return prefix + snippet + suffix
+}
+
+// highlightSnippet decorates the highlighted parts of the snippet by the function.
+func highlightSnippet(m *SearchMatch, decorate func(string) string) string {
+	b := &strings.Builder{}
+	last := 0
+	for _, h := range m.Highlights {
+		b.WriteString(m.Snippet[last:h[0]])
+		b.WriteString(decorate(m.Snippet[h[0]:h[1]]))
+		last = h[1]
+	}
+	b.WriteString(m.Snippet[last:])
+	return b.String()
+}
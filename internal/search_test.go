@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"fixed", "the", "nginx", "conf", "file"}, tokenize("Fixed the nginx.conf file!"))
+	assert.Equal(t, []string{"日本語"}, tokenize("a 日本語"))
+}
+
+func testCreateSearchConversations(t *testing.T, s *Store) {
+	t.Helper()
+	for _, c := range []struct {
+		prompt string
+		answer string
+		label  string
+	}{
+		{"How do I configure nginx as a reverse proxy?", "Use the proxy_pass directive in the nginx config.", "web"},
+		{"What is the capital city of Japan?", "Tokyo is the capital city of Japan.", ""},
+		{"My nginx config returns 502", "Check the nginx upstream. Fixed nginx config should look like this.", "web"},
+	} {
+		co := NewConversation()
+		co.Prompt = c.prompt
+		co.Label = c.label
+		co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: c.prompt})
+		co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: c.answer})
+		assert.NoError(t, s.CreateConversation(co))
+	}
+}
+
+func TestStore_SearchConversations(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+
+	t.Run("ranked results", func(t *testing.T) {
+		results, err := s.SearchConversations(&SearchQuery{Query: "nginx config"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		// the conversation 3 mentions nginx more often
+		assert.Equal(t, uint64(3), results[0].Id)
+		assert.Equal(t, uint64(1), results[1].Id)
+		assert.Greater(t, results[0].Score, results[1].Score)
+		assert.Equal(t, SearchRolePrompt, results[0].Matches[0].Role)
+		assert.Equal(t, "My nginx config returns 502", results[0].Matches[0].Snippet)
+		assert.Equal(t, [][2]int{{3, 8}, {9, 15}}, results[0].Matches[0].Highlights)
+	})
+
+	t.Run("all terms must match", func(t *testing.T) {
+		results, err := s.SearchConversations(&SearchQuery{Query: "nginx tokyo"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 0)
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		results, err := s.SearchConversations(&SearchQuery{Query: "tok*"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, uint64(2), results[0].Id)
+	})
+
+	t.Run("role filter", func(t *testing.T) {
+		results, err := s.SearchConversations(&SearchQuery{Query: "upstream", Roles: []string{"user"}})
+		assert.NoError(t, err)
+		assert.Len(t, results, 0)
+
+		results, err = s.SearchConversations(&SearchQuery{Query: "upstream", Roles: []string{"assistant"}})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, "assistant", results[0].Matches[0].Role)
+		assert.Equal(t, 1, results[0].Matches[0].Index)
+	})
+
+	t.Run("label and limit", func(t *testing.T) {
+		results, err := s.SearchConversations(&SearchQuery{Query: "nginx", Label: "web", Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+	})
+
+	t.Run("updated and deleted conversations", func(t *testing.T) {
+		co, err := s.GetConversationById(2)
+		assert.NoError(t, err)
+		co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "And what about Kyoto?"})
+		assert.NoError(t, s.UpdateConversation(co))
+
+		results, err := s.SearchConversations(&SearchQuery{Query: "kyoto"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		assert.NoError(t, s.DeleteConversationById(2))
+		results, err = s.SearchConversations(&SearchQuery{Query: "kyoto"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 0)
+	})
+}
+
+func TestStore_RebuildSearchIndex(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+
+	assert.NoError(t, s.RebuildSearchIndex())
+	results, err := s.SearchConversations(&SearchQuery{Query: "nginx"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	m := &SearchMatch{Snippet: "My nginx config", Highlights: [][2]int{{3, 8}}}
+	assert.Equal(t, "My [nginx] config", highlightSnippet(m, func(s string) string { return "[" + s + "]" }))
+}
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v2"
+	"strings"
+)
+
+var SearchCommand = &cli.Command{
+	Name:      "search",
+	Usage:     "Search conversations by keywords or by meaning",
+	ArgsUsage: "[query...]",
+	Description: `Search the prompts and messages of the stored conversations.
+All the terms in the query must match. A term that ends with "*" matches as a prefix (e.g. "nginx*").
+
+With --semantic, the messages are ranked by the similarity of their embeddings to the embedding of the query,
+so that the messages with the same meaning match without the same words. --similar finds the conversations
+related to a conversation. The messages that have not been embedded yet are embedded before searching.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:               "semantic",
+			Aliases:            []string{"s"},
+			Usage:              "Search the messages similar in meaning to the query",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:  "similar",
+			Usage: "Search the conversations similar to the `conversation`. You can specify a conversation id or name",
+		},
+		&cli.Float64Flag{
+			Name:  "min-score",
+			Usage: "The minimum similarity of the messages in the semantic search",
+		},
+		&cli.StringSliceFlag{
+			Name:  "role",
+			Usage: "Search only the messages with the `role` (prompt, user, assistant or system)",
+		},
+		&cli.StringFlag{
+			Name:    "label",
+			Aliases: []string{"l"},
+			Usage:   "Filter the conversations by `label`",
+		},
+		&cli.StringSliceFlag{
+			Name:    "tag",
+			Aliases: []string{"t"},
+			Usage:   "Filter the conversations by `tag`. It can be specified multiple times",
+		},
+		&cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "Filter the conversations by metadata (`key=value`). It can be specified multiple times",
+		},
+		&cli.IntFlag{
+			Name:    "limit",
+			Aliases: []string{"L"},
+			Usage:   "Limit the `number` of displayed conversations",
+			Value:   20,
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Specify an output `format` (table or json)",
+			Value:   "table",
+		},
+		&cli.BoolFlag{
+			Name:               "pretty",
+			Aliases:            []string{"p"},
+			Usage:              "Pretty print (only for json format)",
+			DisableDefaultText: true,
+		},
+	},
+	Action: searchAction,
+}
+
+var searchHighlightColor = color.New(color.FgYellow, color.Bold)
+
+var searchAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	q := strings.Join(c.Args().Slice(), " ")
+	similar := c.String("similar")
+	if similar != "" && strings.TrimSpace(q) != "" {
+		return errors.New("a query can not be used with --similar")
+	}
+	if similar == "" && strings.TrimSpace(q) == "" {
+		return errors.New("missing query")
+	}
+
+	for _, role := range c.StringSlice("role") {
+		switch role {
+		case SearchRolePrompt, "user", "assistant", "system":
+		default:
+			return fmt.Errorf("invalid role: %s", role)
+		}
+	}
+
+	metadata, err := parseMetadataFlags(c.StringSlice("meta"))
+	if err != nil {
+		return err
+	}
+
+	var results []*SearchResult
+	if c.Bool("semantic") || similar != "" {
+		results, err = semanticSearch(c, r, &SemanticSearchQuery{
+			Roles:    c.StringSlice("role"),
+			Label:    c.String("label"),
+			Tags:     c.StringSlice("tag"),
+			Metadata: metadata,
+			MinScore: c.Float64("min-score"),
+			Limit:    c.Int("limit"),
+		}, q, similar)
+	} else {
+		results, err = keywordSearch(r, &SearchQuery{
+			Query:    q,
+			Roles:    c.StringSlice("role"),
+			Label:    c.String("label"),
+			Tags:     c.StringSlice("tag"),
+			Metadata: metadata,
+			Limit:    c.Int("limit"),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	switch format := c.String("format"); format {
+	case "json":
+		var buf []byte
+		if c.Bool("pretty") {
+			buf, err = json.MarshalIndent(results, "", "  ")
+		} else {
+			buf, err = json.Marshal(results)
+		}
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.App.Writer, string(buf))
+	case "table":
+		t := NewSimpleTableWriter(c.App.Writer)
+		t.AppendHeader(table.Row{
+			"ID",
+			"SCORE",
+			"NAME",
+			"LABEL",
+			"ROLE",
+			"MATCH",
+		})
+		for _, result := range results {
+			for i, m := range result.Matches {
+				row := table.Row{"", "", "", "", m.Role, highlightSnippet(m, func(s string) string { return searchHighlightColor.Sprint(s) })}
+				if i == 0 {
+					row[0] = result.Id
+					row[1] = fmt.Sprintf("%.3f", result.Score)
+					row[2] = result.Name
+					row[3] = result.Label
+				}
+				t.AppendRow(row)
+			}
+		}
+		t.Render()
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+	return nil
+})
+
+func keywordSearch(r *Repository, query *SearchQuery) ([]*SearchResult, error) {
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	return store.SearchConversations(query)
+}
+
+// semanticSearch embeds the pending messages, and searches the messages similar to the query or the conversation.
+func semanticSearch(c *cli.Context, r *Repository, query *SemanticSearchQuery, q string, similar string) ([]*SearchResult, error) {
+	sv, err := r.NewChatService(c.App.Writer, c.App.ErrWriter)
+	if err != nil {
+		return nil, err
+	}
+	sv.ErrWriter = c.App.ErrWriter
+	ctx := context.Background()
+
+	n, err := sv.EmbedMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		_, _ = fmt.Fprintf(c.App.ErrWriter, "Embedded %d new message(s)\n", n)
+	}
+
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	query.Model = sv.Embedder.Name()
+	if similar != "" {
+		co, err := store.GetConversationByKey(NewConversationKey(similar))
+		if err != nil {
+			return nil, err
+		}
+		vector, err := store.ConversationVector(co.Id, query.Model)
+		if err != nil {
+			return nil, err
+		}
+		if vector == nil {
+			return nil, fmt.Errorf("conversation %d has no message to compare", co.Id)
+		}
+		query.Vector = vector
+		query.Exclude = co.Id
+	} else {
+		vector, err := sv.embed(ctx, sv.redactText(q))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute the embedding of the query: %w", err)
+		}
+		query.Vector = vector
+	}
+	return store.SemanticSearchConversations(query)
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// defaultSemanticCacheThreshold is the default minimum similarity of the semantic cache.
+const defaultSemanticCacheThreshold = 0.95
+
+// SemanticCache is the settings of the semantic cache that returns the cached responses of the prompts
+// similar to the prompt of the request. The embeddings are computed by the Embedder of the ChatService.
+type SemanticCache struct {
+	Threshold float64 // The minimum cosine similarity of the prompts
+}
+
+// cacheEmbedding is the value of the embeddings bucket.
+type cacheEmbedding struct {
+	Model   string    `json:"model"`   // The name of the embedder
+	Context string    `json:"context"` // The hash of the request without the prompt. Only the requests with the same context are compared
+	Vector  []float32 `json:"vector"`
+}
+
+// semanticCacheQuery is the prompt of a request to look up in the semantic cache.
+type semanticCacheQuery struct {
+	Model   string
+	Context string
+	Vector  []float32
+}
+
+// SimilarCacheHit is a cached response returned by the semantic cache.
+type SimilarCacheHit struct {
+	Item       *CacheItem
+	Value      []byte
+	Similarity float64
+}
+
+// semanticCacheContext returns the prompt of the request and the hash of the request without the prompt.
+// The prompt is the last user message. It returns an empty prompt if the request has no user message.
+func semanticCacheContext(req openai.ChatCompletionRequest) (string, string, error) {
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	copy(messages, req.Messages)
+	prompt := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			prompt = messages[i].Content
+			messages[i].Content = ""
+			break
+		}
+	}
+	req.Messages = messages
+	hash, err := requestHash(req)
+	if err != nil {
+		return "", "", err
+	}
+	return prompt, hex.EncodeToString(hash), nil
+}
+
+// SetEmbedding stores the embedding of the prompt of the cached response of the key.
+// It does nothing if the response is not cached.
+func (c *Cache) SetEmbedding(key []byte, q *semanticCacheQuery) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(CacheBucketCaches)).Get(key) == nil {
+			return nil
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(CacheBucketEmbeddings))
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(&cacheEmbedding{Model: q.Model, Context: q.Context, Vector: q.Vector})
+		if err != nil {
+			return err
+		}
+		buf, err = sealValue(txCipher(tx), buf)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, buf)
+	})
+}
+
+// LookupSimilar returns the cached response whose prompt is the most similar to the query.
+// The similarity must be at least the threshold. The expired responses are ignored.
+// A found response is recorded as a semantic hit. It returns nil if no response is found.
+func (c *Cache) LookupSimilar(q *semanticCacheQuery, threshold float64, maxAge time.Duration) (*SimilarCacheHit, error) {
+	var hit *SimilarCacheHit
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(CacheBucketEmbeddings))
+		if b == nil {
+			return nil
+		}
+		caches := tx.Bucket([]byte(CacheBucketCaches))
+		now := time.Now()
+		var bestKey []byte
+		var bestItem *CacheItem
+		best := threshold
+		if err := b.ForEach(func(key, buf []byte) error {
+			buf, err := openValue(txCipher(tx), buf)
+			if err != nil {
+				return err
+			}
+			e := &cacheEmbedding{}
+			if err := json.Unmarshal(buf, e); err != nil {
+				return err
+			}
+			if e.Model != q.Model || e.Context != q.Context {
+				return nil
+			}
+			similarity := cosineSimilarity(q.Vector, e.Vector)
+			if similarity < best {
+				return nil
+			}
+			value := caches.Get(key)
+			if value == nil {
+				return nil
+			}
+			item, err := getCacheItem(tx, key, value)
+			if err != nil {
+				return err
+			}
+			if item.Expired(now, maxAge) {
+				return nil
+			}
+			best = similarity
+			bestKey = append([]byte{}, key...)
+			bestItem = item
+			return nil
+		}); err != nil {
+			return err
+		}
+		if bestKey == nil {
+			return nil
+		}
+
+		v, err := openValue(txCipher(tx), caches.Get(bestKey))
+		if err != nil {
+			return err
+		}
+		hit = &SimilarCacheHit{Item: bestItem, Value: append([]byte{}, v...), Similarity: best}
+		if err := touchCacheKey(tx, bestKey); err != nil {
+			return err
+		}
+		bestItem.Hits++
+		bestItem.LastHitAt = &now
+		if err := putCacheItem(tx, tx.Bucket([]byte(CacheBucketItems)), bestKey, bestItem); err != nil {
+			return err
+		}
+		return incrementCacheStat(tx, cacheStatsKeySemanticHits)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hit, nil
+}
+
+// newSemanticCacheQuery computes the embedding of the prompt of the request to look up in the semantic cache.
+// The semantic cache is best effort, so it returns nil if the request has no prompt or the embedding fails.
+func (c *ChatService) newSemanticCacheQuery(ctx context.Context, req openai.ChatCompletionRequest) *semanticCacheQuery {
+	if c.SemanticCache == nil || c.Embedder == nil {
+		return nil
+	}
+	prompt, reqContext, err := semanticCacheContext(req)
+	if err != nil || prompt == "" {
+		return nil
+	}
+	vector, err := c.embed(ctx, prompt)
+	if err != nil {
+		if c.ErrWriter != nil {
+			_, _ = fmt.Fprintf(c.ErrWriter, "warning: failed to compute the embedding for the semantic cache: %v\n", err)
+		}
+		return nil
+	}
+	return &semanticCacheQuery{Model: c.Embedder.Name(), Context: reqContext, Vector: vector}
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSemanticCacheContext(t *testing.T) {
+	req := func(prompt string) openai.ChatCompletionRequest {
+		return openai.ChatCompletionRequest{
+			Model: "test-model",
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: "system"},
+				{Role: openai.ChatMessageRoleUser, Content: prompt},
+			},
+		}
+	}
+
+	prompt1, context1, err := semanticCacheContext(req("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", prompt1)
+	prompt2, context2, err := semanticCacheContext(req("Hello!"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello!", prompt2)
+	assert.Equal(t, context1, context2)
+
+	// the original request is not modified
+	r := req("hello")
+	_, _, _ = semanticCacheContext(r)
+	assert.Equal(t, "hello", r.Messages[1].Content)
+
+	r.Model = "other-model"
+	_, context3, err := semanticCacheContext(r)
+	assert.NoError(t, err)
+	assert.NotEqual(t, context1, context3)
+
+	prompt, _, err := semanticCacheContext(openai.ChatCompletionRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", prompt)
+}
+
+func TestCache_LookupSimilar(t *testing.T) {
+	cm := testCacheManager(t)
+	c, err := cm.Open()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key1 := sha256.Sum256([]byte("key-1"))
+	key2 := sha256.Sum256([]byte("key-2"))
+	assert.NoError(t, c.Set(key1[:], []byte("value-1")))
+	assert.NoError(t, c.Set(key2[:], []byte("value-2")))
+	assert.NoError(t, c.SetEmbedding(key1[:], &semanticCacheQuery{Model: "m", Context: "ctx", Vector: []float32{1, 0}}))
+	assert.NoError(t, c.SetEmbedding(key2[:], &semanticCacheQuery{Model: "m", Context: "ctx", Vector: []float32{0.6, 0.8}}))
+
+	hit, err := c.LookupSimilar(&semanticCacheQuery{Model: "m", Context: "ctx", Vector: []float32{0.8, 0.6}}, 0.9, 0)
+	assert.NoError(t, err)
+	assert.NotNil(t, hit)
+	assert.Equal(t, []byte("value-2"), hit.Value)
+	assert.InDelta(t, 0.96, hit.Similarity, 1e-6)
+	assert.Equal(t, uint64(1), hit.Item.Hits)
+
+	// below the threshold
+	hit, err = c.LookupSimilar(&semanticCacheQuery{Model: "m", Context: "ctx", Vector: []float32{0, 1}}, 0.9, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+
+	// the other context or model
+	hit, err = c.LookupSimilar(&semanticCacheQuery{Model: "m", Context: "other", Vector: []float32{1, 0}}, 0.9, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+	hit, err = c.LookupSimilar(&semanticCacheQuery{Model: "other", Context: "ctx", Vector: []float32{1, 0}}, 0.9, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+
+	// the embedding is removed with the response
+	assert.NoError(t, c.Delete(key2[:]))
+	hit, err = c.LookupSimilar(&semanticCacheQuery{Model: "m", Context: "ctx", Vector: []float32{0.6, 0.8}}, 0.9, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), stats.SemanticHits)
+}
@@ -0,0 +1,475 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// BucketMessageEmbeddings holds the embeddings of the messages for the semantic search.
+// Each conversation has a nested bucket whose keys are the message indexes and values are messageEmbedding.
+const BucketMessageEmbeddings = "message_embeddings"
+
+// messageEmbedding is the embedding of a message.
+type messageEmbedding struct {
+	Model  string    `json:"model"` // The name of the embedder
+	Hash   string    `json:"hash"`  // The hash of the message. The embedding is stale if the message is changed
+	Vector []float32 `json:"vector"`
+}
+
+// PendingMessage is a message whose embedding has not been computed yet.
+type PendingMessage struct {
+	ConversationId uint64
+	Index          int
+	Hash           string
+	Text           string
+}
+
+// SemanticSearchQuery is a query of the semantic search.
+type SemanticSearchQuery struct {
+	Vector   []float32 // The embedding of the query
+	Model    string    // The name of the embedder of the query. Only the embeddings of the same model are compared
+	Exclude  uint64    // The id of a conversation excluded from the results
+	Roles    []string  // If specified, only the messages with these roles are searched
+	Label    string
+	Tags     []string          // The conversations must have all the tags
+	Metadata map[string]string // The conversations must have all the key/value pairs
+	MinScore float64           // The minimum similarity of the messages
+	Limit    int
+}
+
+// messageEmbeddingHash returns the hash of the message that identifies its embedding.
+func messageEmbeddingHash(m openai.ChatCompletionMessage) string {
+	sum := sha256.Sum256([]byte(m.Role + "\n" + m.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// isEmbeddableMessage reports whether the message is embedded for the semantic search.
+// The system messages such as the summaries of the compaction are not embedded.
+func isEmbeddableMessage(m openai.ChatCompletionMessage) bool {
+	return (m.Role == openai.ChatMessageRoleUser || m.Role == openai.ChatMessageRoleAssistant) && strings.TrimSpace(m.Content) != ""
+}
+
+func getMessageEmbedding(tx *bolt.Tx, v []byte) (*messageEmbedding, error) {
+	buf, err := openValue(txCipher(tx), v)
+	if err != nil {
+		return nil, err
+	}
+	e := &messageEmbedding{}
+	if err := json.Unmarshal(buf, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// pruneMessageEmbeddings removes the embeddings of the messages of the conversation that were changed or removed in the transaction.
+func pruneMessageEmbeddings(tx *bolt.Tx, co *Conversation) error {
+	be := tx.Bucket([]byte(BucketMessageEmbeddings))
+	if be == nil {
+		return nil
+	}
+	cb := be.Bucket(uint64tob(co.Id))
+	if cb == nil {
+		return nil
+	}
+	var stale [][]byte
+	if err := cb.ForEach(func(k, v []byte) error {
+		i := int(btouint64(k))
+		if i < len(co.Messages) {
+			e, err := getMessageEmbedding(tx, v)
+			if err != nil {
+				return err
+			}
+			if e.Hash == messageEmbeddingHash(co.Messages[i]) {
+				return nil
+			}
+		}
+		stale = append(stale, append([]byte{}, k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := cb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMessageEmbeddings removes the embeddings of the messages of the conversation in the transaction.
+func deleteMessageEmbeddings(tx *bolt.Tx, id uint64) error {
+	be := tx.Bucket([]byte(BucketMessageEmbeddings))
+	if be == nil {
+		return nil
+	}
+	if err := be.DeleteBucket(uint64tob(id)); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
+
+// PendingMessageEmbeddings returns the messages whose embeddings of the model have not been computed yet.
+// If ids are specified, only the messages of these conversations are returned.
+func (s *Store) PendingMessageEmbeddings(model string, ids ...uint64) ([]*PendingMessage, error) {
+	var pending []*PendingMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bc := tx.Bucket([]byte(BucketConversations))
+		be := tx.Bucket([]byte(BucketMessageEmbeddings))
+		check := func(k, v []byte) error {
+			co := NewConversation()
+			if err := decodeConversation(tx, v, co); err != nil {
+				return err
+			}
+			var cb *bolt.Bucket
+			if be != nil {
+				cb = be.Bucket(k)
+			}
+			for i, m := range co.Messages {
+				if !isEmbeddableMessage(m) {
+					continue
+				}
+				hash := messageEmbeddingHash(m)
+				if cb != nil {
+					if v := cb.Get(uint64tob(uint64(i))); v != nil {
+						e, err := getMessageEmbedding(tx, v)
+						if err != nil {
+							return err
+						}
+						if e.Model == model && e.Hash == hash {
+							continue
+						}
+					}
+				}
+				pending = append(pending, &PendingMessage{ConversationId: co.Id, Index: i, Hash: hash, Text: m.Content})
+			}
+			return nil
+		}
+
+		if len(ids) == 0 {
+			return bc.ForEach(check)
+		}
+		for _, id := range ids {
+			k := uint64tob(id)
+			v := bc.Get(k)
+			if v == nil {
+				continue
+			}
+			if err := check(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// PutMessageEmbedding stores the embedding of the message.
+// It does nothing if the conversation was removed or the message was changed after it was returned as pending.
+func (s *Store) PutMessageEmbedding(model string, m *PendingMessage, vector []float32) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(BucketConversations)).Get(uint64tob(m.ConversationId))
+		if v == nil {
+			return nil
+		}
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			return err
+		}
+		if m.Index >= len(co.Messages) || messageEmbeddingHash(co.Messages[m.Index]) != m.Hash {
+			return nil
+		}
+
+		be, err := tx.CreateBucketIfNotExists([]byte(BucketMessageEmbeddings))
+		if err != nil {
+			return err
+		}
+		cb, err := be.CreateBucketIfNotExists(uint64tob(m.ConversationId))
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(&messageEmbedding{Model: model, Hash: m.Hash, Vector: vector})
+		if err != nil {
+			return err
+		}
+		buf, err = sealValue(txCipher(tx), buf)
+		if err != nil {
+			return err
+		}
+		return cb.Put(uint64tob(uint64(m.Index)), buf)
+	})
+}
+
+// ConversationVector returns the mean of the embeddings of the messages of the conversation.
+// It returns nil if no message of the conversation has an embedding of the model.
+func (s *Store) ConversationVector(id uint64, model string) ([]float32, error) {
+	var mean []float64
+	n := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		be := tx.Bucket([]byte(BucketMessageEmbeddings))
+		if be == nil {
+			return nil
+		}
+		cb := be.Bucket(uint64tob(id))
+		if cb == nil {
+			return nil
+		}
+		return cb.ForEach(func(_, v []byte) error {
+			e, err := getMessageEmbedding(tx, v)
+			if err != nil {
+				return err
+			}
+			if e.Model != model || (mean != nil && len(e.Vector) != len(mean)) {
+				return nil
+			}
+			if mean == nil {
+				mean = make([]float64, len(e.Vector))
+			}
+			// the vectors are normalized so that each message has the same weight
+			norm := math.Sqrt(float64(dot32(e.Vector, e.Vector)))
+			if norm == 0 {
+				return nil
+			}
+			for i, x := range e.Vector {
+				mean[i] += float64(x) / norm
+			}
+			n++
+			return nil
+		})
+	})
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	v := make([]float32, len(mean))
+	for i, x := range mean {
+		v[i] = float32(x / float64(n))
+	}
+	return v, nil
+}
+
+func dot32(a, b []float32) float32 {
+	var d float32
+	for i := range a {
+		d += a[i] * b[i]
+	}
+	return d
+}
+
+// SemanticSearchConversations returns the conversations whose messages are the most similar to the query vector.
+// The score of a conversation is the highest similarity of its messages.
+func (s *Store) SemanticSearchConversations(query *SemanticSearchQuery) ([]*SearchResult, error) {
+	roles := map[string]bool{}
+	for _, r := range query.Roles {
+		roles[r] = true
+	}
+
+	var results []*SearchResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		be := tx.Bucket([]byte(BucketMessageEmbeddings))
+		if be == nil {
+			return nil
+		}
+		bc := tx.Bucket([]byte(BucketConversations))
+		filter := &ListConversationsQuery{Label: query.Label, Tags: query.Tags, Metadata: query.Metadata}
+		return be.ForEach(func(k, _ []byte) error {
+			id := btouint64(k)
+			if id == query.Exclude {
+				return nil
+			}
+			buf := bc.Get(k)
+			if buf == nil {
+				return nil
+			}
+			co := NewConversation()
+			if err := decodeConversation(tx, buf, co); err != nil {
+				return err
+			}
+			if !filter.Match(co) {
+				return nil
+			}
+
+			var matches []*SearchMatch
+			if err := be.Bucket(k).ForEach(func(ik, v []byte) error {
+				i := int(btouint64(ik))
+				if i >= len(co.Messages) {
+					return nil
+				}
+				m := co.Messages[i]
+				if len(roles) > 0 && !roles[m.Role] {
+					return nil
+				}
+				e, err := getMessageEmbedding(tx, v)
+				if err != nil {
+					return err
+				}
+				if e.Model != query.Model {
+					return nil
+				}
+				score := cosineSimilarity(query.Vector, e.Vector)
+				if score < query.MinScore {
+					return nil
+				}
+				matches = append(matches, &SearchMatch{
+					Role:       m.Role,
+					Index:      i,
+					Snippet:    searchSnippet(m.Content, 0),
+					Highlights: [][2]int{},
+					Score:      math.Round(score*1000) / 1000,
+				})
+				return nil
+			}); err != nil {
+				return err
+			}
+			if len(matches) == 0 {
+				return nil
+			}
+
+			sort.SliceStable(matches, func(i, j int) bool {
+				return matches[i].Score > matches[j].Score
+			})
+			if len(matches) > maxSearchMatchesPerConversation {
+				matches = matches[:maxSearchMatchesPerConversation]
+			}
+			results = append(results, &SearchResult{
+				Conversation: co,
+				Id:           co.Id,
+				Name:         co.Name,
+				Label:        co.Label,
+				Prompt:       co.Prompt,
+				Score:        matches[0].Score,
+				Matches:      matches,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id > results[j].Id
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	if results == nil {
+		results = []*SearchResult{}
+	}
+	return results, nil
+}
+
+// reencryptMessageEmbeddings re-encrypts the embeddings of the messages in the nested buckets.
+func reencryptMessageEmbeddings(tx *bolt.Tx, old *ValueCipher) error {
+	be := tx.Bucket([]byte(BucketMessageEmbeddings))
+	if be == nil {
+		return nil
+	}
+	var ids [][]byte
+	if err := be.ForEach(func(k, _ []byte) error {
+		ids = append(ids, append([]byte{}, k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		cb := be.Bucket(id)
+		values := map[string][]byte{}
+		if err := cb.ForEach(func(k, v []byte) error {
+			plain, err := openValue(old, v)
+			if err != nil {
+				return err
+			}
+			sealed, err := sealValue(txCipher(tx), plain)
+			if err != nil {
+				return err
+			}
+			values[string(k)] = sealed
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, v := range values {
+			if err := cb.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EmbedMessages computes and stores the embeddings of the messages that have not been embedded yet.
+// If ids are specified, only the messages of these conversations are embedded. It returns the number of the embedded messages.
+func (c *ChatService) EmbedMessages(ctx context.Context, ids ...uint64) (int, error) {
+	store, err := c.StoreManager.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	model := c.Embedder.Name()
+	pending, err := store.PendingMessageEmbeddings(model, ids...)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range pending {
+		// the stored messages are not redacted, so they are redacted before they are sent to the embedding API
+		vector, err := c.embed(ctx, c.redactText(m.Text))
+		if err != nil {
+			return n, fmt.Errorf("failed to compute the embedding of the message %d of the conversation %d: %w", m.Index, m.ConversationId, err)
+		}
+		if err := store.PutMessageEmbedding(model, m, vector); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// autoEmbedMessages embeds the new messages of the conversation if the semantic search is enabled.
+// It is best effort, so the messages that failed to be embedded are embedded by the next semantic search.
+func (c *ChatService) autoEmbedMessages() {
+	if !c.EmbedConversations || c.OnMemory || c.Embedder == nil || c.Conversation.IsNew() {
+		return
+	}
+	if _, err := c.EmbedMessages(context.Background(), c.Conversation.Id); err != nil && c.ErrWriter != nil {
+		_, _ = fmt.Fprintf(c.ErrWriter, "warning: failed to embed the messages for the semantic search: %v\n", err)
+	}
+}
+
+// pruneOrphanMessageEmbeddings removes the embeddings of the conversations that do not exist in the transaction.
+func pruneOrphanMessageEmbeddings(tx *bolt.Tx) error {
+	be := tx.Bucket([]byte(BucketMessageEmbeddings))
+	if be == nil {
+		return nil
+	}
+	bc := tx.Bucket([]byte(BucketConversations))
+	var orphans [][]byte
+	if err := be.ForEach(func(k, _ []byte) error {
+		if bc.Get(k) == nil {
+			orphans = append(orphans, append([]byte{}, k...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range orphans {
+		if err := be.DeleteBucket(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+	"testing"
+)
+
+// testEmbedMessages stores the embeddings of the pending messages computed by the mock server.
+func testEmbedMessages(t *testing.T, s *Store) int {
+	t.Helper()
+	pending, err := s.PendingMessageEmbeddings("mock")
+	assert.NoError(t, err)
+	for _, m := range pending {
+		assert.NoError(t, s.PutMessageEmbedding("mock", m, mockserver.Embed(m.Text)))
+	}
+	return len(pending)
+}
+
+func TestStore_MessageEmbeddings(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.Init())
+	testCreateSearchConversations(t, s)
+
+	// the user and assistant messages of the 3 conversations
+	assert.Equal(t, 6, testEmbedMessages(t, s))
+	assert.Equal(t, 0, testEmbedMessages(t, s))
+
+	// the messages embedded with another model are pending
+	pending, err := s.PendingMessageEmbeddings("other", 2)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, uint64(2), pending[0].ConversationId)
+
+	// the embeddings of the changed messages are removed
+	co, err := s.GetConversationById(1)
+	assert.NoError(t, err)
+	co.Messages[1].Content = "Use the upstream directive."
+	co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "summary"})
+	assert.NoError(t, s.UpdateConversation(co))
+	pending, err = s.PendingMessageEmbeddings("mock")
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Index)
+	assert.Equal(t, "Use the upstream directive.", pending[0].Text)
+
+	// the embedding of a message changed after it was returned as pending is not stored
+	co.Messages[1].Content = "Use proxy_pass."
+	assert.NoError(t, s.UpdateConversation(co))
+	assert.NoError(t, s.PutMessageEmbedding("mock", pending[0], mockserver.Embed(pending[0].Text)))
+	pending, err = s.PendingMessageEmbeddings("mock")
+	assert.NoError(t, err)
+	assert.Equal(t, "Use proxy_pass.", pending[0].Text)
+
+	// the embeddings are removed with the conversation
+	assert.NoError(t, s.DeleteConversationById(2))
+	assert.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte(BucketMessageEmbeddings)).Bucket(uint64tob(2)))
+		return nil
+	}))
+}
+
+func TestStore_SemanticSearchConversations(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.Init())
+	testCreateSearchConversations(t, s)
+	testEmbedMessages(t, s)
+
+	t.Run("ranked results", func(t *testing.T) {
+		results, err := s.SemanticSearchConversations(&SemanticSearchQuery{
+			Vector: mockserver.Embed("nginx returns 502"),
+			Model:  "mock",
+		})
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+		assert.Equal(t, uint64(3), results[0].Id)
+		assert.Equal(t, "My nginx config returns 502", results[0].Matches[0].Snippet)
+		assert.Equal(t, results[0].Matches[0].Score, results[0].Score)
+		assert.GreaterOrEqual(t, results[0].Matches[0].Score, results[0].Matches[1].Score)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		results, err := s.SemanticSearchConversations(&SemanticSearchQuery{
+			Vector:   mockserver.Embed("nginx returns 502"),
+			Model:    "mock",
+			Roles:    []string{"assistant"},
+			Label:    "web",
+			MinScore: 0.1,
+			Exclude:  3,
+		})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, uint64(1), results[0].Id)
+		assert.Len(t, results[0].Matches, 1)
+		assert.Equal(t, "assistant", results[0].Matches[0].Role)
+
+		// the embeddings of another model are not compared
+		results, err = s.SemanticSearchConversations(&SemanticSearchQuery{Vector: mockserver.Embed("nginx"), Model: "other"})
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+
+	t.Run("similar conversations", func(t *testing.T) {
+		vector, err := s.ConversationVector(1, "mock")
+		assert.NoError(t, err)
+		results, err := s.SemanticSearchConversations(&SemanticSearchQuery{Vector: vector, Model: "mock", Exclude: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), results[0].Id)
+
+		vector, err = s.ConversationVector(1, "other")
+		assert.NoError(t, err)
+		assert.Nil(t, vector)
+	})
+}
+
+func TestStore_MessageEmbeddingsEncryption(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.Init())
+	testCreateSearchConversations(t, s)
+	testEmbedMessages(t, s)
+	assert.NoError(t, sm.Close())
+
+	t.Setenv("GPTX_PASSPHRASE", "secret")
+	sm.KeyResolver = NewEncryptionKeyResolver(&EncryptionConfig{})
+	sm.Encrypt = true
+	s, err = sm.Open()
+	assert.NoError(t, err)
+	assert.NoError(t, s.Init())
+	defer sm.Close()
+
+	assert.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+		assert.True(t, isSealedValue(tx.Bucket([]byte(BucketMessageEmbeddings)).Bucket(uint64tob(1)).Get(uint64tob(0))))
+		return nil
+	}))
+	results, err := s.SemanticSearchConversations(&SemanticSearchQuery{Vector: mockserver.Embed("capital of japan"), Model: "mock"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), results[0].Id)
+}
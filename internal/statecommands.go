@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"errors"
+	"github.com/urfave/cli/v2"
+)
+
+var undoStateFlag = &cli.BoolFlag{
+	Name:               "undo",
+	Aliases:            []string{"u"},
+	Usage:              "Clear the state instead of setting it",
+	DisableDefaultText: true,
+}
+
+var ArchiveCommand = &cli.Command{
+	Name:        "archive",
+	Usage:       "Archive one or more conversations",
+	ArgsUsage:   `[conversation...]`,
+	Description: "Archived conversations are hidden from 'gptx list' by default. Use --undo to unarchive them.",
+	Flags: []cli.Flag{
+		undoStateFlag,
+	},
+	Action: archiveAction,
+}
+
+var archiveAction = conversationStateAction(func(co *Conversation, v bool) {
+	co.Archived = v
+})
+
+var PinCommand = &cli.Command{
+	Name:        "pin",
+	Usage:       "Pin one or more conversations",
+	ArgsUsage:   `[conversation...]`,
+	Description: "Pinned conversations are listed first by 'gptx list' and never removed by the retention policy. Use --undo to unpin them.",
+	Flags: []cli.Flag{
+		undoStateFlag,
+	},
+	Action: pinAction,
+}
+
+var pinAction = conversationStateAction(func(co *Conversation, v bool) {
+	co.Pinned = v
+})
+
+var StarCommand = &cli.Command{
+	Name:        "star",
+	Usage:       "Star one or more conversations",
+	ArgsUsage:   `[conversation...]`,
+	Description: "Starred conversations can be listed by 'gptx list --starred'. Use --undo to unstar them.",
+	Flags: []cli.Flag{
+		undoStateFlag,
+	},
+	Action: starAction,
+}
+
+var starAction = conversationStateAction(func(co *Conversation, v bool) {
+	co.Starred = v
+})
+
+// conversationStateAction returns an action that sets or clears a state of the conversations specified by the arguments.
+func conversationStateAction(set func(co *Conversation, v bool)) func(c *cli.Context) error {
+	return repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+		if c.NArg() == 0 {
+			return errors.New("missing conversation argument(s)")
+		}
+
+		store, err := r.StoreManager.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		for _, key := range c.Args().Slice() {
+			co, err := store.GetConversationByKey(NewConversationKey(key))
+			if err != nil {
+				return err
+			}
+			set(co, !c.Bool("undo"))
+			if err := store.UpdateConversationState(co); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
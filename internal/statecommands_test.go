@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStateCommands(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+	s, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+	before, err := s.GetConversationById(1)
+	assert.NoError(t, err)
+
+	listIds := func(t *testing.T, args ...string) []uint64 {
+		t.Helper()
+		app.Writer = &bytes.Buffer{}
+		err := app.Run(append([]string{"gptx", "list", "--format", "json"}, args...))
+		assert.NoError(t, err)
+		list := &ConversationList{}
+		assert.NoError(t, json.Unmarshal(app.Writer.(*bytes.Buffer).Bytes(), list))
+		ids := []uint64{}
+		for _, co := range list.Conversations {
+			ids = append(ids, co.Id)
+		}
+		return ids
+	}
+
+	t.Run("missing argument", func(t *testing.T) {
+		err := app.Run([]string{"gptx", "pin"})
+		assert.Error(t, err)
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		assert.NoError(t, app.Run([]string{"gptx", "archive", "1"}))
+		assert.Equal(t, []uint64{2, 3}, listIds(t))
+		assert.Equal(t, []uint64{1}, listIds(t, "--archived"))
+		assert.Equal(t, []uint64{1, 2, 3}, listIds(t, "--all"))
+
+		assert.NoError(t, app.Run([]string{"gptx", "archive", "--undo", "1"}))
+		assert.Equal(t, []uint64{1, 2, 3}, listIds(t))
+	})
+
+	t.Run("pin", func(t *testing.T) {
+		assert.NoError(t, app.Run([]string{"gptx", "pin", "3"}))
+		assert.Equal(t, []uint64{3, 1, 2}, listIds(t))
+		assert.Equal(t, []uint64{3, 1}, listIds(t, "--limit", "2"))
+		assert.Equal(t, []uint64{3, 2, 1}, listIds(t, "--reverse"))
+	})
+
+	t.Run("star", func(t *testing.T) {
+		assert.NoError(t, app.Run([]string{"gptx", "star", "1", "2"}))
+		assert.Equal(t, []uint64{1, 2}, listIds(t, "--starred"))
+		assert.NoError(t, app.Run([]string{"gptx", "star", "-u", "2"}))
+		assert.Equal(t, []uint64{1}, listIds(t, "--starred"))
+	})
+
+	t.Run("state does not change the updated time", func(t *testing.T) {
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		after, err := s.GetConversationById(1)
+		assert.NoError(t, err)
+		assert.True(t, after.Starred)
+		assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt))
+	})
+}
@@ -1,10 +1,13 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	bolt "go.etcd.io/bbolt"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,8 +15,17 @@ import (
 const (
 	BucketConversations = "conversations"
 	BucketNameIndex     = "names"
+	// BucketCreatedIndex and BucketUpdatedIndex are secondary indexes to list conversations in time order.
+	// Their keys are the concatenation of the time (unix nano) and the conversation id.
+	BucketCreatedIndex = "created_index"
+	BucketUpdatedIndex = "updated_index"
+	// BucketPinnedIndex holds the ids of the pinned conversations.
+	BucketPinnedIndex = "pinned_index"
 )
 
+// errDryRun is returned from a transaction to roll back the changes in a dry run.
+var errDryRun = errors.New("dry run")
+
 type ConversationNotFoundError struct {
 	Key interface{}
 }
@@ -41,8 +53,15 @@ func (e *ConversationInvalidNameError) Error() string {
 
 type StoreManager struct {
 	DBPath string
-	store  *Store
-	lock   sync.RWMutex
+	// DisableAutoMigration disables migrating the database when the store is initialized.
+	DisableAutoMigration bool
+	// KeyResolver resolves the secret to open the encrypted database.
+	KeyResolver *EncryptionKeyResolver
+	// Encrypt encrypts the database when the store is initialized if it is not encrypted yet.
+	Encrypt bool
+	store   *Store
+	cipher  *ValueCipher
+	lock    sync.RWMutex
 }
 
 func (m *StoreManager) Open() (*Store, error) {
@@ -65,6 +84,7 @@ func (m *StoreManager) Open() (*Store, error) {
 		m:  m,
 		db: db,
 	}
+	registerValueCipher(db, m.cipher)
 
 	return m.store, nil
 }
@@ -95,6 +115,7 @@ func (s *Store) Close() error {
 		// already closed
 		return nil
 	}
+	registerValueCipher(s.db, nil)
 	err := s.db.Close()
 	if err != nil {
 		return err
@@ -105,38 +126,158 @@ func (s *Store) Close() error {
 }
 
 func (s *Store) Init() error {
+	fresh := false
 	err := s.db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte(BucketConversations)); err != nil {
-			return err
+		fresh = tx.Bucket([]byte(BucketConversations)) == nil
+		for _, name := range []string{BucketConversations, BucketNameIndex, BucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
 		}
-		if _, err := tx.CreateBucketIfNotExists([]byte(BucketNameIndex)); err != nil {
-			return err
+		if fresh {
+			// a new database does not need any migrations
+			for _, name := range []string{BucketCreatedIndex, BucketUpdatedIndex, BucketPinnedIndex, BucketTagIndex, BucketSearchIndex, BucketSearchDocs, BucketMessageEmbeddings} {
+				if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+					return err
+				}
+			}
+			return putSchemaVersion(tx, LatestSchemaVersion())
 		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
+
+	// the encrypted database must be unlocked before the migrations decode the conversations
+	c, err := unlockDB(s.db, s.m.KeyResolver)
+	if err != nil {
+		return err
+	}
+	s.setCipher(c)
+
+	if !fresh {
+		if err := s.autoMigrate(); err != nil {
+			return err
+		}
+	}
+
+	if s.m.Encrypt && c == nil {
+		return s.encrypt()
+	}
 	return nil
 }
 
+func (s *Store) autoMigrate() error {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 || s.m.DisableAutoMigration {
+		return nil
+	}
+
+	// backup the database before migrating it
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if err := s.Backup(s.BackupPath(version)); err != nil {
+		return err
+	}
+	_, err = s.Migrate(false)
+	return err
+}
+
 func (s *Store) CreateConversation(co *Conversation) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bc := tx.Bucket([]byte(BucketConversations))
-		id, _ := bc.NextSequence()
-		co.Id = id
 		co.CreatedAt = time.Now().UTC()
-		buf, err := serialize(co)
-		if err != nil {
+		co.UpdatedAt = co.CreatedAt
+		return createConversation(tx, co)
+	})
+}
+
+// createConversation stores the conversation with a new id in the transaction.
+// The timestamps of the conversation are stored as they are.
+func createConversation(tx *bolt.Tx, co *Conversation) error {
+	bc := tx.Bucket([]byte(BucketConversations))
+	id, _ := bc.NextSequence()
+	co.Id = id
+	buf, err := encodeConversation(tx, co)
+	if err != nil {
+		return err
+	}
+
+	// update name index
+	if co.Name != "" {
+		if err := checkValidConversationName(co.Name); err != nil {
 			return err
 		}
+		bni := tx.Bucket([]byte(BucketNameIndex))
+		// check if name is already used
+		if v := bni.Get([]byte(co.Name)); v != nil {
+			return &ConversationNameDuplicatedError{Name: co.Name, Id: btouint64(v)}
+		}
+		if err := bni.Put([]byte(co.Name), uint64tob(co.Id)); err != nil {
+			return err
+		}
+	}
+
+	if err := putTimeIndexes(tx, co); err != nil {
+		return err
+	}
+	if err := putPinnedIndex(tx, co); err != nil {
+		return err
+	}
+	if err := putTagIndex(tx, nil, co); err != nil {
+		return err
+	}
+
+	if err := indexConversation(tx, co); err != nil {
+		return err
+	}
+
+	return bc.Put(uint64tob(co.Id), buf)
+}
+
+func (s *Store) UpdateConversation(co *Conversation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateConversation(tx, co, true)
+	})
+}
+
+// UpdateConversationState updates the conversation without changing its last updated time.
+// It is used to change the state such as archived, pinned and starred that is not a part of the conversation content.
+func (s *Store) UpdateConversationState(co *Conversation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateConversation(tx, co, false)
+	})
+}
 
-		// update name index
+func updateConversation(tx *bolt.Tx, co *Conversation, touch bool) error {
+	bc := tx.Bucket([]byte(BucketConversations))
+	buf := bc.Get(uint64tob(co.Id))
+	if buf == nil {
+		return &ConversationNotFoundError{Key: co.Id}
+	}
+
+	old := NewConversation()
+	if err := decodeConversation(tx, buf, old); err != nil {
+		return err
+	}
+
+	// update name index
+	if old.Name != co.Name {
+		bni := tx.Bucket([]byte(BucketNameIndex))
+		if old.Name != "" {
+			if err := bni.Delete([]byte(old.Name)); err != nil {
+				return err
+			}
+		}
 		if co.Name != "" {
 			if err := checkValidConversationName(co.Name); err != nil {
 				return err
 			}
-			bni := tx.Bucket([]byte(BucketNameIndex))
 			// check if name is already used
 			if v := bni.Get([]byte(co.Name)); v != nil {
 				return &ConversationNameDuplicatedError{Name: co.Name, Id: btouint64(v)}
@@ -145,72 +286,117 @@ func (s *Store) CreateConversation(co *Conversation) error {
 				return err
 			}
 		}
+	}
 
-		return bc.Put(uint64tob(co.Id), buf)
-	})
+	if touch {
+		co.UpdatedAt = time.Now().UTC()
+	}
+	if err := deleteTimeIndexes(tx, old); err != nil {
+		return err
+	}
+	if err := putTimeIndexes(tx, co); err != nil {
+		return err
+	}
+	if err := putPinnedIndex(tx, co); err != nil {
+		return err
+	}
+	if err := putTagIndex(tx, old.Tags, co); err != nil {
+		return err
+	}
+
+	if err := indexConversation(tx, co); err != nil {
+		return err
+	}
+	if err := pruneMessageEmbeddings(tx, co); err != nil {
+		return err
+	}
+
+	buf, err := encodeConversation(tx, co)
+	if err != nil {
+		return err
+	}
+	return bc.Put(uint64tob(co.Id), buf)
 }
 
-func (s *Store) UpdateConversation(co *Conversation) error {
+func (s *Store) DeleteConversationById(id uint64) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bc := tx.Bucket([]byte(BucketConversations))
-		buf := bc.Get(uint64tob(co.Id))
-		if buf == nil {
-			return &ConversationNotFoundError{Key: co.Id}
-		}
+		return deleteConversation(tx, id)
+	})
+}
 
-		old := NewConversation()
-		if err := deserialize(buf, old); err != nil {
-			return err
+// DeleteConversations deletes the conversations in a transaction.
+func (s *Store) DeleteConversations(ids []uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := deleteConversation(tx, id); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+}
 
-		// update name index
-		if old.Name != co.Name {
-			bni := tx.Bucket([]byte(BucketNameIndex))
-			if old.Name != "" {
-				if err := bni.Delete([]byte(old.Name)); err != nil {
-					return err
-				}
-			}
-			if co.Name != "" {
-				if err := checkValidConversationName(co.Name); err != nil {
-					return err
-				}
-				// check if name is already used
-				if v := bni.Get([]byte(co.Name)); v != nil {
-					return &ConversationNameDuplicatedError{Name: co.Name, Id: btouint64(v)}
-				}
-				if err := bni.Put([]byte(co.Name), uint64tob(co.Id)); err != nil {
-					return err
-				}
+// PruneConversations deletes the conversations that have not been updated since the time,
+// and returns the number of the deleted conversations. The pinned conversations are not deleted.
+func (s *Store) PruneConversations(before time.Time) (int, error) {
+	n := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// the updated time index is sorted by the time, so the iteration can stop at the first newer conversation
+		var ids []uint64
+		bpi := tx.Bucket([]byte(BucketPinnedIndex))
+		c := tx.Bucket([]byte(BucketUpdatedIndex)).Cursor()
+		limit := uint64tob(uint64(before.UnixNano()))
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], limit) < 0; k, _ = c.Next() {
+			if bpi.Get(k[8:]) != nil {
+				// pinned conversations are never pruned
+				continue
 			}
+			ids = append(ids, btouint64(k[8:]))
 		}
-
-		buf, err := serialize(co)
-		if err != nil {
-			return err
+		for _, id := range ids {
+			if err := deleteConversation(tx, id); err != nil {
+				return err
+			}
 		}
-		return bc.Put(uint64tob(co.Id), buf)
+		n = len(ids)
+		return nil
 	})
+	return n, err
 }
 
-func (s *Store) DeleteConversationById(id uint64) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		co := NewConversation()
-		buf := tx.Bucket([]byte(BucketConversations)).Get(uint64tob(id))
-		if buf == nil {
-			return &ConversationNotFoundError{Key: id}
-		}
-		if err := deserialize(buf, co); err != nil {
+func deleteConversation(tx *bolt.Tx, id uint64) error {
+	co := NewConversation()
+	buf := tx.Bucket([]byte(BucketConversations)).Get(uint64tob(id))
+	if buf == nil {
+		return &ConversationNotFoundError{Key: id}
+	}
+	if err := decodeConversation(tx, buf, co); err != nil {
+		return err
+	}
+	if co.Name != "" {
+		if err := tx.Bucket([]byte(BucketNameIndex)).Delete([]byte(co.Name)); err != nil {
 			return err
 		}
-		if co.Name != "" {
-			if err := tx.Bucket([]byte(BucketNameIndex)).Delete([]byte(co.Name)); err != nil {
-				return err
-			}
-		}
+	}
 
-		return tx.Bucket([]byte(BucketConversations)).Delete(uint64tob(id))
-	})
+	if err := deleteTimeIndexes(tx, co); err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(BucketPinnedIndex)).Delete(uint64tob(id)); err != nil {
+		return err
+	}
+	if err := deleteTagIndex(tx, co); err != nil {
+		return err
+	}
+
+	if err := unindexConversation(tx, id); err != nil {
+		return err
+	}
+	if err := deleteMessageEmbeddings(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(BucketConversations)).Delete(uint64tob(id))
 }
 
 func (s *Store) GetConversationById(id uint64) (*Conversation, error) {
@@ -220,7 +406,7 @@ func (s *Store) GetConversationById(id uint64) (*Conversation, error) {
 		if buf == nil {
 			return &ConversationNotFoundError{Key: id}
 		}
-		return deserialize(buf, co)
+		return decodeConversation(tx, buf, co)
 	})
 	if err != nil {
 		return nil, err
@@ -239,7 +425,7 @@ func (s *Store) GetConversationByName(name string) (*Conversation, error) {
 		if buf == nil {
 			return &ConversationNotFoundError{Key: name}
 		}
-		return deserialize(buf, co)
+		return decodeConversation(tx, buf, co)
 	})
 	if err != nil {
 		return nil, err
@@ -266,6 +452,110 @@ func (s *Store) RenameConversationByKey(key *ConversationKey, name string) error
 }
 
 func (s *Store) ListConversations(query *ListConversationsQuery) (*ConversationList, error) {
+	if query.PinnedFirst {
+		return s.listConversationsPinnedFirst(query)
+	}
+	return s.listConversations(query)
+}
+
+// listConversationsPinnedFirst lists the pinned conversations before the others.
+// The pinned conversations are only listed on the first page, and the other pages list only the conversations that are not pinned.
+func (s *Store) listConversationsPinnedFirst(query *ListConversationsQuery) (*ConversationList, error) {
+	pinned := []*Conversation{}
+	if query.Begin == nil {
+		_pinned, err := s.listPinnedConversations(query)
+		if err != nil {
+			return nil, err
+		}
+		pinned = _pinned
+	}
+
+	notPinned := false
+	q := *query
+	q.PinnedFirst = false
+	q.Pinned = &notPinned
+	if q.Limit > 0 {
+		if len(pinned) >= q.Limit {
+			// the first page is filled with the pinned conversations, so the next page begins with the first conversation that is not pinned
+			q.Limit = 1
+			l, err := s.listConversations(&q)
+			if err != nil {
+				return nil, err
+			}
+			ret := &ConversationList{
+				query:         query,
+				Conversations: pinned[:query.Limit],
+				HasNext:       len(l.Conversations) > 0,
+				Count:         query.Limit,
+			}
+			if ret.HasNext && (query.Sort == "" || query.Sort == SortById) {
+				next := l.Conversations[0].Id
+				ret.Next = &next
+			}
+			return ret, nil
+		}
+		q.Limit -= len(pinned)
+	}
+
+	l, err := s.listConversations(&q)
+	if err != nil {
+		return nil, err
+	}
+	l.query = query
+	l.Conversations = append(pinned, l.Conversations...)
+	l.Count = len(l.Conversations)
+	return l, nil
+}
+
+func (s *Store) listPinnedConversations(query *ListConversationsQuery) ([]*Conversation, error) {
+	list := []*Conversation{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bc := tx.Bucket([]byte(BucketConversations))
+		return tx.Bucket([]byte(BucketPinnedIndex)).ForEach(func(k, _ []byte) error {
+			buf := bc.Get(k)
+			if buf == nil {
+				return nil
+			}
+			co := NewConversation()
+			if err := decodeConversation(tx, buf, co); err != nil {
+				return err
+			}
+			if query.Match(co) {
+				list = append(list, co)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if query.Reverse {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+	return list, nil
+}
+
+func (s *Store) listConversations(query *ListConversationsQuery) (*ConversationList, error) {
+	if len(query.Tags) > 0 {
+		return s.listConversationsByTags(query)
+	}
+	switch query.Sort {
+	case "", SortById:
+		return s.listConversationsById(query)
+	case SortByCreated:
+		return s.listConversationsByTimeIndex(query, BucketCreatedIndex, query.CreatedSince, query.CreatedUntil)
+	case SortByUpdated:
+		return s.listConversationsByTimeIndex(query, BucketUpdatedIndex, query.UpdatedSince, query.UpdatedUntil)
+	case SortByMessages:
+		return s.listConversationsByMessages(query)
+	default:
+		return nil, fmt.Errorf("invalid sort: %s", query.Sort)
+	}
+}
+
+func (s *Store) listConversationsById(query *ListConversationsQuery) (*ConversationList, error) {
 	l := &ConversationList{
 		query:         query,
 		Conversations: []*Conversation{},
@@ -273,6 +563,20 @@ func (s *Store) ListConversations(query *ListConversationsQuery) (*ConversationL
 	}
 
 	err := s.db.View(func(tx *bolt.Tx) error {
+		// the time indexes narrow the conversations to decode
+		candidates := timeIndexIds(tx, query)
+		tryAppend := func(k, v []byte) error {
+			if candidates != nil && !candidates[btouint64(k)] {
+				return nil
+			}
+			c := NewConversation()
+			if err := decodeConversation(tx, v, c); err != nil {
+				return err
+			}
+			l.TryAppendConversation(c)
+			return nil
+		}
+
 		cursor := tx.Bucket([]byte(BucketConversations)).Cursor()
 		if query.Reverse {
 			if query.Begin != nil {
@@ -291,12 +595,9 @@ func (s *Store) ListConversations(query *ListConversationsQuery) (*ConversationL
 				}
 
 				for ; k != nil; k, v = cursor.Prev() {
-					c := NewConversation()
-					if err := deserialize(v, c); err != nil {
+					if err := tryAppend(k, v); err != nil {
 						return err
 					}
-
-					l.TryAppendConversation(c)
 					if l.IsLimitReached() {
 						break
 					}
@@ -304,12 +605,9 @@ func (s *Store) ListConversations(query *ListConversationsQuery) (*ConversationL
 
 			} else {
 				for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
-					c := NewConversation()
-					if err := deserialize(v, c); err != nil {
+					if err := tryAppend(k, v); err != nil {
 						return err
 					}
-
-					l.TryAppendConversation(c)
 					if l.IsLimitReached() {
 						break
 					}
@@ -327,24 +625,18 @@ func (s *Store) ListConversations(query *ListConversationsQuery) (*ConversationL
 			if query.Begin != nil {
 				begin := uint64tob(*query.Begin)
 				for k, v := cursor.Seek(begin); k != nil; k, v = cursor.Next() {
-					c := NewConversation()
-					if err := deserialize(v, c); err != nil {
+					if err := tryAppend(k, v); err != nil {
 						return err
 					}
-
-					l.TryAppendConversation(c)
 					if l.IsLimitReached() {
 						break
 					}
 				}
 			} else {
 				for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-					c := NewConversation()
-					if err := deserialize(v, c); err != nil {
+					if err := tryAppend(k, v); err != nil {
 						return err
 					}
-
-					l.TryAppendConversation(c)
 					if l.IsLimitReached() {
 						break
 					}
@@ -366,3 +658,237 @@ func (s *Store) ListConversations(query *ListConversationsQuery) (*ConversationL
 
 	return l, err
 }
+
+// listConversationsByTimeIndex lists the conversations in the order of the time index.
+// The since and until parameters are used to narrow the range of the index to scan.
+func (s *Store) listConversationsByTimeIndex(query *ListConversationsQuery, bucket string, since *time.Time, until *time.Time) (*ConversationList, error) {
+	if query.Begin != nil {
+		return nil, fmt.Errorf("begin can be used only with the %s sort", SortById)
+	}
+
+	l := &ConversationList{
+		query:         query,
+		Conversations: []*Conversation{},
+		HasNext:       false,
+	}
+
+	var lower, upper []byte
+	if since != nil {
+		lower = timeIndexKey(*since, 0)
+	}
+	if until != nil {
+		upper = timeIndexKey(*until, ^uint64(0))
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bc := tx.Bucket([]byte(BucketConversations))
+		cursor := tx.Bucket([]byte(bucket)).Cursor()
+
+		var k []byte
+		var next func() ([]byte, []byte)
+		if query.Reverse {
+			if upper != nil {
+				k, _ = cursor.Seek(upper)
+				if k == nil {
+					k, _ = cursor.Last()
+				} else if bytes.Compare(k, upper) > 0 {
+					k, _ = cursor.Prev()
+				}
+			} else {
+				k, _ = cursor.Last()
+			}
+			next = cursor.Prev
+		} else {
+			if lower != nil {
+				k, _ = cursor.Seek(lower)
+			} else {
+				k, _ = cursor.First()
+			}
+			next = cursor.Next
+		}
+
+		for ; k != nil; k, _ = next() {
+			if (lower != nil && bytes.Compare(k, lower) < 0) || (upper != nil && bytes.Compare(k, upper) > 0) {
+				break
+			}
+			buf := bc.Get(k[8:])
+			if buf == nil {
+				continue
+			}
+			c := NewConversation()
+			if err := decodeConversation(tx, buf, c); err != nil {
+				return err
+			}
+			if l.IsLimitReached() {
+				if query.Match(c) {
+					l.HasNext = true
+					break
+				}
+				continue
+			}
+			l.TryAppendConversation(c)
+		}
+
+		l.Count = len(l.Conversations)
+		return nil
+	})
+
+	return l, err
+}
+
+// listConversationsByMessages lists the conversations in the order of the number of messages.
+// It needs to scan all the conversations because there is no index for the number of messages.
+func (s *Store) listConversationsByMessages(query *ListConversationsQuery) (*ConversationList, error) {
+	if query.Begin != nil {
+		return nil, fmt.Errorf("begin can be used only with the %s sort", SortById)
+	}
+
+	var all []*Conversation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BucketConversations)).ForEach(func(k, v []byte) error {
+			c := NewConversation()
+			if err := decodeConversation(tx, v, c); err != nil {
+				return err
+			}
+			if query.Match(c) {
+				all = append(all, c)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if query.Reverse {
+			return len(all[i].Messages) > len(all[j].Messages)
+		}
+		return len(all[i].Messages) < len(all[j].Messages)
+	})
+
+	l := &ConversationList{
+		query:         query,
+		Conversations: []*Conversation{},
+		HasNext:       false,
+	}
+	for _, c := range all {
+		if l.IsLimitReached() {
+			l.HasNext = true
+			break
+		}
+		l.TryAppendConversation(c)
+	}
+	l.Count = len(l.Conversations)
+	return l, nil
+}
+
+// timeIndexIds returns the ids of the conversations in the ranges of the created and updated time filters of the query.
+// It returns nil if the query has no time filter.
+func timeIndexIds(tx *bolt.Tx, query *ListConversationsQuery) map[uint64]bool {
+	var ids map[uint64]bool
+	for _, r := range []struct {
+		bucket string
+		since  *time.Time
+		until  *time.Time
+	}{
+		{BucketCreatedIndex, query.CreatedSince, query.CreatedUntil},
+		{BucketUpdatedIndex, query.UpdatedSince, query.UpdatedUntil},
+	} {
+		if r.since == nil && r.until == nil {
+			continue
+		}
+		var lower, upper []byte
+		if r.since != nil {
+			lower = timeIndexKey(*r.since, 0)
+		}
+		if r.until != nil {
+			upper = timeIndexKey(*r.until, ^uint64(0))
+		}
+		found := map[uint64]bool{}
+		cursor := tx.Bucket([]byte(r.bucket)).Cursor()
+		var k []byte
+		if lower != nil {
+			k, _ = cursor.Seek(lower)
+		} else {
+			k, _ = cursor.First()
+		}
+		for ; k != nil && (upper == nil || bytes.Compare(k, upper) <= 0); k, _ = cursor.Next() {
+			id := btouint64(k[8:])
+			// both filters must be satisfied
+			if ids == nil || ids[id] {
+				found[id] = true
+			}
+		}
+		ids = found
+	}
+	return ids
+}
+
+func timeIndexKey(t time.Time, id uint64) []byte {
+	return append(uint64tob(uint64(t.UnixNano())), uint64tob(id)...)
+}
+
+func putTimeIndexes(tx *bolt.Tx, co *Conversation) error {
+	if err := tx.Bucket([]byte(BucketCreatedIndex)).Put(timeIndexKey(co.CreatedAt, co.Id), []byte{}); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(BucketUpdatedIndex)).Put(timeIndexKey(co.LastUpdatedAt(), co.Id), []byte{})
+}
+
+func deleteTimeIndexes(tx *bolt.Tx, co *Conversation) error {
+	if err := tx.Bucket([]byte(BucketCreatedIndex)).Delete(timeIndexKey(co.CreatedAt, co.Id)); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(BucketUpdatedIndex)).Delete(timeIndexKey(co.LastUpdatedAt(), co.Id))
+}
+
+func putPinnedIndex(tx *bolt.Tx, co *Conversation) error {
+	b := tx.Bucket([]byte(BucketPinnedIndex))
+	if co.Pinned {
+		return b.Put(uint64tob(co.Id), []byte{})
+	}
+	return b.Delete(uint64tob(co.Id))
+}
+
+// rebuildPinnedIndex drops and rebuilds the pinned index in the transaction.
+func rebuildPinnedIndex(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(BucketPinnedIndex)) != nil {
+		if err := tx.DeleteBucket([]byte(BucketPinnedIndex)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.CreateBucket([]byte(BucketPinnedIndex)); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(BucketConversations)).ForEach(func(k, v []byte) error {
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			return err
+		}
+		return putPinnedIndex(tx, co)
+	})
+}
+
+// rebuildTimeIndexes drops and rebuilds the time indexes in the transaction.
+func rebuildTimeIndexes(tx *bolt.Tx) error {
+	for _, name := range []string{BucketCreatedIndex, BucketUpdatedIndex} {
+		if tx.Bucket([]byte(name)) != nil {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket([]byte(BucketConversations)).ForEach(func(k, v []byte) error {
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			return err
+		}
+		return putTimeIndexes(tx, co)
+	})
+}
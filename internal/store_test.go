@@ -2,8 +2,11 @@ package internal
 
 import (
 	"fmt"
+	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
 	"testing"
+	"time"
 )
 
 func TestConversationNotFoundError_Error(t *testing.T) {
@@ -186,6 +189,52 @@ func TestStore_DeleteConversationById(t *testing.T) {
 	})
 }
 
+func TestStore_DeleteConversations(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+
+	err = s.DeleteConversations([]uint64{1, 3})
+	assert.NoError(t, err)
+	l, err := s.ListConversations(&ListConversationsQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, l.Conversations, 1)
+	assert.Equal(t, uint64(2), l.Conversations[0].Id)
+
+	// the whole deletion is rolled back if a conversation is not found
+	err = s.DeleteConversations([]uint64{2, 100})
+	assert.Error(t, err)
+	_, err = s.GetConversationById(2)
+	assert.NoError(t, err)
+}
+
+func TestStore_PruneConversations(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+
+	old := NewConversation()
+	old.CreatedAt = time.Now().Add(-100 * 24 * time.Hour)
+	recent := NewConversation()
+	recent.CreatedAt = time.Now().Add(-1 * time.Hour)
+	pinned := NewConversation()
+	pinned.CreatedAt = time.Now().Add(-100 * 24 * time.Hour)
+	pinned.Pinned = true
+	assert.NoError(t, s.ImportConversations([]*Conversation{old, recent, pinned}, false))
+
+	n, err := s.PruneConversations(time.Now().Add(-90 * 24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = s.GetConversationById(pinned.Id)
+	assert.NoError(t, err)
+
+	_, err = s.GetConversationById(old.Id)
+	assert.IsType(t, &ConversationNotFoundError{}, err)
+	_, err = s.GetConversationById(recent.Id)
+	assert.NoError(t, err)
+}
+
 func TestStore_GetConversationByKey(t *testing.T) {
 	t.Run("get a conversation by numeric (id) key", func(t *testing.T) {
 		sm := testStoreManager(t)
@@ -425,3 +474,170 @@ func reverseConversationsSlice(input []*Conversation) []*Conversation {
 
 	return result
 }
+
+func TestStore_ListConversations_TimeFiltersById(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		for i := 1; i <= 4; i++ {
+			co := NewConversation()
+			co.CreatedAt = time.Date(2023, 5, i, 12, 0, 0, 0, time.UTC)
+			co.UpdatedAt = co.CreatedAt.Add(time.Hour)
+			if err := createConversation(tx, co); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	// break the conversation 1 to make sure that the conversations out of the range are not decoded
+	assert.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BucketConversations)).Put(uint64tob(1), []byte("broken"))
+	}))
+
+	ids := func(l *ConversationList) []uint64 {
+		ret := []uint64{}
+		for _, c := range l.Conversations {
+			ret = append(ret, c.Id)
+		}
+		return ret
+	}
+
+	since := time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2023, 5, 3, 12, 0, 0, 0, time.UTC)
+	l, err := s.ListConversations(&ListConversationsQuery{CreatedSince: &since, CreatedUntil: &until})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2, 3}, ids(l))
+
+	l, err = s.ListConversations(&ListConversationsQuery{CreatedSince: &since, CreatedUntil: &until, UpdatedSince: &since, Reverse: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2}, ids(l))
+
+	l, err = s.ListConversations(&ListConversationsQuery{CreatedSince: &since, UpdatedUntil: &until})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2}, ids(l))
+
+	begin := uint64(3)
+	l, err = s.ListConversations(&ListConversationsQuery{CreatedSince: &since, Begin: &begin})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, ids(l))
+}
+
+func TestStore_ListConversations_PinnedFirst(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		co := NewConversation()
+		co.Pinned = i == 2 || i == 4
+		assert.NoError(t, s.CreateConversation(co))
+	}
+
+	ids := func(l *ConversationList) []uint64 {
+		ret := []uint64{}
+		for _, c := range l.Conversations {
+			ret = append(ret, c.Id)
+		}
+		return ret
+	}
+	list := func(limit int, begin *uint64) *ConversationList {
+		l, err := s.ListConversations(&ListConversationsQuery{PinnedFirst: true, Limit: limit, Begin: begin})
+		assert.NoError(t, err)
+		return l
+	}
+
+	t.Run("the first page is filled with the pinned conversations", func(t *testing.T) {
+		l := list(2, nil)
+		assert.Equal(t, []uint64{2, 4}, ids(l))
+		assert.True(t, l.HasNext)
+		assert.Equal(t, uint64(1), *l.Next)
+
+		l = list(2, l.Next)
+		assert.Equal(t, []uint64{1, 3}, ids(l))
+		assert.True(t, l.HasNext)
+
+		l = list(2, l.Next)
+		assert.Equal(t, []uint64{5}, ids(l))
+		assert.False(t, l.HasNext)
+	})
+
+	t.Run("the pinned conversations are not listed again", func(t *testing.T) {
+		l := list(3, nil)
+		assert.Equal(t, []uint64{2, 4, 1}, ids(l))
+		assert.True(t, l.HasNext)
+
+		l = list(3, l.Next)
+		assert.Equal(t, []uint64{3, 5}, ids(l))
+		assert.False(t, l.HasNext)
+	})
+}
+
+func TestStore_ListConversations_Sort(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		co := NewConversation()
+		co.Name = fmt.Sprintf("test-conversation-%d", i)
+		for j := 0; j < i%3; j++ {
+			co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hello"})
+		}
+		assert.NoError(t, s.CreateConversation(co))
+	}
+
+	// update the conversation 2 so that it becomes the latest updated one
+	co, err := s.GetConversationById(2)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UpdateConversation(co))
+
+	ids := func(l *ConversationList) []uint64 {
+		ret := []uint64{}
+		for _, c := range l.Conversations {
+			ret = append(ret, c.Id)
+		}
+		return ret
+	}
+
+	t.Run("created", func(t *testing.T) {
+		l, err := s.ListConversations(&ListConversationsQuery{Sort: SortByCreated})
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{1, 2, 3, 4, 5}, ids(l))
+
+		l, err = s.ListConversations(&ListConversationsQuery{Sort: SortByCreated, Reverse: true, Limit: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{5, 4}, ids(l))
+		assert.True(t, l.HasNext)
+	})
+
+	t.Run("updated", func(t *testing.T) {
+		l, err := s.ListConversations(&ListConversationsQuery{Sort: SortByUpdated, Reverse: true})
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{2, 5, 4, 3, 1}, ids(l))
+		assert.False(t, l.HasNext)
+
+		since := co.UpdatedAt
+		l, err = s.ListConversations(&ListConversationsQuery{Sort: SortByUpdated, UpdatedSince: &since})
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{2}, ids(l))
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		l, err := s.ListConversations(&ListConversationsQuery{Sort: SortByMessages, Reverse: true})
+		assert.NoError(t, err)
+		assert.Equal(t, []uint64{2, 5, 1, 4, 3}, ids(l))
+	})
+
+	t.Run("begin is not supported", func(t *testing.T) {
+		begin := uint64(1)
+		_, err := s.ListConversations(&ListConversationsQuery{Sort: SortByUpdated, Begin: &begin})
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid sort", func(t *testing.T) {
+		_, err := s.ListConversations(&ListConversationsQuery{Sort: "foo"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,278 @@
+package internal
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"strings"
+)
+
+// BucketTagIndex is an index to look up conversations by tags.
+// Each tag has a nested bucket whose keys are the ids of the conversations with the tag.
+const BucketTagIndex = "tag_index"
+
+// normalizeTags trims the tags and removes the empty and duplicated ones. The result is sorted.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		ret = append(ret, tag)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// AddTags adds the tags to the conversation.
+func (c *Conversation) AddTags(tags ...string) {
+	c.Tags = normalizeTags(append(append([]string{}, c.Tags...), tags...))
+}
+
+// RemoveTags removes the tags from the conversation.
+func (c *Conversation) RemoveTags(tags ...string) {
+	remove := map[string]bool{}
+	for _, tag := range tags {
+		remove[strings.TrimSpace(tag)] = true
+	}
+	ret := []string{}
+	for _, tag := range c.Tags {
+		if !remove[tag] {
+			ret = append(ret, tag)
+		}
+	}
+	c.Tags = ret
+}
+
+// HasTag returns true if the conversation has the tag.
+func (c *Conversation) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMetadata sets the key/value metadata to the conversation. An empty value removes the key.
+func (c *Conversation) SetMetadata(key string, value string) {
+	if value == "" {
+		delete(c.Metadata, key)
+		return
+	}
+	if c.Metadata == nil {
+		c.Metadata = map[string]string{}
+	}
+	c.Metadata[key] = value
+}
+
+// parseMetadataFlags parses the "key=value" strings into a map.
+func parseMetadataFlags(values []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid metadata: %s (it must be in the form of key=value)", v)
+		}
+		m[key] = strings.TrimSpace(value)
+	}
+	return m, nil
+}
+
+// putTagIndex updates the tag index from the old tags to the new tags of the conversation.
+func putTagIndex(tx *bolt.Tx, old []string, co *Conversation) error {
+	bti := tx.Bucket([]byte(BucketTagIndex))
+	id := uint64tob(co.Id)
+	for _, tag := range old {
+		if co.HasTag(tag) {
+			continue
+		}
+		if err := deleteTagIndexEntry(bti, tag, id); err != nil {
+			return err
+		}
+	}
+	for _, tag := range co.Tags {
+		tb, err := bti.CreateBucketIfNotExists([]byte(tag))
+		if err != nil {
+			return err
+		}
+		if err := tb.Put(id, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteTagIndex removes the conversation from the tag index.
+func deleteTagIndex(tx *bolt.Tx, co *Conversation) error {
+	bti := tx.Bucket([]byte(BucketTagIndex))
+	for _, tag := range co.Tags {
+		if err := deleteTagIndexEntry(bti, tag, uint64tob(co.Id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteTagIndexEntry(bti *bolt.Bucket, tag string, id []byte) error {
+	tb := bti.Bucket([]byte(tag))
+	if tb == nil {
+		return nil
+	}
+	if err := tb.Delete(id); err != nil {
+		return err
+	}
+	if k, _ := tb.Cursor().First(); k == nil {
+		// remove the empty tag bucket
+		return bti.DeleteBucket([]byte(tag))
+	}
+	return nil
+}
+
+// rebuildTagIndex drops and rebuilds the tag index in the transaction.
+func rebuildTagIndex(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(BucketTagIndex)) != nil {
+		if err := tx.DeleteBucket([]byte(BucketTagIndex)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.CreateBucket([]byte(BucketTagIndex)); err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(BucketConversations)).ForEach(func(k, v []byte) error {
+		co := NewConversation()
+		if err := decodeConversation(tx, v, co); err != nil {
+			return err
+		}
+		return putTagIndex(tx, nil, co)
+	})
+}
+
+// conversationIdsByTags returns the ids of the conversations that have all the tags in ascending order.
+func conversationIdsByTags(tx *bolt.Tx, tags []string) []uint64 {
+	var ids []uint64
+	for i, tag := range tags {
+		tb := tx.Bucket([]byte(BucketTagIndex)).Bucket([]byte(tag))
+		if tb == nil {
+			return []uint64{}
+		}
+		set := map[uint64]bool{}
+		for _, id := range ids {
+			set[id] = true
+		}
+		var next []uint64
+		_ = tb.ForEach(func(k, _ []byte) error {
+			id := btouint64(k)
+			if i == 0 || set[id] {
+				next = append(next, id)
+			}
+			return nil
+		})
+		ids = next
+		if len(ids) == 0 {
+			return []uint64{}
+		}
+	}
+	return ids
+}
+
+// TagCount is a tag and the number of the conversations with it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ListTags returns all the tags with the number of the conversations.
+func (s *Store) ListTags() ([]*TagCount, error) {
+	tags := []*TagCount{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BucketTagIndex)).ForEach(func(k, _ []byte) error {
+			tb := tx.Bucket([]byte(BucketTagIndex)).Bucket(k)
+			if tb == nil {
+				return nil
+			}
+			tags = append(tags, &TagCount{Tag: string(k), Count: tb.Stats().KeyN})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// listConversationsByTags lists the conversations with the tags by looking up the tag index.
+func (s *Store) listConversationsByTags(query *ListConversationsQuery) (*ConversationList, error) {
+	switch query.Sort {
+	case "", SortById:
+	default:
+		if query.Begin != nil {
+			return nil, fmt.Errorf("begin can be used only with the %s sort", SortById)
+		}
+	}
+
+	var all []*Conversation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bc := tx.Bucket([]byte(BucketConversations))
+		for _, id := range conversationIdsByTags(tx, normalizeTags(query.Tags)) {
+			if query.Begin != nil && ((!query.Reverse && id < *query.Begin) || (query.Reverse && id > *query.Begin)) {
+				continue
+			}
+			buf := bc.Get(uint64tob(id))
+			if buf == nil {
+				continue
+			}
+			co := NewConversation()
+			if err := decodeConversation(tx, buf, co); err != nil {
+				return err
+			}
+			if query.Match(co) {
+				all = append(all, co)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	less := func(i, j int) bool { return all[i].Id < all[j].Id }
+	switch query.Sort {
+	case SortByCreated:
+		less = func(i, j int) bool { return all[i].CreatedAt.Before(all[j].CreatedAt) }
+	case SortByUpdated:
+		less = func(i, j int) bool { return all[i].LastUpdatedAt().Before(all[j].LastUpdatedAt()) }
+	case SortByMessages:
+		less = func(i, j int) bool { return len(all[i].Messages) < len(all[j].Messages) }
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if query.Reverse {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	l := &ConversationList{
+		query:         query,
+		Conversations: []*Conversation{},
+		HasNext:       false,
+	}
+	for _, c := range all {
+		if l.IsLimitReached() {
+			l.HasNext = true
+			if query.Sort == "" || query.Sort == SortById {
+				next := c.Id
+				l.Next = &next
+			}
+			break
+		}
+		l.TryAppendConversation(c)
+	}
+	l.Count = len(l.Conversations)
+	return l, nil
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConversation_Tags(t *testing.T) {
+	co := NewConversation()
+	co.AddTags("web", " nginx ", "", "web")
+	assert.Equal(t, []string{"nginx", "web"}, co.Tags)
+	assert.True(t, co.HasTag("web"))
+
+	co.RemoveTags("web", "unknown")
+	assert.Equal(t, []string{"nginx"}, co.Tags)
+	assert.False(t, co.HasTag("web"))
+
+	co.SetMetadata("ticket", "ABC-123")
+	assert.Equal(t, map[string]string{"ticket": "ABC-123"}, co.Metadata)
+	co.SetMetadata("ticket", "")
+	assert.Equal(t, map[string]string{}, co.Metadata)
+}
+
+func TestParseMetadataFlags(t *testing.T) {
+	m, err := parseMetadataFlags([]string{"ticket=ABC-123", " owner = alice ", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ticket": "ABC-123", "owner": "alice", "empty": ""}, m)
+
+	_, err = parseMetadataFlags([]string{"ticket"})
+	assert.EqualError(t, err, "invalid metadata: ticket (it must be in the form of key=value)")
+	_, err = parseMetadataFlags([]string{"=value"})
+	assert.Error(t, err)
+}
+
+func TestStore_Tags(t *testing.T) {
+	sm := testStoreManager(t)
+	s, err := sm.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+
+	for id, tags := range map[uint64][]string{1: {"nginx", "web"}, 2: {"geo"}, 3: {"nginx"}} {
+		co, err := s.GetConversationById(id)
+		assert.NoError(t, err)
+		co.AddTags(tags...)
+		assert.NoError(t, s.UpdateConversationState(co))
+	}
+
+	listIds := func(t *testing.T, query *ListConversationsQuery) []uint64 {
+		t.Helper()
+		list, err := s.ListConversations(query)
+		assert.NoError(t, err)
+		ids := []uint64{}
+		for _, co := range list.Conversations {
+			ids = append(ids, co.Id)
+		}
+		return ids
+	}
+
+	t.Run("list tags", func(t *testing.T) {
+		tags, err := s.ListTags()
+		assert.NoError(t, err)
+		assert.Equal(t, []*TagCount{{Tag: "geo", Count: 1}, {Tag: "nginx", Count: 2}, {Tag: "web", Count: 1}}, tags)
+	})
+
+	t.Run("list conversations by tags", func(t *testing.T) {
+		assert.Equal(t, []uint64{1, 3}, listIds(t, &ListConversationsQuery{Tags: []string{"nginx"}}))
+		assert.Equal(t, []uint64{1}, listIds(t, &ListConversationsQuery{Tags: []string{"nginx", "web"}}))
+		assert.Equal(t, []uint64{3, 1}, listIds(t, &ListConversationsQuery{Tags: []string{"nginx"}, Reverse: true}))
+		assert.Equal(t, []uint64{}, listIds(t, &ListConversationsQuery{Tags: []string{"unknown"}}))
+
+		list, err := s.ListConversations(&ListConversationsQuery{Tags: []string{"nginx"}, Limit: 1})
+		assert.NoError(t, err)
+		assert.True(t, list.HasNext)
+		assert.Equal(t, uint64(3), *list.Next)
+	})
+
+	t.Run("remove tags", func(t *testing.T) {
+		co, err := s.GetConversationById(1)
+		assert.NoError(t, err)
+		co.RemoveTags("web")
+		assert.NoError(t, s.UpdateConversationState(co))
+		assert.NoError(t, s.DeleteConversationById(3))
+
+		tags, err := s.ListTags()
+		assert.NoError(t, err)
+		assert.Equal(t, []*TagCount{{Tag: "geo", Count: 1}, {Tag: "nginx", Count: 1}}, tags)
+		assert.Equal(t, []uint64{1}, listIds(t, &ListConversationsQuery{Tags: []string{"nginx"}}))
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		co, err := s.GetConversationById(2)
+		assert.NoError(t, err)
+		co.SetMetadata("ticket", "ABC-123")
+		assert.NoError(t, s.UpdateConversationState(co))
+
+		assert.Equal(t, []uint64{2}, listIds(t, &ListConversationsQuery{Metadata: map[string]string{"ticket": "ABC-123"}}))
+		assert.Equal(t, []uint64{}, listIds(t, &ListConversationsQuery{Metadata: map[string]string{"ticket": "ABC-999"}}))
+
+		results, err := s.SearchConversations(&SearchQuery{Query: "tokyo", Metadata: map[string]string{"ticket": "ABC-123"}})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		results, err = s.SearchConversations(&SearchQuery{Query: "tokyo", Tags: []string{"nginx"}})
+		assert.NoError(t, err)
+		assert.Len(t, results, 0)
+	})
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/urfave/cli/v2"
+	"sort"
+)
+
+var TagCommand = &cli.Command{
+	Name:  "tag",
+	Usage: "Manage the tags and metadata of the conversations",
+	Subcommands: []*cli.Command{
+		TagAddCommand,
+		TagListCommand,
+		TagRemoveCommand,
+	},
+}
+
+var TagAddCommand = &cli.Command{
+	Name:      "add",
+	Usage:     "Add tags and metadata to a conversation",
+	ArgsUsage: "[conversation] [tag...]",
+	Description: `Add the tags to the conversation specified by an id or a name.
+Use --meta to set the key/value metadata. For example: gptx tag add --meta ticket=ABC-123 1 incident`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "Set metadata (`key=value`). It can be specified multiple times",
+		},
+	},
+	Action: tagAddAction,
+}
+
+var tagAddAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing conversation argument")
+	}
+	metadata, err := parseMetadataFlags(c.StringSlice("meta"))
+	if err != nil {
+		return err
+	}
+	if c.NArg() == 1 && len(metadata) == 0 {
+		return errors.New("missing tag argument(s) or --meta option")
+	}
+
+	return updateConversationTags(r, c.Args().First(), func(co *Conversation) {
+		co.AddTags(c.Args().Tail()...)
+		for k, v := range metadata {
+			co.SetMetadata(k, v)
+		}
+	})
+})
+
+var TagRemoveCommand = &cli.Command{
+	Name:      "rm",
+	Usage:     "Remove tags and metadata from a conversation",
+	ArgsUsage: "[conversation] [tag...]",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "Remove the metadata with the `key`. It can be specified multiple times",
+		},
+	},
+	Action: tagRemoveAction,
+}
+
+var tagRemoveAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing conversation argument")
+	}
+	keys := c.StringSlice("meta")
+	if c.NArg() == 1 && len(keys) == 0 {
+		return errors.New("missing tag argument(s) or --meta option")
+	}
+
+	return updateConversationTags(r, c.Args().First(), func(co *Conversation) {
+		co.RemoveTags(c.Args().Tail()...)
+		for _, k := range keys {
+			co.SetMetadata(k, "")
+		}
+	})
+})
+
+// updateConversationTags updates the tags and metadata of the conversation without changing its updated time.
+func updateConversationTags(r *Repository, key string, update func(co *Conversation)) error {
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	co, err := store.GetConversationByKey(NewConversationKey(key))
+	if err != nil {
+		return err
+	}
+	update(co)
+	return store.UpdateConversationState(co)
+}
+
+var TagListCommand = &cli.Command{
+	Name:      "ls",
+	Usage:     "List the tags",
+	ArgsUsage: "[conversation]",
+	Description: `List all the tags with the number of the conversations.
+If a conversation is specified, list the tags and metadata of the conversation.`,
+	Action: tagListAction,
+}
+
+var tagListAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if c.NArg() == 0 {
+		tags, err := store.ListTags()
+		if err != nil {
+			return err
+		}
+		t := NewSimpleTableWriter(c.App.Writer)
+		t.AppendHeader(table.Row{"TAG", "CONVERSATIONS"})
+		for _, tag := range tags {
+			t.AppendRow(table.Row{tag.Tag, tag.Count})
+		}
+		t.Render()
+		return nil
+	}
+
+	co, err := store.GetConversationByKey(NewConversationKey(c.Args().First()))
+	if err != nil {
+		return err
+	}
+	t := NewSimpleTableWriter(c.App.Writer)
+	t.AppendHeader(table.Row{"KEY", "VALUE"})
+	for _, tag := range co.Tags {
+		t.AppendRow(table.Row{"tag", tag})
+	}
+	keys := make([]string, 0, len(co.Metadata))
+	for k := range co.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t.AppendRow(table.Row{fmt.Sprintf("meta.%s", k), co.Metadata[k]})
+	}
+	t.Render()
+	return nil
+})
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTagCommand(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+	s, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+	before, err := s.GetConversationById(1)
+	assert.NoError(t, err)
+
+	listIds := func(t *testing.T, args ...string) []uint64 {
+		t.Helper()
+		app.Writer = &bytes.Buffer{}
+		err := app.Run(append([]string{"gptx", "list", "--format", "json"}, args...))
+		assert.NoError(t, err)
+		list := &ConversationList{}
+		assert.NoError(t, json.Unmarshal(app.Writer.(*bytes.Buffer).Bytes(), list))
+		ids := []uint64{}
+		for _, co := range list.Conversations {
+			ids = append(ids, co.Id)
+		}
+		return ids
+	}
+
+	t.Run("missing arguments", func(t *testing.T) {
+		assert.EqualError(t, app.Run([]string{"gptx", "tag", "add"}), "missing conversation argument")
+		assert.EqualError(t, app.Run([]string{"gptx", "tag", "add", "1"}), "missing tag argument(s) or --meta option")
+		assert.Error(t, app.Run([]string{"gptx", "tag", "add", "--meta", "invalid", "1"}))
+	})
+
+	t.Run("add", func(t *testing.T) {
+		assert.NoError(t, app.Run([]string{"gptx", "tag", "add", "--meta", "ticket=ABC-123", "1", "nginx", "web"}))
+		assert.NoError(t, app.Run([]string{"gptx", "tag", "add", "3", "nginx"}))
+
+		assert.Equal(t, []uint64{1, 3}, listIds(t, "--tag", "nginx"))
+		assert.Equal(t, []uint64{1}, listIds(t, "--tag", "nginx", "--tag", "web"))
+		assert.Equal(t, []uint64{1}, listIds(t, "--meta", "ticket=ABC-123"))
+	})
+
+	t.Run("ls", func(t *testing.T) {
+		app.Writer = &bytes.Buffer{}
+		assert.NoError(t, app.Run([]string{"gptx", "tag", "ls"}))
+		assert.Regexp(t, `(?s)TAG\s+CONVERSATIONS\s+nginx\s+2\s+web\s+1`, app.Writer.(*bytes.Buffer).String())
+
+		app.Writer = &bytes.Buffer{}
+		assert.NoError(t, app.Run([]string{"gptx", "tag", "ls", "1"}))
+		assert.Regexp(t, `(?s)tag\s+nginx\s+tag\s+web\s+meta\.ticket\s+ABC-123`, app.Writer.(*bytes.Buffer).String())
+	})
+
+	t.Run("search", func(t *testing.T) {
+		app.Writer = &bytes.Buffer{}
+		assert.NoError(t, app.Run([]string{"gptx", "search", "--format", "json", "--tag", "web", "nginx"}))
+		results := []*SearchResult{}
+		assert.NoError(t, json.Unmarshal(app.Writer.(*bytes.Buffer).Bytes(), &results))
+		assert.Len(t, results, 1)
+		assert.Equal(t, uint64(1), results[0].Id)
+	})
+
+	t.Run("rm", func(t *testing.T) {
+		assert.NoError(t, app.Run([]string{"gptx", "tag", "rm", "--meta", "ticket", "1", "web"}))
+		assert.Equal(t, []uint64{}, listIds(t, "--tag", "web"))
+		assert.Equal(t, []uint64{}, listIds(t, "--meta", "ticket=ABC-123"))
+		assert.Equal(t, []uint64{1, 3}, listIds(t, "--tag", "nginx"))
+	})
+
+	t.Run("tags do not change the updated time", func(t *testing.T) {
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		after, err := s.GetConversationById(1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"nginx"}, after.Tags)
+		assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt))
+	})
+}
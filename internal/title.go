@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+// maxTitleLength is the maximum number of characters of a title.
+const maxTitleLength = 80
+
+// titleInstruction is the system message to ask the model for a title of a conversation.
+const titleInstruction = "Generate a short title (at most 8 words) that describes the following conversation. Reply with the title only, without quotes or punctuation at the end."
+
+// DisplayTitle returns the title of the conversation.
+// If the conversation does not have a title, the first line of the prompt is returned instead.
+func (c *Conversation) DisplayTitle() string {
+	if c.Title != "" {
+		return c.Title
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(c.Prompt), "\n")
+	return strings.TrimSpace(line)
+}
+
+// titleTranscript returns the first exchange of the conversation as a text to generate a title from.
+func titleTranscript(co *Conversation) string {
+	var b strings.Builder
+	var user, assistant bool
+	for _, m := range co.Messages {
+		switch {
+		case m.Role == openai.ChatMessageRoleUser && !user:
+			user = true
+			b.WriteString("User: " + truncateChars(m.Content, 2000) + "\n")
+		case m.Role == openai.ChatMessageRoleAssistant && user && !assistant:
+			assistant = true
+			b.WriteString("Assistant: " + truncateChars(m.Content, 2000) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// cleanTitle normalizes a title generated by the model.
+func cleanTitle(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) > 6 && strings.EqualFold(line[:6], "title:") {
+			line = strings.TrimSpace(line[6:])
+		}
+		line = strings.Trim(line, "\"'`*")
+		line = strings.TrimRight(line, ".")
+		return truncateChars(strings.TrimSpace(line), maxTitleLength)
+	}
+	return ""
+}
+
+// GenerateTitle asks the model for a short title of the conversation.
+// The request is not cached because titles are generated only once for each conversation.
+func (c *ChatService) GenerateTitle(ctx context.Context, co *Conversation, model string) (string, error) {
+	transcript := titleTranscript(co)
+	if transcript == "" {
+		return "", errors.New("the conversation has no messages to generate a title from")
+	}
+	if model == "" {
+		model = co.Model
+	}
+	if model == "" {
+		model = c.Model
+	}
+
+	messages, rd := c.redactMessages([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: titleInstruction},
+		{Role: openai.ChatMessageRoleUser, Content: transcript},
+	})
+
+	resp, err := c.createChatCompletion(ctx, newAuditEntry(AuditKindTitle, AuditCacheOff, co), openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: messages,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no title was generated")
+	}
+	title := cleanTitle(c.restoreRedacted(rd, resp.Choices[0].Message.Content))
+	if title == "" {
+		return "", fmt.Errorf("the model returned an empty title")
+	}
+	return title, nil
+}
+
+// autoTitle generates and saves the title of the conversation after its first exchange.
+// Titling is best effort, so the conversation is kept untitled if it fails, and a warning is written to ErrWriter.
+func (c *ChatService) autoTitle() {
+	co := c.Conversation
+	if !c.AutoTitle || c.OnMemory || co.Title != "" {
+		return
+	}
+	if err := c.saveTitle(co); err != nil && c.ErrWriter != nil {
+		_, _ = fmt.Fprintf(c.ErrWriter, "warning: failed to generate the title of the conversation: %v\n", err)
+	}
+}
+
+func (c *ChatService) saveTitle(co *Conversation) error {
+	title, err := c.GenerateTitle(context.Background(), co, c.TitleModel)
+	if err != nil {
+		return err
+	}
+	co.Title = title
+
+	store, err := c.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.UpdateConversationState(co)
+}
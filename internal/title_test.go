@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestConversation_DisplayTitle(t *testing.T) {
+	co := NewConversation()
+	co.Prompt = "  panic: runtime error\ngoroutine 1 [running]:"
+	assert.Equal(t, "panic: runtime error", co.DisplayTitle())
+	co.Title = "Go runtime panic"
+	assert.Equal(t, "Go runtime panic", co.DisplayTitle())
+}
+
+func TestCleanTitle(t *testing.T) {
+	assert.Equal(t, "Nginx reverse proxy setup", cleanTitle("\n\"Nginx reverse proxy setup.\"\n"))
+	assert.Equal(t, "Capital of Japan", cleanTitle("Title: Capital of Japan\nThe conversation is about..."))
+	assert.Equal(t, "", cleanTitle(" \n "))
+	assert.Len(t, cleanTitle(strings.Repeat("a", 200)), maxTitleLength)
+}
+
+func TestTitleTranscript(t *testing.T) {
+	co := testExportConversation()
+	co.AddMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "Thanks"})
+	assert.Equal(t, "User: How do I configure nginx?\nAssistant: Use <proxy_pass>.\n", titleTranscript(co))
+	assert.Equal(t, "", titleTranscript(NewConversation()))
+}
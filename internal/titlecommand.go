@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"strings"
+)
+
+var TitleCommand = &cli.Command{
+	Name:      "title",
+	Usage:     "Display or set the title of a conversation",
+	ArgsUsage: "[conversation] [text...]",
+	Description: `Display the title of the conversation specified by an id or a name.
+If the text is specified, it is set as the title. Use --regenerate to ask the model for a new title.
+Titles are generated automatically after the first exchange if 'auto' is enabled in the [title] section of the config.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:               "regenerate",
+			Aliases:            []string{"r"},
+			Usage:              "Generate a new title with the model",
+			DisableDefaultText: true,
+		},
+		&cli.StringFlag{
+			Name:  "model",
+			Usage: "Specify a `model` to generate the title. The default is the title model in the config or the model of the conversation",
+		},
+		&cli.BoolFlag{
+			Name:               "clear",
+			Usage:              "Remove the title",
+			DisableDefaultText: true,
+		},
+		&cli.BoolFlag{
+			Name:               "no-loading",
+			Usage:              "Disable loading animation",
+			DisableDefaultText: true,
+		},
+	},
+	Action: titleAction,
+}
+
+var titleAction = repositoryAwareAction(func(c *cli.Context, r *Repository) error {
+	if c.NArg() == 0 {
+		return errors.New("missing conversation argument")
+	}
+	text := strings.TrimSpace(strings.Join(c.Args().Tail(), " "))
+	regenerate := c.Bool("regenerate")
+	clearTitle := c.Bool("clear")
+	if (text != "" && regenerate) || (text != "" && clearTitle) || (regenerate && clearTitle) {
+		return errors.New("text, --regenerate and --clear are mutually exclusive")
+	}
+
+	store, err := r.StoreManager.Open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	co, err := store.GetConversationByKey(NewConversationKey(c.Args().First()))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case text != "":
+		co.Title = truncateChars(text, maxTitleLength)
+	case clearTitle:
+		co.Title = ""
+	case regenerate:
+		sv, err := r.NewChatService(c.App.Writer, c.App.ErrWriter)
+		if err != nil {
+			return err
+		}
+		sv.NoLoading = c.Bool("no-loading")
+		model := c.String("model")
+		if model == "" {
+			model = sv.TitleModel
+		}
+		sv.Model = r.Config.Model
+		title, err := sv.GenerateTitle(context.Background(), co, model)
+		if err != nil {
+			return err
+		}
+		co.Title = title
+	default:
+		_, _ = fmt.Fprintln(c.App.Writer, co.Title)
+		return nil
+	}
+
+	if err := store.UpdateConversationState(co); err != nil {
+		return err
+	}
+	if co.Title != "" {
+		_, _ = fmt.Fprintln(c.App.Writer, co.Title)
+	}
+	return nil
+})
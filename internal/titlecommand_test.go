@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/kohkimakimoto/gptx/mockserver"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTitleCommand(t *testing.T) {
+	app := testNewApp(t)
+	r, err := getRepository(app)
+	assert.NoError(t, err)
+	s, err := r.StoreManager.Open()
+	assert.NoError(t, err)
+	testCreateSearchConversations(t, s)
+	before, err := s.GetConversationById(1)
+	assert.NoError(t, err)
+
+	ms, err := mockserver.New(&mockserver.Script{
+		Responses: []*mockserver.Response{
+			{Match: `^User: How do I configure nginx`, Model: "title-model", Content: "\"Nginx reverse proxy.\""},
+		},
+	})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(ms)
+	defer ts.Close()
+	r.ClientConfig.BaseURL = ts.URL + "/v1"
+
+	title := func(t *testing.T, args ...string) string {
+		t.Helper()
+		app.Writer = &bytes.Buffer{}
+		assert.NoError(t, app.Run(append([]string{"gptx", "title"}, args...)))
+		return app.Writer.(*bytes.Buffer).String()
+	}
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		assert.EqualError(t, app.Run([]string{"gptx", "title"}), "missing conversation argument")
+		assert.EqualError(t, app.Run([]string{"gptx", "title", "--clear", "1", "text"}), "text, --regenerate and --clear are mutually exclusive")
+	})
+
+	t.Run("set and clear", func(t *testing.T) {
+		assert.Equal(t, "\n", title(t, "1"))
+		assert.Equal(t, "Nginx as a proxy\n", title(t, "1", "Nginx", "as", "a", "proxy"))
+		assert.Equal(t, "Nginx as a proxy\n", title(t, "1"))
+		assert.Equal(t, "", title(t, "--clear", "1"))
+		assert.Equal(t, "\n", title(t, "1"))
+	})
+
+	t.Run("regenerate", func(t *testing.T) {
+		assert.Equal(t, "Nginx reverse proxy\n", title(t, "--regenerate", "--no-loading", "--model", "title-model", "1"))
+		reqs := ms.Requests()
+		assert.Len(t, reqs, 1)
+		assert.Equal(t, "title-model", reqs[0].Model)
+		assert.Equal(t, titleInstruction, reqs[0].Messages[0].Content)
+
+		s, err := r.StoreManager.Open()
+		assert.NoError(t, err)
+		after, err := s.GetConversationById(1)
+		assert.NoError(t, err)
+		assert.Equal(t, "Nginx reverse proxy", after.Title)
+		assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt))
+	})
+}
@@ -1,12 +1,15 @@
 package internal
 
 import (
+	"fmt"
 	"github.com/mattn/go-isatty"
 	"github.com/urfave/cli/v2"
 	"io"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // getUint64ValueFromStringFlag returns the uint64 value of a string flag.
@@ -69,3 +72,90 @@ func equalStringSlice(a, b []string) bool {
 
 	return true
 }
+
+var relativeTimeRegex = regexp.MustCompile(`^(\d+)([smhdw])$`)
+
+// parseDurationSpec parses a duration such as "30m", "12h", "7d" or "2w".
+// Go durations such as "1h30m" are also accepted.
+func parseDurationSpec(s string) (time.Duration, error) {
+	if m := relativeTimeRegex.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		unit := map[string]time.Duration{
+			"s": time.Second,
+			"m": time.Minute,
+			"h": time.Hour,
+			"d": 24 * time.Hour,
+			"w": 7 * 24 * time.Hour,
+		}[m[2]]
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s (expected a duration like 30m, 12h or 7d)", s)
+	}
+	return d, nil
+}
+
+// parseTimeSpec parses a time specification.
+// It accepts a relative duration from now (e.g. "30m", "12h", "7d", "2w"),
+// a date ("2006-01-02") or an RFC3339 timestamp.
+func parseTimeSpec(s string, now time.Time) (time.Time, error) {
+	if relativeTimeRegex.MatchString(s) {
+		d, err := parseDurationSpec(s)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s (expected a duration like 7d, a date like 2006-01-02 or an RFC3339 timestamp)", s)
+}
+
+// parseUntilTimeSpec parses the upper bound of a time range like parseTimeSpec.
+// A date-only value means the end of the day, so that the whole day is included.
+func parseUntilTimeSpec(s string, now time.Time) (time.Time, error) {
+	t, err := parseTimeSpec(s, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// getTimeValueFromStringFlag returns the time value of a string flag parsed by parseTimeSpec.
+// It returns nil if the flag is not specified.
+func getTimeValueFromStringFlag(c *cli.Context, flagName string) (*time.Time, error) {
+	v := c.String(flagName)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := parseTimeSpec(v, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for flag %s: %w", flagName, err)
+	}
+	return &t, nil
+}
+
+// getUntilTimeValueFromStringFlag returns the time value of a string flag parsed by parseUntilTimeSpec.
+// It returns nil if the flag is not specified.
+func getUntilTimeValueFromStringFlag(c *cli.Context, flagName string) (*time.Time, error) {
+	v := c.String(flagName)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := parseUntilTimeSpec(v, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for flag %s: %w", flagName, err)
+	}
+	return &t, nil
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/urfave/cli/v2"
 	"testing"
+	"time"
 )
 
 func TestGetUint64ValueFromStringFlag(t *testing.T) {
@@ -136,3 +137,76 @@ func TestEqualStringSlice(t *testing.T) {
 		assert.Equal(t, tt.expected, equalStringSlice(tt.input1, tt.input2))
 	}
 }
+
+func TestParseTimeSpec(t *testing.T) {
+	now := time.Date(2023, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input    string
+		expected time.Time
+		hasError bool
+	}{
+		{input: "30m", expected: now.Add(-30 * time.Minute)},
+		{input: "12h", expected: now.Add(-12 * time.Hour)},
+		{input: "7d", expected: now.Add(-7 * 24 * time.Hour)},
+		{input: "2w", expected: now.Add(-14 * 24 * time.Hour)},
+		{input: "2023-05-01", expected: time.Date(2023, 5, 1, 0, 0, 0, 0, time.Local)},
+		{input: "2023-05-01T10:00:00Z", expected: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{input: "yesterday", hasError: true},
+		{input: "7y", hasError: true},
+	}
+
+	for _, tt := range tests {
+		ret, err := parseTimeSpec(tt.input, now)
+		if tt.hasError {
+			assert.Error(t, err, tt.input)
+		} else {
+			assert.NoError(t, err, tt.input)
+			assert.True(t, tt.expected.Equal(ret), tt.input)
+		}
+	}
+}
+
+func TestParseUntilTimeSpec(t *testing.T) {
+	now := time.Date(2023, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	// a date-only value includes the whole day
+	ret, err := parseUntilTimeSpec("2023-05-01", now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2023, 5, 1, 23, 59, 59, 999999999, time.Local).Equal(ret))
+
+	ret, err = parseUntilTimeSpec("2023-05-01T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC).Equal(ret))
+
+	ret, err = parseUntilTimeSpec("12h", now)
+	assert.NoError(t, err)
+	assert.True(t, now.Add(-12*time.Hour).Equal(ret))
+
+	_, err = parseUntilTimeSpec("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestParseDurationSpec(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		hasError bool
+	}{
+		{input: "30m", expected: 30 * time.Minute},
+		{input: "7d", expected: 7 * 24 * time.Hour},
+		{input: "2w", expected: 14 * 24 * time.Hour},
+		{input: "1h30m", expected: 90 * time.Minute},
+		{input: "7y", hasError: true},
+		{input: "", hasError: true},
+	}
+
+	for _, tt := range tests {
+		ret, err := parseDurationSpec(tt.input)
+		if tt.hasError {
+			assert.Error(t, err, tt.input)
+		} else {
+			assert.NoError(t, err, tt.input)
+			assert.Equal(t, tt.expected, ret, tt.input)
+		}
+	}
+}
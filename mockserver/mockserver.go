@@ -0,0 +1,373 @@
+// Package mockserver provides a fake OpenAI Chat API server that returns scripted responses.
+// It also serves the embeddings API with embeddings computed from the words of the input, so that the texts
+// with the same words have the same embedding.
+//
+// It is intended for testing hooks and custom subcommands end-to-end without access to the real API.
+// A Server is an http.Handler, so it can be used with net/http/httptest in Go tests:
+//
+//	script, _ := mockserver.LoadScript("responses.toml")
+//	srv, _ := mockserver.New(script)
+//	ts := httptest.NewServer(srv)
+//	defer ts.Close()
+//	// point the OpenAI client's base URL at ts.URL + "/v1"
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/sashabaranov/go-openai"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Script is a set of scripted responses.
+type Script struct {
+	Responses []*Response `toml:"responses" json:"responses"`
+}
+
+// Response is a scripted response.
+// The first response whose conditions match the request is returned.
+type Response struct {
+	Match   string `toml:"match" json:"match,omitempty"`     // A regular expression matched against the last user message. An empty value matches any message.
+	Model   string `toml:"model" json:"model,omitempty"`     // If set, the response is used only for requests with this model.
+	Content string `toml:"content" json:"content,omitempty"` // The content of the assistant message.
+	Status  int    `toml:"status" json:"status,omitempty"`   // The HTTP status code. A non-2xx status returns an OpenAI style error.
+	Error   string `toml:"error" json:"error,omitempty"`     // The error message returned with a non-2xx status.
+	Delay   string `toml:"delay" json:"delay,omitempty"`     // The delay before responding (e.g. "500ms").
+	re      *regexp.Regexp
+	delay   time.Duration
+}
+
+// LoadScript loads a script from a TOML or JSON file.
+// The format is determined by the file extension. Files without the ".json" extension are parsed as TOML.
+func LoadScript(path string) (*Script, error) {
+	s := &Script{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, s); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := toml.DecodeFile(path, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Server is a fake OpenAI API server.
+// If no scripted response matches a request, the server echoes back the last user message.
+type Server struct {
+	script            *Script
+	requests          []openai.ChatCompletionRequest
+	embeddingRequests []*EmbeddingRequest
+	lock              sync.Mutex
+}
+
+// EmbeddingRequest is a request to the embeddings API.
+// The model is a string unlike openai.EmbeddingRequest, so that any model can be requested.
+type EmbeddingRequest struct {
+	Input []string `json:"input"`
+	Model string   `json:"model"`
+}
+
+// EmbeddingDimensions is the number of the dimensions of the embeddings returned by the server.
+const EmbeddingDimensions = 64
+
+// New creates a new Server with the script. The script can be nil.
+func New(script *Script) (*Server, error) {
+	if script == nil {
+		script = &Script{}
+	}
+	for i, resp := range script.Responses {
+		if resp.Match != "" {
+			re, err := regexp.Compile(resp.Match)
+			if err != nil {
+				return nil, fmt.Errorf("invalid match of responses[%d]: %w", i, err)
+			}
+			resp.re = re
+		}
+		if resp.Delay != "" {
+			d, err := time.ParseDuration(resp.Delay)
+			if err != nil {
+				return nil, fmt.Errorf("invalid delay of responses[%d]: %w", i, err)
+			}
+			resp.delay = d
+		}
+	}
+	return &Server{
+		script: script,
+	}, nil
+}
+
+// Requests returns the chat completion requests that the server has received.
+func (s *Server) Requests() []openai.ChatCompletionRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]openai.ChatCompletionRequest, len(s.requests))
+	copy(ret, s.requests)
+	return ret
+}
+
+// EmbeddingRequests returns the embedding requests that the server has received.
+func (s *Server) EmbeddingRequests() []*EmbeddingRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]*EmbeddingRequest, len(s.embeddingRequests))
+	copy(ret, s.embeddingRequests)
+	return ret
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		s.handleChatCompletions(w, r)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/embeddings"):
+		s.handleEmbeddings(w, r)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown endpoint: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	req := openai.ChatCompletionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	s.requests = append(s.requests, req)
+	s.lock.Unlock()
+
+	prompt := lastUserMessage(req.Messages)
+	resp := s.findResponse(req.Model, prompt)
+	content := prompt
+	if resp != nil {
+		if resp.delay > 0 {
+			time.Sleep(resp.delay)
+		}
+		if resp.Status != 0 && (resp.Status < 200 || resp.Status >= 300) {
+			writeError(w, resp.Status, resp.Error)
+			return
+		}
+		content = resp.Content
+	}
+
+	if req.Stream {
+		writeStream(w, req.Model, content)
+		return
+	}
+
+	promptTokens := 0
+	for _, m := range req.Messages {
+		promptTokens += countTokens(m.Content)
+	}
+	completionTokens := countTokens(content)
+	writeJSON(w, http.StatusOK, openai.ChatCompletionResponse{
+		ID:      newID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Index: 0,
+				Message: openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: content,
+				},
+				FinishReason: "stop",
+			},
+		},
+		Usage: openai.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	})
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	req := &EmbeddingRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Input) == 0 {
+		writeError(w, http.StatusBadRequest, "input is required")
+		return
+	}
+
+	s.lock.Lock()
+	s.embeddingRequests = append(s.embeddingRequests, req)
+	s.lock.Unlock()
+
+	data := make([]map[string]interface{}, 0, len(req.Input))
+	tokens := 0
+	for i, input := range req.Input {
+		data = append(data, map[string]interface{}{
+			"object":    "embedding",
+			"embedding": Embed(input),
+			"index":     i,
+		})
+		tokens += countTokens(input)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage": openai.Usage{
+			PromptTokens: tokens,
+			TotalTokens:  tokens,
+		},
+	})
+}
+
+// Embed returns the embedding of the text that the server returns.
+// It is a normalized bag of the lower-cased words hashed into EmbeddingDimensions dimensions,
+// so the texts that differ only in cases, punctuations and whitespaces have the same embedding.
+func Embed(text string) []float32 {
+	v := make([]float32, EmbeddingDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(word))
+		v[h.Sum32()%EmbeddingDimensions]++
+	}
+	var norm float64
+	for _, x := range v {
+		norm += float64(x * x)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range v {
+			v[i] = float32(float64(v[i]) / norm)
+		}
+	}
+	return v
+}
+
+func (s *Server) findResponse(model string, prompt string) *Response {
+	for _, resp := range s.script.Responses {
+		if resp.Model != "" && resp.Model != model {
+			continue
+		}
+		if resp.re != nil && !resp.re.MatchString(prompt) {
+			continue
+		}
+		return resp
+	}
+	return nil
+}
+
+func writeStream(w http.ResponseWriter, model string, content string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	id := newID()
+	created := time.Now().Unix()
+	chunks := append(splitChunks(content), "")
+	for i, chunk := range chunks {
+		choice := openai.ChatCompletionStreamChoice{
+			Index: 0,
+			Delta: openai.ChatCompletionStreamChoiceDelta{Content: chunk},
+		}
+		if i == len(chunks)-1 {
+			choice.FinishReason = "stop"
+		}
+		b, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []openai.ChatCompletionStreamChoice{choice},
+		})
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "mock_error",
+		},
+	})
+}
+
+func lastUserMessage(messages []openai.ChatCompletionMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// splitChunks splits the content into chunks that keep their trailing whitespaces,
+// so that concatenating the chunks restores the original content.
+func splitChunks(content string) []string {
+	var chunks []string
+	start := 0
+	for i := 1; i < len(content); i++ {
+		if content[i-1] == ' ' || content[i-1] == '\n' {
+			if content[i] != ' ' && content[i] != '\n' {
+				chunks = append(chunks, content[start:i])
+				start = i
+			}
+		}
+	}
+	if start < len(content) {
+		chunks = append(chunks, content[start:])
+	}
+	return chunks
+}
+
+// countTokens roughly counts tokens by words. It is not accurate but enough for the mock.
+func countTokens(s string) int {
+	return len(strings.Fields(s))
+}
+
+var idLock sync.Mutex
+var idSeq int
+
+func newID() string {
+	idLock.Lock()
+	defer idLock.Unlock()
+	idSeq++
+	return fmt.Sprintf("chatcmpl-mock-%d", idSeq)
+}
@@ -0,0 +1,164 @@
+package mockserver
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testClient(t *testing.T, s *Server) *openai.Client {
+	t.Helper()
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	config := openai.DefaultConfig("sk-dummykey")
+	config.BaseURL = ts.URL + "/v1"
+	return openai.NewClientWithConfig(config)
+}
+
+func TestServer_ChatCompletion(t *testing.T) {
+	s, err := New(&Script{
+		Responses: []*Response{
+			{Match: `(?i)capital of japan`, Content: "Tokyo"},
+			{Match: `fail`, Status: 500, Error: "boom"},
+			{Model: "gpt-4", Content: "I am gpt-4"},
+		},
+	})
+	assert.NoError(t, err)
+	client := testClient(t, s)
+
+	t.Run("matched", func(t *testing.T) {
+		resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:    openai.GPT3Dot5Turbo,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "What is the capital of Japan?"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "Tokyo", resp.Choices[0].Message.Content)
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:    openai.GPT3Dot5Turbo,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", resp.Choices[0].Message.Content)
+	})
+
+	t.Run("model", func(t *testing.T) {
+		resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:    openai.GPT4,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "who are you?"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "I am gpt-4", resp.Choices[0].Message.Content)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:    openai.GPT3Dot5Turbo,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "please fail"}},
+		})
+		assert.Error(t, err)
+		apiErr := &openai.APIError{}
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, 500, apiErr.StatusCode)
+		assert.Equal(t, "boom", apiErr.Message)
+	})
+
+	assert.Len(t, s.Requests(), 4)
+}
+
+func TestServer_ChatCompletionStream(t *testing.T) {
+	s, err := New(&Script{
+		Responses: []*Response{
+			{Content: "Hello there, how may I assist you today?"},
+		},
+	})
+	assert.NoError(t, err)
+	client := testClient(t, s)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:    openai.GPT3Dot5Turbo,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello!"}},
+	})
+	assert.NoError(t, err)
+	defer stream.Close()
+
+	content := ""
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		content += resp.Choices[0].Delta.Content
+	}
+	assert.Equal(t, "Hello there, how may I assist you today?", content)
+}
+
+func TestServer_Embeddings(t *testing.T) {
+	s, err := New(nil)
+	assert.NoError(t, err)
+	client := testClient(t, s)
+
+	resp, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+		Input: []string{"What is the capital of Japan?", "what is the capital of japan", "Tell me a joke"},
+		Model: openai.AdaEmbeddingV2,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Data, 3)
+	assert.Len(t, resp.Data[0].Embedding, EmbeddingDimensions)
+	assert.Equal(t, resp.Data[0].Embedding, resp.Data[1].Embedding)
+	assert.NotEqual(t, resp.Data[0].Embedding, resp.Data[2].Embedding)
+
+	reqs := s.EmbeddingRequests()
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "text-embedding-ada-002", reqs[0].Model)
+}
+
+func TestLoadScript(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("toml", func(t *testing.T) {
+		path := filepath.Join(dir, "responses.toml")
+		err := os.WriteFile(path, []byte(`
+[[responses]]
+match = "hello"
+content = "Hi!"
+delay = "10ms"
+`), 0600)
+		assert.NoError(t, err)
+
+		s, err := LoadScript(path)
+		assert.NoError(t, err)
+		assert.Len(t, s.Responses, 1)
+		assert.Equal(t, "hello", s.Responses[0].Match)
+		assert.Equal(t, "Hi!", s.Responses[0].Content)
+		assert.Equal(t, "10ms", s.Responses[0].Delay)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(dir, "responses.json")
+		err := os.WriteFile(path, []byte(`{"responses": [{"match": "hello", "content": "Hi!"}]}`), 0600)
+		assert.NoError(t, err)
+
+		s, err := LoadScript(path)
+		assert.NoError(t, err)
+		assert.Len(t, s.Responses, 1)
+		assert.Equal(t, "Hi!", s.Responses[0].Content)
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&Script{Responses: []*Response{{Match: "("}}})
+	assert.Error(t, err)
+
+	_, err = New(&Script{Responses: []*Response{{Delay: "soon"}}})
+	assert.Error(t, err)
+}